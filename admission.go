@@ -0,0 +1,277 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	"github.com/libp2p/go-libp2p-kad-dht/internal/metrics"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// admissionPruneInterval is the minimum time between two sweeps of the
+// expired records and idle rate limiters kept by admission control, as well as
+// between two counts of the provider records held for the storage budget.
+const admissionPruneInterval = time.Minute
+
+// Reasons reported when rejecting a request.
+const (
+	rejectRateLimit     = "rate_limit"
+	rejectPeerQuota     = "peer_quota"
+	rejectStorageBudget = "storage_budget"
+)
+
+var (
+	errRateLimited          = errors.New("rate limit exceeded")
+	errPeerQuotaExceeded    = errors.New("peer storage quota exceeded")
+	errStorageBudgetReached = errors.New("storage budget exhausted")
+)
+
+// tokenBucket is a token bucket rate limiter, it is not thread safe.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket according to the time elapsed since the last call
+// and takes a token if there is one.
+func (b *tokenBucket) take(lim dhtcfg.RateLimit, now time.Time) bool {
+	b.refill(lim, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(lim dhtcfg.RateLimit, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * lim.Rate
+	b.tokens = min(b.tokens, float64(lim.Burst))
+	b.last = now
+}
+
+type rateLimitKey struct {
+	t pb.Message_MessageType
+	p peer.ID
+}
+
+// peerUsage holds the expiration time of the records stored by a peer, by
+// key.
+type peerUsage struct {
+	records   map[string]time.Time
+	providers map[string]time.Time
+}
+
+// providerCounter is implemented by the provider stores that count the
+// provider records they hold, like providers.ProviderManager.
+type providerCounter interface {
+	CountProviders(ctx context.Context) (int, error)
+}
+
+// admission limits the rate of inbound requests and the number of records
+// remote peers can store on this node. The per peer quotas are only kept in
+// memory, and only for the kinds of records they apply to. The storage budget
+// follows the count of provider records held by the provider store.
+type admission struct {
+	maxRecordsPerPeer   int
+	maxProvidersPerPeer int
+	maxStored           int
+	recordTTL           time.Duration
+	providerTTL         time.Duration
+	rateLimits          map[pb.Message_MessageType]dhtcfg.RateLimit
+	providers           providerCounter
+
+	lk      sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+	usage   map[peer.ID]*peerUsage
+	// stored is the number of provider records held as of storedAt, plus the
+	// ones admitted since.
+	stored    int
+	storedAt  time.Time
+	lastPrune time.Time
+}
+
+// newAdmission returns the admission control configured in cfg, or nil if no
+// limit is set. The storage budget is checked against the records counted by
+// ps.
+func newAdmission(cfg dhtcfg.Config, providerTTL time.Duration, ps providers.ProviderStore) (*admission, error) {
+	c := cfg.Admission
+	if c.MaxRecordsPerPeer == 0 && c.MaxProvidersPerPeer == 0 && c.MaxStoredRecords == 0 && len(c.RateLimits) == 0 {
+		return nil, nil
+	}
+	var counter providerCounter
+	if c.MaxStoredRecords > 0 {
+		var ok bool
+		if counter, ok = ps.(providerCounter); !ok {
+			return nil, fmt.Errorf("the storage budget needs a provider store counting its records, %T doesn't", ps)
+		}
+	}
+	return &admission{
+		maxRecordsPerPeer:   c.MaxRecordsPerPeer,
+		maxProvidersPerPeer: c.MaxProvidersPerPeer,
+		maxStored:           c.MaxStoredRecords,
+		recordTTL:           cfg.MaxRecordAge,
+		providerTTL:         providerTTL,
+		rateLimits:          c.RateLimits,
+		providers:           counter,
+		buckets:             make(map[rateLimitKey]*tokenBucket),
+		usage:               make(map[peer.ID]*peerUsage),
+		lastPrune:           time.Now(),
+	}, nil
+}
+
+// allow takes a token from the rate limiter of the message type for p.
+func (a *admission) allow(ctx context.Context, t pb.Message_MessageType, p peer.ID) error {
+	lim, ok := a.rateLimits[t]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.maybePrune(now)
+
+	k := rateLimitKey{t: t, p: p}
+	b, ok := a.buckets[k]
+	if !ok {
+		b = &tokenBucket{tokens: float64(lim.Burst), last: now}
+		a.buckets[k] = b
+	}
+	if !b.take(lim, now) {
+		metrics.RecordRequestRejected(ctx, rejectRateLimit)
+		return errRateLimited
+	}
+	return nil
+}
+
+// admitRecord accounts for a value record stored by p under key, unless it
+// would exceed the quota of p or the storage budget.
+func (a *admission) admitRecord(ctx context.Context, p peer.ID, key string) error {
+	return a.admit(ctx, p, key, false)
+}
+
+// admitProvider accounts for a provider record of p for key, unless it would
+// exceed the quota of p or the storage budget.
+func (a *admission) admitProvider(ctx context.Context, p peer.ID, key string) error {
+	return a.admit(ctx, p, key, true)
+}
+
+func (a *admission) admit(ctx context.Context, p peer.ID, key string, provider bool) error {
+	limit, ttl := a.maxRecordsPerPeer, a.recordTTL
+	if provider {
+		limit, ttl = a.maxProvidersPerPeer, a.providerTTL
+	}
+	budget := provider && a.maxStored > 0
+	if limit == 0 && !budget {
+		return nil
+	}
+
+	now := time.Now()
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.maybePrune(now)
+
+	var u *peerUsage
+	var entries map[string]time.Time
+	if limit > 0 {
+		var ok bool
+		if u, ok = a.usage[p]; !ok {
+			u = &peerUsage{records: make(map[string]time.Time), providers: make(map[string]time.Time)}
+			a.usage[p] = u
+		}
+		entries = u.records
+		if provider {
+			entries = u.providers
+		}
+
+		// records stored again only have their expiration pushed back
+		if _, ok := entries[key]; ok {
+			entries[key] = now.Add(ttl)
+			return nil
+		}
+		if len(entries) >= limit {
+			pruneEntries(entries, now)
+			if len(entries) >= limit {
+				a.dropIfUnused(p, u)
+				metrics.RecordRequestRejected(ctx, rejectPeerQuota)
+				return errPeerQuotaExceeded
+			}
+		}
+	}
+	if budget {
+		a.maybeCountStored(ctx, now)
+		if a.stored >= a.maxStored {
+			if u != nil {
+				a.dropIfUnused(p, u)
+			}
+			metrics.RecordRequestRejected(ctx, rejectStorageBudget)
+			return errStorageBudgetReached
+		}
+		a.stored++
+	}
+	if entries != nil {
+		entries[key] = now.Add(ttl)
+	}
+	return nil
+}
+
+// maybeCountStored counts the provider records held again if the last count
+// is older than admissionPruneInterval, so that the records garbage collected
+// since are released from the budget. The previous count is kept if it fails.
+func (a *admission) maybeCountStored(ctx context.Context, now time.Time) {
+	if !a.storedAt.IsZero() && now.Sub(a.storedAt) < admissionPruneInterval {
+		return
+	}
+	n, err := a.providers.CountProviders(ctx)
+	if err != nil {
+		logger.Warnw("failed to count the provider records held", "error", err)
+		return
+	}
+	a.stored, a.storedAt = n, now
+}
+
+func (a *admission) dropIfUnused(p peer.ID, u *peerUsage) {
+	if len(u.records) == 0 && len(u.providers) == 0 {
+		delete(a.usage, p)
+	}
+}
+
+// pruneEntries removes the expired entries of a peer.
+func pruneEntries(entries map[string]time.Time, now time.Time) {
+	for k, exp := range entries {
+		if now.After(exp) {
+			delete(entries, k)
+		}
+	}
+}
+
+func (a *admission) maybePrune(now time.Time) {
+	if now.Sub(a.lastPrune) >= admissionPruneInterval {
+		a.prune(now)
+	}
+}
+
+// prune removes every expired entry, as well as the rate limiters that are
+// full again and can be recreated on demand.
+func (a *admission) prune(now time.Time) {
+	a.lastPrune = now
+	for p, u := range a.usage {
+		pruneEntries(u.records, now)
+		pruneEntries(u.providers, now)
+		a.dropIfUnused(p, u)
+	}
+	for k, b := range a.buckets {
+		lim := a.rateLimits[k.t]
+		b.refill(lim, now)
+		if b.tokens >= float64(lim.Burst) {
+			delete(a.buckets, k)
+		}
+	}
+}
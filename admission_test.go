@@ -0,0 +1,155 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// testProviderStore counts a fixed number of provider records.
+type testProviderStore struct {
+	providers.ProviderStore
+	count int
+}
+
+func (s *testProviderStore) CountProviders(context.Context) (int, error) {
+	return s.count, nil
+}
+
+func newTestAdmission(t *testing.T, opts ...Option) *admission {
+	t.Helper()
+	var cfg dhtcfg.Config
+	require.NoError(t, cfg.Apply(append([]Option{dhtcfg.Defaults}, opts...)...))
+	a, err := newAdmission(cfg, time.Hour, &testProviderStore{})
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	return a
+}
+
+func TestAdmissionPeerQuotas(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmission(t, MaxRecordsPerPeer(2), MaxProvidersPerPeer(1))
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+
+	require.NoError(t, a.admitRecord(ctx, alice, "a"))
+	require.NoError(t, a.admitRecord(ctx, alice, "b"))
+	// storing a key again doesn't use more of the quota
+	require.NoError(t, a.admitRecord(ctx, alice, "a"))
+	require.ErrorIs(t, a.admitRecord(ctx, alice, "c"), errPeerQuotaExceeded)
+	require.NoError(t, a.admitRecord(ctx, bob, "c"))
+
+	require.NoError(t, a.admitProvider(ctx, alice, "a"))
+	require.ErrorIs(t, a.admitProvider(ctx, alice, "b"), errPeerQuotaExceeded)
+
+	// expired records are released
+	a.usage[alice].records["a"] = time.Now().Add(-time.Second)
+	require.NoError(t, a.admitRecord(ctx, alice, "c"))
+
+	// only the kinds of records with a quota are tracked
+	a = newTestAdmission(t, MaxRecordsPerPeer(1), RateLimit(pb.Message_ADD_PROVIDER, 1, 1))
+	require.NoError(t, a.admitProvider(ctx, alice, "a"))
+	require.NoError(t, a.admitProvider(ctx, alice, "b"))
+	require.Empty(t, a.usage)
+}
+
+func TestAdmissionStorageBudget(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmission(t, MaxStoredRecords(2))
+	ps := a.providers.(*testProviderStore)
+
+	// records stored before count towards the budget
+	ps.count = 1
+	require.NoError(t, a.admitProvider(ctx, peer.ID("alice"), "a"))
+	require.ErrorIs(t, a.admitProvider(ctx, peer.ID("bob"), "a"), errStorageBudgetReached)
+	// value records don't
+	require.NoError(t, a.admitRecord(ctx, peer.ID("carol"), "a"))
+	require.Empty(t, a.usage)
+
+	// the budget is released once records are garbage collected
+	ps.count = 0
+	a.storedAt = time.Now().Add(-admissionPruneInterval)
+	require.NoError(t, a.admitProvider(ctx, peer.ID("bob"), "a"))
+
+	// the provider store must count its records
+	var cfg dhtcfg.Config
+	require.NoError(t, cfg.Apply(dhtcfg.Defaults, MaxStoredRecords(1)))
+	_, err := newAdmission(cfg, time.Hour, struct{ providers.ProviderStore }{})
+	require.Error(t, err)
+}
+
+func TestAdmissionRateLimit(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmission(t, RateLimit(pb.Message_ADD_PROVIDER, 1, 2))
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+
+	require.NoError(t, a.allow(ctx, pb.Message_ADD_PROVIDER, alice))
+	require.NoError(t, a.allow(ctx, pb.Message_ADD_PROVIDER, alice))
+	require.ErrorIs(t, a.allow(ctx, pb.Message_ADD_PROVIDER, alice), errRateLimited)
+	// limits are per peer and per message type
+	require.NoError(t, a.allow(ctx, pb.Message_ADD_PROVIDER, bob))
+	require.NoError(t, a.allow(ctx, pb.Message_FIND_NODE, alice))
+
+	// tokens are refilled over time
+	a.buckets[rateLimitKey{t: pb.Message_ADD_PROVIDER, p: alice}].last = time.Now().Add(-time.Second)
+	require.NoError(t, a.allow(ctx, pb.Message_ADD_PROVIDER, alice))
+
+	_, err := New(ctx, nil, RateLimit(pb.Message_ADD_PROVIDER, 0, 1))
+	require.Error(t, err)
+}
+
+func TestAdmissionMaxProvidersPerPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := setupDHT(ctx, t, false, MaxProvidersPerPeer(2))
+	client := setupDHT(ctx, t, false)
+	connect(t, ctx, client, server)
+
+	for _, k := range testCaseCids[:3] {
+		require.NoError(t, client.Provide(ctx, k, true))
+	}
+
+	// the announcements are handled in order, only the last one exceeds the
+	// quota
+	waitForProviders(t, []*IpfsDHT{server}, testCaseCids[1].Hash(), storesProviders(1))
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, storesProviders(1)(server, testCaseCids[0].Hash()))
+	require.True(t, storesProviders(0)(server, testCaseCids[2].Hash()))
+}
+
+func TestAdmissionSkipsInvalidProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := setupDHT(ctx, t, false, MaxProvidersPerPeer(1))
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	addr := server.host.Addrs()[0]
+
+	// announcements that aren't stored don't count against the quota
+	for i, k := range testCaseCids[:3] {
+		pmes := pb.NewMessage(pb.Message_ADD_PROVIDER, k.Hash(), 0)
+		switch i {
+		case 0:
+			pmes.ProviderPeers = pb.RawPeerInfosToPBPeers([]peer.AddrInfo{{ID: bob, Addrs: []ma.Multiaddr{addr}}})
+		case 1:
+			pmes.ProviderPeers = pb.RawPeerInfosToPBPeers([]peer.AddrInfo{{ID: alice}})
+		case 2:
+			pmes.SignedProviderRecords = [][]byte{[]byte("invalid envelope")}
+		}
+		_, err := server.handleAddProvider(ctx, alice, pmes)
+		require.Error(t, err)
+	}
+
+	pmes := pb.NewMessage(pb.Message_ADD_PROVIDER, testCaseCids[3].Hash(), 0)
+	pmes.ProviderPeers = pb.RawPeerInfosToPBPeers([]peer.AddrInfo{{ID: alice, Addrs: []ma.Multiaddr{addr}}})
+	_, err := server.handleAddProvider(ctx, alice, pmes)
+	require.NoError(t, err)
+}
@@ -42,6 +42,22 @@ const (
 	// is also known as Provider Record Expiration Interval.
 	DefaultProvideValidity = 48 * time.Hour
 
+	// DefaultReprovideInterval is the suggested interval at which a node
+	// should re-announce the provider records it is responsible for. It is
+	// kept well below DefaultProvideValidity so that records are refreshed
+	// before they expire, even if a reprovide run takes several hours.
+	DefaultReprovideInterval = 22 * time.Hour
+
+	// DefaultRepublishInterval is the suggested interval at which a node
+	// should push the value records it is responsible for to the closest
+	// peers missing them. Peers drop value records 48 hours after receiving
+	// them, so records are pushed again well before that.
+	DefaultRepublishInterval = 12 * time.Hour
+
+	// MaxProvidersBatchSize is the maximal number of keys a single
+	// GET_PROVIDERS_BATCH request may carry. Servers reject larger batches.
+	MaxProvidersBatchSize = 64
+
 	// DefaultProviderAddrTTL is the TTL to keep the multi addresses of
 	// provider peers around. Those addresses are returned alongside provider.
 	// After it expires, the returned records will require an extra lookup, to
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+)
+
+// parseArgs parses the flags of a command and checks it got n arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != n {
+		return fmt.Errorf("%s: expected %d arguments, got %d", fs.Name(), n, fs.NArg())
+	}
+	return nil
+}
+
+func newFlagSet(c *cli, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// runLookup starts the DHT and calls fn with it, recording the lookup trace
+// if requested. Only fn is bounded by the timeout, as bootstrapping a FullRT
+// crawls the whole network.
+func (c *cli) runLookup(ctx context.Context, fn func(ctx context.Context, n node) (interface{}, error)) error {
+	n, stop, err := c.startNode(ctx)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	ctx, stopTrace, err := c.withTrace(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := fn(ctx, n)
+	if terr := stopTrace(); terr != nil {
+		return errors.Join(err, fmt.Errorf("failed to write the lookup trace: %w", terr))
+	}
+	if err != nil {
+		return err
+	}
+	return c.output(res)
+}
+
+// FindProvidersResult is the output of find-providers.
+type FindProvidersResult struct {
+	Providers []peer.AddrInfo `json:"providers"`
+}
+
+func findProviders(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "find-providers")
+	count := fs.Int("n", 20, "number of providers to find, 0 for all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	key, err := cid.Decode(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid cid: %w", err)
+	}
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		res := FindProvidersResult{Providers: []peer.AddrInfo{}}
+		for ai := range n.FindProvidersAsync(ctx, key, *count) {
+			res.Providers = append(res.Providers, ai)
+		}
+		return res, nil
+	})
+}
+
+// FindPeerResult is the output of find-peer.
+type FindPeerResult struct {
+	Peer peer.AddrInfo `json:"peer"`
+}
+
+func findPeer(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "find-peer")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	p, err := peer.Decode(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid peer: %w", err)
+	}
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		ai, err := n.FindPeer(ctx, p)
+		return FindPeerResult{Peer: ai}, err
+	})
+}
+
+// ValueResult is the output of get-value and put-value.
+type ValueResult struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func getValue(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "get-value")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		v, err := n.GetValue(ctx, key)
+		return ValueResult{Key: key, Value: v}, err
+	})
+}
+
+func putValue(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "put-value")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	key, value := fs.Arg(0), []byte(fs.Arg(1))
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		return ValueResult{Key: key, Value: value}, n.PutValue(ctx, key, value)
+	})
+}
+
+// ClosestPeersResult is the output of closest-peers.
+type ClosestPeersResult struct {
+	Peers []peer.ID `json:"peers"`
+}
+
+func closestPeers(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "closest-peers")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	key := parseKey(fs.Arg(0))
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		peers, err := n.GetClosestPeers(ctx, key)
+		return ClosestPeersResult{Peers: peers}, err
+	})
+}
+
+// parseKey returns the DHT key of a CID or a peer ID, or key itself.
+func parseKey(key string) string {
+	if c, err := cid.Decode(key); err == nil {
+		return string(c.Hash())
+	}
+	if p, err := peer.Decode(key); err == nil {
+		return string(p)
+	}
+	return key
+}
+
+// PingResult is the output of ping.
+type PingResult struct {
+	Peer peer.ID       `json:"peer"`
+	RTT  time.Duration `json:"rttNs"`
+}
+
+func ping(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "ping")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	p, err := peer.Decode(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid peer: %w", err)
+	}
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		start := time.Now()
+		err := n.Ping(ctx, p)
+		return PingResult{Peer: p, RTT: time.Since(start)}, err
+	})
+}
+
+// NetworkSizeResult is the output of netsize.
+type NetworkSizeResult struct {
+	Estimate int32 `json:"estimate"`
+}
+
+func networkSize(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "netsize")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	return c.runLookup(ctx, func(ctx context.Context, n node) (interface{}, error) {
+		est, err := n.NetworkSize()
+		return NetworkSizeResult{Estimate: est}, err
+	})
+}
+
+// crawl crawls the network from the bootstrap peers and writes a snapshot of
+// it in the format documented by crawler.Snapshot.
+func crawl(ctx context.Context, c *cli, args []string) error {
+	fs := newFlagSet(c, "crawl")
+	parallelism := fs.Int("parallelism", 200, "number of peers queried in parallel")
+	format := fs.String("format", "json", "snapshot format: json or csv")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown snapshot format %q", *format)
+	}
+	h, err := c.startHost(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	cr, err := crawler.NewDefaultCrawler(h,
+		crawler.WithParallelism(*parallelism),
+		crawler.WithProtocols([]protocol.ID{c.prefix + "/kad/1.0.0"}),
+	)
+	if err != nil {
+		return err
+	}
+	start := make([]*peer.AddrInfo, len(c.bootstrap))
+	for i := range c.bootstrap {
+		start[i] = &c.bootstrap[i]
+	}
+
+	rec := crawler.NewSnapshotRecorder(h)
+	cr.Run(ctx, start, rec.HandleSuccess, rec.HandleFail)
+	if ctx.Err() != nil {
+		return fmt.Errorf("crawl interrupted: %w", ctx.Err())
+	}
+	if *format == "csv" {
+		return rec.Snapshot().WriteCSV(c.stdout)
+	}
+	return rec.Snapshot().WriteJSON(c.stdout)
+}
@@ -0,0 +1,200 @@
+// Command dht-cli runs one-off DHT operations against a network, from a
+// dual.DHT or a fullrt.FullRT, and prints their results as JSON.
+//
+// Usage:
+//
+//	dht-cli [flags] <command> [command flags] [args]
+//
+// Commands:
+//
+//	find-providers [-n count] <cid>
+//	find-peer <peer-id>
+//	get-value <key>
+//	put-value <key> <value>
+//	closest-peers <cid|peer-id|key>
+//	crawl [-parallelism n] [-format json|csv]
+//	ping <peer-id>
+//	netsize
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/amino"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	c := &cli{
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		newHost: func() (host.Host, error) { return libp2p.New() },
+	}
+	if err := c.run(ctx, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "dht-cli:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// cli holds the environment the commands run in.
+type cli struct {
+	stdout, stderr io.Writer
+	// newHost creates the libp2p host the DHT runs on.
+	newHost func() (host.Host, error)
+	// dhtOptions are appended to the options of the DHT.
+	dhtOptions []dht.Option
+
+	mode      string
+	bootstrap []peer.AddrInfo
+	prefix    protocol.ID
+	trace     string
+	// timeout bounds the lookups, once the DHT bootstrapped.
+	timeout time.Duration
+}
+
+type command struct {
+	name, usage string
+	run         func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{"find-providers", "[-n count] <cid>", findProviders},
+	{"find-peer", "<peer-id>", findPeer},
+	{"get-value", "<key>", getValue},
+	{"put-value", "<key> <value>", putValue},
+	{"closest-peers", "<cid|peer-id|key>", closestPeers},
+	{"crawl", "[-parallelism n] [-format json|csv]", crawl},
+	{"ping", "<peer-id>", ping},
+	{"netsize", "", networkSize},
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dht-cli", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.mode, "mode", "dual", "DHT to run: dual or fullrt")
+	bootstrap := fs.String("bootstrap", "", "comma separated multiaddrs of the bootstrap peers (default: the Amino DHT bootstrap peers)")
+	prefix := fs.String("prefix", string(amino.ProtocolPrefix), "DHT protocol prefix")
+	fs.DurationVar(&c.timeout, "timeout", time.Minute, "timeout of the lookup once the DHT bootstrapped, 0 for none. Bootstrapping and crawls run until done or interrupted")
+	fs.StringVar(&c.trace, "trace", "", "file to write the lookup trace to as JSON lines, - for stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(c.stderr, "Usage: dht-cli [flags] <command> [command flags] [args]\n\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(c.stderr, "  %s %s\n", cmd.name, cmd.usage)
+		}
+		fmt.Fprintln(c.stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+	i := slices.IndexFunc(commands, func(cmd command) bool { return cmd.name == fs.Arg(0) })
+	if i < 0 {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	if c.mode != "dual" && c.mode != "fullrt" {
+		return fmt.Errorf("unknown mode %q", c.mode)
+	}
+	c.prefix = protocol.ID(*prefix)
+
+	if *bootstrap == "" {
+		c.bootstrap = dht.GetDefaultBootstrapPeerAddrInfos()
+	} else {
+		var addrs []ma.Multiaddr
+		for _, s := range strings.Split(*bootstrap, ",") {
+			a, err := ma.NewMultiaddr(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("invalid bootstrap peer %q: %w", s, err)
+			}
+			addrs = append(addrs, a)
+		}
+		var err error
+		if c.bootstrap, err = peer.AddrInfosFromP2pAddrs(addrs...); err != nil {
+			return fmt.Errorf("invalid bootstrap peers: %w", err)
+		}
+	}
+
+	return commands[i].run(ctx, c, fs.Args()[1:])
+}
+
+// output writes v to stdout as a JSON document.
+func (c *cli) output(v interface{}) error {
+	return json.NewEncoder(c.stdout).Encode(v)
+}
+
+// startHost creates the host and connects it to the bootstrap peers.
+func (c *cli) startHost(ctx context.Context) (host.Host, error) {
+	h, err := c.newHost()
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var connected int
+	for _, ai := range c.bootstrap {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Connect(ctx, ai); err != nil {
+				fmt.Fprintf(c.stderr, "failed to connect to bootstrap peer %s: %s\n", ai.ID, err)
+				return
+			}
+			mu.Lock()
+			connected++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if connected == 0 {
+		h.Close()
+		return nil, errors.New("could not connect to any bootstrap peer")
+	}
+	return h, nil
+}
+
+// withTrace records the lookup events of the lookups run with the returned
+// context to the trace file, if any. The returned function stops recording.
+func (c *cli) withTrace(ctx context.Context) (context.Context, func() error, error) {
+	if c.trace == "" {
+		return ctx, func() error { return nil }, nil
+	}
+	w, closeFile := c.stderr, func() error { return nil }
+	if c.trace != "-" {
+		f, err := os.Create(c.trace)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closeFile = f, f.Close
+	}
+	ctx, rec := dht.NewLookupTraceRecorder(ctx, w)
+	return ctx, func() error {
+		return errors.Join(rec.Close(), closeFile())
+	}, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p-kad-dht/netsize"
+)
+
+const testPrefix = "/test"
+
+type blankValidator struct{}
+
+func (blankValidator) Validate(_ string, _ []byte) error        { return nil }
+func (blankValidator) Select(_ string, _ [][]byte) (int, error) { return 0, nil }
+
+// testNetwork is a mocknet swarm of DHT servers.
+type testNetwork struct {
+	mn      mocknet.Mocknet
+	servers []*dht.IpfsDHT
+}
+
+func newTestNetwork(t *testing.T, n int) *testNetwork {
+	ctx := context.Background()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	tn := &testNetwork{mn: mn}
+	for i := 0; i < n; i++ {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.DisableAutoRefresh(), dht.ProtocolPrefix(testPrefix),
+			dht.NamespacedValidator("v", blankValidator{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { d.Close() })
+		tn.servers = append(tn.servers, d)
+	}
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	for _, d := range tn.servers {
+		require.Eventually(t, func() bool { return d.RoutingTable().Size() == n-1 }, 5*time.Second, 10*time.Millisecond)
+	}
+	return tn
+}
+
+// run runs the cli with a mocknet host, bootstrapping from the first server.
+func (tn *testNetwork) run(t *testing.T, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{
+		stdout: &stdout,
+		stderr: &stderr,
+		newHost: func() (host.Host, error) {
+			h, err := tn.mn.GenPeer()
+			if err != nil {
+				return nil, err
+			}
+			return h, tn.mn.LinkAll()
+		},
+		// the WAN DHT only accepts public addresses, unlike mocknet's
+		dhtOptions: []dht.Option{
+			dht.QueryFilter(func(interface{}, peer.AddrInfo) bool { return true }),
+			dht.RoutingTableFilter(func(interface{}, peer.ID) bool { return true }),
+			dht.RoutingTablePeerDiversityFilter(nil),
+			dht.NamespacedValidator("v", blankValidator{}),
+		},
+	}
+	s := tn.servers[0]
+	bootstrap := s.Host().Addrs()[0].String() + "/p2p/" + s.PeerID().String()
+	err := c.run(context.Background(), append([]string{"-bootstrap", bootstrap, "-prefix", testPrefix, "-timeout", "10s"}, args...))
+	t.Log(stderr.String())
+	return stdout.String(), err
+}
+
+func decode(t *testing.T, out string, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.Unmarshal([]byte(out), v), out)
+}
+
+func TestCommands(t *testing.T) {
+	tn := newTestNetwork(t, 4)
+	var serverIDs []peer.ID
+	for _, s := range tn.servers {
+		serverIDs = append(serverIDs, s.PeerID())
+	}
+
+	out, err := tn.run(t, "closest-peers", "foo")
+	require.NoError(t, err)
+	var closest ClosestPeersResult
+	decode(t, out, &closest)
+	require.ElementsMatch(t, serverIDs, closest.Peers)
+
+	target := tn.servers[3].PeerID()
+	out, err = tn.run(t, "find-peer", target.String())
+	require.NoError(t, err)
+	var found FindPeerResult
+	decode(t, out, &found)
+	require.Equal(t, target, found.Peer.ID)
+	require.NotEmpty(t, found.Peer.Addrs)
+
+	out, err = tn.run(t, "ping", target.String())
+	require.NoError(t, err)
+	var pinged PingResult
+	decode(t, out, &pinged)
+	require.Equal(t, target, pinged.Peer)
+
+	_, err = tn.run(t, "put-value", "/v/hello", "world")
+	require.NoError(t, err)
+	out, err = tn.run(t, "get-value", "/v/hello")
+	require.NoError(t, err)
+	var value ValueResult
+	decode(t, out, &value)
+	require.Equal(t, ValueResult{Key: "/v/hello", Value: []byte("world")}, value)
+
+	mh, err := multihash.Sum([]byte("hello"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mh)
+	require.NoError(t, tn.servers[1].Provide(context.Background(), c, true))
+	out, err = tn.run(t, "find-providers", "-n", "1", c.String())
+	require.NoError(t, err)
+	var provs FindProvidersResult
+	decode(t, out, &provs)
+	require.Len(t, provs.Providers, 1)
+	require.Equal(t, tn.servers[1].PeerID(), provs.Providers[0].ID)
+
+	out, err = tn.run(t, "crawl", "-format", "csv")
+	require.NoError(t, err)
+	snapshot, err := crawler.ReadSnapshotCSV(strings.NewReader(out))
+	require.NoError(t, err)
+	var crawledIDs []peer.ID
+	for _, p := range snapshot.Peers {
+		require.True(t, p.Reached(), p.Error)
+		crawledIDs = append(crawledIDs, p.ID)
+	}
+	require.ElementsMatch(t, serverIDs, crawledIDs)
+
+	// there is not enough data to estimate the size of such a small network
+	_, err = tn.run(t, "netsize")
+	require.ErrorIs(t, err, netsize.ErrNotEnoughData)
+}
+
+func TestTrace(t *testing.T) {
+	tn := newTestNetwork(t, 3)
+	trace := filepath.Join(t.TempDir(), "trace.jsonl")
+	_, err := tn.run(t, "-trace", trace, "closest-peers", "foo")
+	require.NoError(t, err)
+
+	f, err := os.Open(trace)
+	require.NoError(t, err)
+	defer f.Close()
+	replays, err := dht.ReplayLookupTrace(f)
+	require.NoError(t, err)
+	require.NotEmpty(t, replays)
+	require.Equal(t, "foo", replays[0].Key.Key)
+	require.NotNil(t, replays[0].Terminate)
+}
+
+func TestUsage(t *testing.T) {
+	run := func(args ...string) error {
+		var stdout, stderr bytes.Buffer
+		return (&cli{stdout: &stdout, stderr: &stderr}).run(context.Background(), args)
+	}
+	require.ErrorContains(t, run(), "missing command")
+	require.ErrorContains(t, run("foo"), "unknown command")
+	require.ErrorContains(t, run("-mode", "foo", "netsize"), "unknown mode")
+	require.ErrorContains(t, run("-bootstrap", "/ip4/1.2.3.4/tcp/1", "netsize"), "invalid bootstrap peers")
+	require.ErrorContains(t, run("find-peer"), "expected 1 arguments")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+)
+
+// bootstrapPollInterval is how often the routing table is checked while
+// waiting for the DHT to bootstrap.
+const bootstrapPollInterval = 100 * time.Millisecond
+
+// node is the DHT the commands run against.
+type node interface {
+	routing.Routing
+	GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error)
+	Ping(ctx context.Context, p peer.ID) error
+	NetworkSize() (int32, error)
+	Close() error
+}
+
+type dualNode struct {
+	*dual.DHT
+}
+
+// active returns the DHT the dual DHT runs its lookups with.
+func (d dualNode) active() *dht.IpfsDHT {
+	if d.WANActive() {
+		return d.WAN
+	}
+	return d.LAN
+}
+
+func (d dualNode) GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
+	return d.active().GetClosestPeers(ctx, key)
+}
+
+func (d dualNode) Ping(ctx context.Context, p peer.ID) error {
+	return d.active().Ping(ctx, p)
+}
+
+func (d dualNode) NetworkSize() (int32, error) {
+	return d.active().NetworkSize()
+}
+
+func (d dualNode) ready() bool {
+	return d.WAN.RoutingTable().Size() > 0 || d.LAN.RoutingTable().Size() > 0
+}
+
+type fullRTNode struct {
+	*fullrt.FullRT
+}
+
+// NetworkSize returns the number of DHT servers found by the last crawl.
+func (d fullRTNode) NetworkSize() (int32, error) {
+	return int32(len(d.Stat())), nil
+}
+
+// startNode starts the DHT and waits for it to bootstrap. The returned
+// function stops the DHT and its host.
+func (c *cli) startNode(ctx context.Context) (node, func(), error) {
+	h, err := c.startHost(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := append([]dht.Option{dht.Mode(dht.ModeClient), dht.BootstrapPeers(c.bootstrap...)}, c.dhtOptions...)
+	var n node
+	var ready func() bool
+	switch c.mode {
+	case "fullrt":
+		var d *fullrt.FullRT
+		d, err = fullrt.NewFullRT(h, c.prefix, fullrt.DHTOption(opts...))
+		n, ready = fullRTNode{d}, d.Ready
+	default:
+		var d *dual.DHT
+		d, err = dual.New(ctx, h, dual.DHTOption(append([]dht.Option{dht.ProtocolPrefix(c.prefix)}, opts...)...))
+		n, ready = dualNode{d}, dualNode{d}.ready
+	}
+	if err != nil {
+		h.Close()
+		return nil, nil, err
+	}
+	stop := func() {
+		n.Close()
+		h.Close()
+	}
+
+	t := time.NewTicker(bootstrapPollInterval)
+	defer t.Stop()
+	for !ready() {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			stop()
+			return nil, nil, fmt.Errorf("interrupted while waiting for the DHT to bootstrap: %w", ctx.Err())
+		}
+	}
+	return n, stop, nil
+}
@@ -2,6 +2,7 @@ package crawler
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -21,7 +22,12 @@ import (
 var (
 	logger = logging.Logger("dht-crawler")
 
-	_ Crawler = (*DefaultCrawler)(nil)
+	_ Crawler     = (*DefaultCrawler)(nil)
+	_ PeerQuerier = (*DefaultCrawler)(nil)
+
+	// ErrNoAddrs is passed to HandleQueryFail by QueryPeers for the peers
+	// without any known address.
+	ErrNoAddrs = errors.New("no addresses for peer")
 )
 
 type (
@@ -30,6 +36,13 @@ type (
 		// Run crawls the DHT starting from the startingPeers, and calls either handleSuccess or handleFail depending on whether a peer was successfully contacted or not.
 		Run(ctx context.Context, startingPeers []*peer.AddrInfo, handleSuccess HandleQueryResult, handleFail HandleQueryFail)
 	}
+	// PeerQuerier is implemented by crawlers able to query a given set of peers
+	// for their routing tables without crawling the peers they return.
+	PeerQuerier interface {
+		// QueryPeers queries each of the peers once, and calls either handleSuccess or handleFail depending on whether
+		// the peer was successfully contacted or not. It returns once all the peers were queried or ctx is done.
+		QueryPeers(ctx context.Context, peers []*peer.AddrInfo, handleSuccess HandleQueryResult, handleFail HandleQueryFail)
+	}
 	// DefaultCrawler provides a default implementation of Crawler.
 	DefaultCrawler struct {
 		parallelism    int
@@ -278,6 +291,62 @@ func (c *DefaultCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo
 	}
 }
 
+// QueryPeers queries the given peers in parallel without following the peers they return. The addresses of each peer
+// are merged with the ones in the peerstore, and handleFail is called with ErrNoAddrs for the peers without any.
+// As with Run, a peer returning no peers is reported to handleFail, and the callbacks are not called concurrently.
+func (c *DefaultCrawler) QueryPeers(ctx context.Context, peers []*peer.AddrInfo, handleSuccess HandleQueryResult, handleFail HandleQueryFail) {
+	jobs := make(chan peer.AddrInfo)
+	var cbLk sync.Mutex
+
+	var wg sync.WaitGroup
+	workers := min(c.parallelism, len(peers))
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for ai := range jobs {
+				qctx, cancel := context.WithTimeout(ctx, c.queryTimeout)
+				res := c.queryPeer(qctx, ai)
+				cancel() // do not defer, cleanup after each job
+
+				cbLk.Lock()
+				if len(res.data) > 0 {
+					if handleSuccess != nil {
+						rtPeers := make([]*peer.AddrInfo, 0, len(res.data))
+						for _, pi := range res.data {
+							rtPeers = append(rtPeers, pi)
+						}
+						handleSuccess(res.peer, rtPeers)
+					}
+				} else if handleFail != nil {
+					handleFail(res.peer, res.err)
+				}
+				cbLk.Unlock()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	for _, ai := range peers {
+		addrs := c.host.Peerstore().Addrs(ai.ID)
+		addrs = append(addrs, ai.Addrs...)
+		if len(addrs) == 0 {
+			if handleFail != nil {
+				cbLk.Lock()
+				handleFail(ai.ID, ErrNoAddrs)
+				cbLk.Unlock()
+			}
+			continue
+		}
+		select {
+		case jobs <- peer.AddrInfo{ID: ai.ID, Addrs: addrs}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 type queryResult struct {
 	peer peer.ID
 	data map[peer.ID]*peer.AddrInfo
@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func TestQueryPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New()
+	defer mn.Close()
+	var dhts []*dht.IpfsDHT
+	for i := 0; i < 3; i++ {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/test"), dht.DisableAutoRefresh())
+		require.NoError(t, err)
+		defer d.Close()
+		dhts = append(dhts, d)
+	}
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	for _, d := range dhts {
+		require.Eventually(t, func() bool { return d.RoutingTable().Size() == len(dhts)-1 }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	c, err := NewDefaultCrawler(h, WithProtocols([]protocol.ID{"/test/kad/1.0.0"}), WithConnectTimeout(time.Second))
+	require.NoError(t, err)
+	unreachable := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1")}}
+	noAddrs := peer.AddrInfo{ID: test.RandPeerIDFatal(t)}
+	queried := peer.AddrInfo{ID: dhts[0].PeerID(), Addrs: dhts[0].Host().Addrs()}
+
+	var mu sync.Mutex
+	succeeded := make(map[peer.ID][]peer.ID)
+	failed := make(map[peer.ID]error)
+	c.QueryPeers(ctx, []*peer.AddrInfo{&queried, &unreachable, &noAddrs},
+		func(p peer.ID, rtPeers []*peer.AddrInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ai := range rtPeers {
+				succeeded[p] = append(succeeded[p], ai.ID)
+			}
+		},
+		func(p peer.ID, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[p] = err
+		})
+
+	// the peers returned by the queried peer are not queried
+	require.Len(t, succeeded, 1)
+	require.ElementsMatch(t, []peer.ID{dhts[1].PeerID(), dhts[2].PeerID()}, succeeded[queried.ID])
+	require.Len(t, failed, 2)
+	require.Error(t, failed[unreachable.ID])
+	require.ErrorIs(t, failed[noAddrs.ID], ErrNoAddrs)
+}
@@ -0,0 +1,331 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Snapshot is the state of the network observed by a crawl.
+//
+// A snapshot is written as JSON (see WriteJSON) as:
+//
+//	{
+//	  "startedAt": "2024-01-02T15:04:05Z",
+//	  "finishedAt": "2024-01-02T15:09:05Z",
+//	  "peers": [
+//	    {
+//	      "id": "12D3KooW...",
+//	      "addrs": ["/ip4/1.2.3.4/tcp/4001"],
+//	      "agentVersion": "kubo/0.30.0",
+//	      "protocols": ["/ipfs/kad/1.0.0"],
+//	      "routingTable": ["12D3KooW...", "QmYy..."],
+//	      "error": ""
+//	    }
+//	  ]
+//	}
+//
+// or as CSV (see WriteCSV), with a header row followed by one row per peer:
+//
+//	id,addrs,agent_version,protocols,routing_table,error
+//
+// where the addrs, protocols and routing_table lists are space separated. The
+// CSV format doesn't hold the crawl start and finish times.
+type Snapshot struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Peers      []SnapshotPeer `json:"peers"`
+}
+
+// SnapshotPeer is a peer found by a crawl.
+type SnapshotPeer struct {
+	ID    peer.ID
+	Addrs []ma.Multiaddr
+	// AgentVersion and Protocols are the ones the peer advertised with the
+	// identify protocol, if any.
+	AgentVersion string
+	Protocols    []protocol.ID
+	// RoutingTable is the set of peers the peer returned to the crawler's
+	// FIND_NODE requests, sorted.
+	RoutingTable []peer.ID
+	// Error is the reason the peer couldn't be crawled, empty if it was.
+	Error string
+}
+
+// Reached reports whether the peer was successfully crawled.
+func (p *SnapshotPeer) Reached() bool {
+	return p.Error == ""
+}
+
+type snapshotPeerJSON struct {
+	ID           peer.ID       `json:"id"`
+	Addrs        []string      `json:"addrs"`
+	AgentVersion string        `json:"agentVersion"`
+	Protocols    []protocol.ID `json:"protocols"`
+	RoutingTable []peer.ID     `json:"routingTable"`
+	Error        string        `json:"error"`
+}
+
+func (p SnapshotPeer) MarshalJSON() ([]byte, error) {
+	addrs := make([]string, len(p.Addrs))
+	for i, a := range p.Addrs {
+		addrs[i] = a.String()
+	}
+	return json.Marshal(snapshotPeerJSON{
+		ID:           p.ID,
+		Addrs:        addrs,
+		AgentVersion: p.AgentVersion,
+		Protocols:    p.Protocols,
+		RoutingTable: p.RoutingTable,
+		Error:        p.Error,
+	})
+}
+
+func (p *SnapshotPeer) UnmarshalJSON(b []byte) error {
+	var v snapshotPeerJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	addrs, err := parseAddrs(v.Addrs)
+	if err != nil {
+		return err
+	}
+	*p = SnapshotPeer{
+		ID:           v.ID,
+		Addrs:        addrs,
+		AgentVersion: v.AgentVersion,
+		Protocols:    v.Protocols,
+		RoutingTable: v.RoutingTable,
+		Error:        v.Error,
+	}
+	return nil
+}
+
+func parseAddrs(ss []string) ([]ma.Multiaddr, error) {
+	addrs := make([]ma.Multiaddr, len(ss))
+	for i, s := range ss {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = a
+	}
+	return addrs, nil
+}
+
+// SnapshotRecorder builds a Snapshot of a crawl. Its HandleSuccess and
+// HandleFail methods are the callbacks to pass to Crawler.Run:
+//
+//	rec := NewSnapshotRecorder(h)
+//	c.Run(ctx, startingPeers, rec.HandleSuccess, rec.HandleFail)
+//	snapshot := rec.Snapshot()
+type SnapshotRecorder struct {
+	pstore peerstore.Peerstore
+	start  time.Time
+
+	mu    sync.Mutex
+	peers map[peer.ID]SnapshotPeer
+}
+
+// NewSnapshotRecorder creates a SnapshotRecorder for a crawl run by h, the
+// peer metadata is read from its peerstore.
+func NewSnapshotRecorder(h host.Host) *SnapshotRecorder {
+	return &SnapshotRecorder{
+		pstore: h.Peerstore(),
+		start:  time.Now(),
+		peers:  make(map[peer.ID]SnapshotPeer),
+	}
+}
+
+// HandleSuccess records a peer crawled successfully.
+func (r *SnapshotRecorder) HandleSuccess(p peer.ID, rtPeers []*peer.AddrInfo) {
+	sp := r.peerInfo(p)
+	sp.RoutingTable = make([]peer.ID, len(rtPeers))
+	for i, ai := range rtPeers {
+		sp.RoutingTable[i] = ai.ID
+	}
+	slices.Sort(sp.RoutingTable)
+	r.add(sp)
+}
+
+// HandleFail records a peer that couldn't be crawled.
+func (r *SnapshotRecorder) HandleFail(p peer.ID, err error) {
+	sp := r.peerInfo(p)
+	if err != nil {
+		sp.Error = err.Error()
+	} else {
+		sp.Error = "no peers returned"
+	}
+	r.add(sp)
+}
+
+func (r *SnapshotRecorder) peerInfo(p peer.ID) SnapshotPeer {
+	sp := SnapshotPeer{ID: p, Addrs: r.pstore.Addrs(p)}
+	if v, err := r.pstore.Get(p, "AgentVersion"); err == nil {
+		sp.AgentVersion, _ = v.(string)
+	}
+	if protos, err := r.pstore.GetProtocols(p); err == nil && len(protos) > 0 {
+		sp.Protocols = protos
+		slices.Sort(sp.Protocols)
+	}
+	return sp
+}
+
+func (r *SnapshotRecorder) add(sp SnapshotPeer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers[sp.ID] = sp
+}
+
+// Snapshot returns the peers recorded so far, sorted by peer ID.
+func (r *SnapshotRecorder) Snapshot() *Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &Snapshot{StartedAt: r.start, FinishedAt: time.Now(), Peers: make([]SnapshotPeer, 0, len(r.peers))}
+	for _, sp := range r.peers {
+		s.Peers = append(s.Peers, sp)
+	}
+	slices.SortFunc(s.Peers, func(a, b SnapshotPeer) int { return strings.Compare(string(a.ID), string(b.ID)) })
+	return s
+}
+
+// WriteJSON writes the snapshot as JSON.
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// ReadSnapshotJSON reads a snapshot written by WriteJSON.
+func ReadSnapshotJSON(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return &s, nil
+}
+
+var snapshotCSVHeader = []string{"id", "addrs", "agent_version", "protocols", "routing_table", "error"}
+
+// WriteCSV writes the peers of the snapshot as CSV.
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(snapshotCSVHeader); err != nil {
+		return err
+	}
+	for _, p := range s.Peers {
+		addrs := make([]string, len(p.Addrs))
+		for i, a := range p.Addrs {
+			addrs[i] = a.String()
+		}
+		protos := make([]string, len(p.Protocols))
+		for i, proto := range p.Protocols {
+			protos[i] = string(proto)
+		}
+		rt := make([]string, len(p.RoutingTable))
+		for i, id := range p.RoutingTable {
+			rt[i] = id.String()
+		}
+		err := cw.Write([]string{
+			p.ID.String(),
+			strings.Join(addrs, " "),
+			p.AgentVersion,
+			strings.Join(protos, " "),
+			strings.Join(rt, " "),
+			p.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadSnapshotCSV reads a snapshot written by WriteCSV.
+func ReadSnapshotCSV(r io.Reader) (*Snapshot, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(snapshotCSVHeader)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if !slices.Equal(header, snapshotCSVHeader) {
+		return nil, fmt.Errorf("invalid snapshot header: %v", header)
+	}
+
+	s := &Snapshot{Peers: []SnapshotPeer{}}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		p := SnapshotPeer{AgentVersion: row[2], Error: row[5]}
+		if p.ID, err = peer.Decode(row[0]); err != nil {
+			return nil, fmt.Errorf("invalid snapshot peer %q: %w", row[0], err)
+		}
+		if p.Addrs, err = parseAddrs(strings.Fields(row[1])); err != nil {
+			return nil, fmt.Errorf("invalid addresses of snapshot peer %s: %w", p.ID, err)
+		}
+		for _, proto := range strings.Fields(row[3]) {
+			p.Protocols = append(p.Protocols, protocol.ID(proto))
+		}
+		for _, f := range strings.Fields(row[4]) {
+			id, err := peer.Decode(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid routing table of snapshot peer %s: %w", p.ID, err)
+			}
+			p.RoutingTable = append(p.RoutingTable, id)
+		}
+		s.Peers = append(s.Peers, p)
+	}
+}
+
+// AgentVersions counts the reached peers by agent version.
+func (s *Snapshot) AgentVersions() map[string]int {
+	versions := make(map[string]int)
+	for _, p := range s.Peers {
+		if p.Reached() {
+			versions[p.AgentVersion]++
+		}
+	}
+	return versions
+}
+
+// Churn returns the peers reached by the crawl of s but not by the crawl of
+// old, and those reached by old but not by s.
+func (s *Snapshot) Churn(old *Snapshot) (joined, left []peer.ID) {
+	reached := func(s *Snapshot) map[peer.ID]struct{} {
+		m := make(map[peer.ID]struct{}, len(s.Peers))
+		for _, p := range s.Peers {
+			if p.Reached() {
+				m[p.ID] = struct{}{}
+			}
+		}
+		return m
+	}
+	before, after := reached(old), reached(s)
+	for _, p := range s.Peers {
+		if _, ok := before[p.ID]; !ok && p.Reached() {
+			joined = append(joined, p.ID)
+		}
+	}
+	for _, p := range old.Peers {
+		if _, ok := after[p.ID]; !ok && p.Reached() {
+			left = append(left, p.ID)
+		}
+	}
+	return joined, left
+}
@@ -0,0 +1,100 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func TestSnapshotRecorder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New()
+	defer mn.Close()
+	var dhts []*dht.IpfsDHT
+	var servers []peer.ID
+	for i := 0; i < 3; i++ {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/test"), dht.DisableAutoRefresh())
+		require.NoError(t, err)
+		defer d.Close()
+		dhts = append(dhts, d)
+		servers = append(servers, h.ID())
+	}
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	for _, d := range dhts {
+		require.Eventually(t, func() bool { return d.RoutingTable().Size() == len(dhts)-1 }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	c, err := NewDefaultCrawler(h, WithProtocols([]protocol.ID{"/test/kad/1.0.0"}), WithConnectTimeout(time.Second))
+	require.NoError(t, err)
+	unreachable := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1")}}
+	start := []*peer.AddrInfo{{ID: servers[0], Addrs: dhts[0].Host().Addrs()}, &unreachable}
+
+	rec := NewSnapshotRecorder(h)
+	c.Run(ctx, start, rec.HandleSuccess, rec.HandleFail)
+	s := rec.Snapshot()
+
+	var reached []peer.ID
+	var agent string
+	for _, p := range s.Peers {
+		if p.ID == unreachable.ID {
+			require.False(t, p.Reached())
+			require.NotEmpty(t, p.Error)
+			continue
+		}
+		require.True(t, p.Reached(), p.Error)
+		reached = append(reached, p.ID)
+		require.NotEmpty(t, p.Addrs)
+		require.Contains(t, p.Protocols, protocol.ID("/test/kad/1.0.0"))
+		require.NotEmpty(t, p.AgentVersion)
+		agent = p.AgentVersion
+		require.Len(t, p.RoutingTable, len(servers)-1)
+		require.NotContains(t, p.RoutingTable, p.ID)
+	}
+	require.ElementsMatch(t, servers, reached)
+	require.Equal(t, map[string]int{agent: len(servers)}, s.AgentVersions())
+
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteJSON(&buf))
+	loaded, err := ReadSnapshotJSON(&buf)
+	require.NoError(t, err)
+	require.True(t, s.StartedAt.Equal(loaded.StartedAt))
+	require.Equal(t, s.Peers, loaded.Peers)
+
+	buf.Reset()
+	require.NoError(t, s.WriteCSV(&buf))
+	loaded, err = ReadSnapshotCSV(&buf)
+	require.NoError(t, err)
+	require.Equal(t, s.Peers, loaded.Peers)
+}
+
+func TestSnapshotChurn(t *testing.T) {
+	old := &Snapshot{Peers: []SnapshotPeer{{ID: "a"}, {ID: "b"}, {ID: "c", Error: "timeout"}}}
+	s := &Snapshot{Peers: []SnapshotPeer{{ID: "a"}, {ID: "b", Error: "timeout"}, {ID: "c"}, {ID: "d"}}}
+	joined, left := s.Churn(old)
+	require.Equal(t, []peer.ID{"c", "d"}, joined)
+	require.Equal(t, []peer.ID{"b"}, left)
+}
+
+func TestReadSnapshotCSVErrors(t *testing.T) {
+	_, err := ReadSnapshotCSV(bytes.NewBufferString("id,addrs\n"))
+	require.Error(t, err)
+	_, err = ReadSnapshotCSV(bytes.NewBufferString("id,addrs,agent_version,protocols,routing_table,error\nfoo,,,,,\n"))
+	require.ErrorContains(t, err, "invalid snapshot peer")
+}
@@ -0,0 +1,379 @@
+// Package debug provides an HTTP handler to inspect and operate a running
+// IpfsDHT.
+//
+// The handler serves JSON documents and is meant to be mounted on an admin
+// listener, as it lets its clients trigger network requests:
+//
+//	GET  /routing-table  routing table peers by bucket
+//	GET  /diversity      routing table diversity stats
+//	GET  /mode           DHT mode
+//	GET  /network-size   network size estimate
+//	GET  /providers      provider record count
+//	GET  /queries        lookups in progress
+//	GET  /refresh        routing table refresh state
+//	POST /refresh        force a routing table refresh
+//	POST /ping?peer=     ping a peer
+//	POST /lookup?kind=&key=
+//	                     run a closest-peers, find-peer or find-providers lookup
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+var logger = logging.Logger("dht/debug")
+
+// LookupTimeout bounds the duration of the lookups run by the handler.
+var LookupTimeout = time.Minute
+
+// NewHandler returns an HTTP handler exposing d.
+func NewHandler(d *dht.IpfsDHT) http.Handler {
+	h := &handler{dht: d}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /routing-table", h.routingTable)
+	mux.HandleFunc("GET /diversity", h.diversity)
+	mux.HandleFunc("GET /mode", h.mode)
+	mux.HandleFunc("GET /network-size", h.networkSize)
+	mux.HandleFunc("GET /providers", h.providers)
+	mux.HandleFunc("GET /queries", h.queries)
+	mux.HandleFunc("GET /refresh", h.refreshState)
+	mux.HandleFunc("POST /refresh", h.refresh)
+	mux.HandleFunc("POST /ping", h.ping)
+	mux.HandleFunc("POST /lookup", h.lookup)
+	return mux
+}
+
+type handler struct {
+	dht *dht.IpfsDHT
+}
+
+// RoutingTable is served by GET /routing-table.
+type RoutingTable struct {
+	Self    peer.ID  `json:"self"`
+	Size    int      `json:"size"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Bucket holds the routing table peers sharing Cpl leading bits with the
+// local node.
+type Bucket struct {
+	Cpl   int      `json:"cpl"`
+	Peers []RTPeer `json:"peers"`
+}
+
+// RTPeer is a routing table peer.
+type RTPeer struct {
+	ID                            peer.ID   `json:"id"`
+	AddedAt                       time.Time `json:"addedAt"`
+	LastUsefulAt                  time.Time `json:"lastUsefulAt"`
+	LastSuccessfulOutboundQueryAt time.Time `json:"lastSuccessfulOutboundQueryAt"`
+}
+
+func (h *handler) routingTable(w http.ResponseWriter, _ *http.Request) {
+	self := kb.ConvertPeerID(h.dht.PeerID())
+	infos := h.dht.RoutingTable().GetPeerInfos()
+	rt := RoutingTable{Self: h.dht.PeerID(), Size: len(infos)}
+	for _, pi := range infos {
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(pi.Id))
+		for len(rt.Buckets) <= cpl {
+			rt.Buckets = append(rt.Buckets, Bucket{Cpl: len(rt.Buckets), Peers: []RTPeer{}})
+		}
+		rt.Buckets[cpl].Peers = append(rt.Buckets[cpl].Peers, RTPeer{
+			ID:                            pi.Id,
+			AddedAt:                       pi.AddedAt,
+			LastUsefulAt:                  pi.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, rt)
+}
+
+// DiversityStats is served by GET /diversity, one per common prefix length.
+type DiversityStats struct {
+	Cpl int `json:"cpl"`
+	// Peers maps the peers to the IP groups they belong to.
+	Peers map[peer.ID][]string `json:"peers"`
+}
+
+func (h *handler) diversity(w http.ResponseWriter, _ *http.Request) {
+	out := []DiversityStats{}
+	for _, s := range h.dht.GetRoutingTableDiversityStats() {
+		ds := DiversityStats{Cpl: s.Cpl, Peers: make(map[peer.ID][]string, len(s.Peers))}
+		for p, groups := range s.Peers {
+			for _, g := range groups {
+				ds.Peers[p] = append(ds.Peers[p], string(g))
+			}
+		}
+		out = append(out, ds)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// Mode is served by GET /mode.
+type Mode struct {
+	// Configured is the mode the DHT was configured with.
+	Configured string `json:"configured"`
+	// Server is whether the DHT currently answers remote requests.
+	Server bool `json:"server"`
+}
+
+func modeName(m dht.ModeOpt) string {
+	switch m {
+	case dht.ModeAuto:
+		return "auto"
+	case dht.ModeClient:
+		return "client"
+	case dht.ModeServer:
+		return "server"
+	case dht.ModeAutoServer:
+		return "auto-server"
+	default:
+		return fmt.Sprintf("unknown (%d)", m)
+	}
+}
+
+func (h *handler) mode(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, Mode{Configured: modeName(h.dht.Mode()), Server: h.dht.IsServer()})
+}
+
+// NetworkSize is served by GET /network-size.
+type NetworkSize struct {
+	Estimate int32 `json:"estimate"`
+}
+
+func (h *handler) networkSize(w http.ResponseWriter, _ *http.Request) {
+	n, err := h.dht.NetworkSize()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NetworkSize{Estimate: n})
+}
+
+// ProviderCounts is served by GET /providers.
+type ProviderCounts struct {
+	// Records is the number of provider records held. Expired records are
+	// counted until they are garbage collected.
+	Records int `json:"records"`
+}
+
+func (h *handler) providers(w http.ResponseWriter, r *http.Request) {
+	ps, ok := h.dht.ProviderStore().(providers.IndexedProviderStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("the provider store cannot count its records"))
+		return
+	}
+	n, err := ps.CountProviders(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ProviderCounts{Records: n})
+}
+
+// Query is a lookup in progress, served by GET /queries.
+type Query struct {
+	IDs []string `json:"ids"`
+	// Key is the multibase encoded key looked up.
+	Key       string        `json:"key"`
+	Operation string        `json:"operation"`
+	StartedAt time.Time     `json:"startedAt"`
+	Running   time.Duration `json:"runningNs"`
+}
+
+func (h *handler) queries(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	out := []Query{}
+	for _, q := range h.dht.InFlightQueries() {
+		ids := make([]string, len(q.IDs))
+		for i, id := range q.IDs {
+			ids[i] = id.String()
+		}
+		out = append(out, Query{
+			IDs:       ids,
+			Key:       encodeKey(q.Key),
+			Operation: string(q.Operation),
+			StartedAt: q.StartedAt,
+			Running:   now.Sub(q.StartedAt),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// RefreshState is served by GET /refresh.
+type RefreshState struct {
+	AutoRefresh    bool          `json:"autoRefresh"`
+	Interval       time.Duration `json:"intervalNs"`
+	Running        bool          `json:"running"`
+	Refreshes      int           `json:"refreshes"`
+	LastStartedAt  time.Time     `json:"lastStartedAt"`
+	LastFinishedAt time.Time     `json:"lastFinishedAt"`
+	LastError      string        `json:"lastError,omitempty"`
+	// CplLastRefreshedAt maps the common prefix lengths tracked for refresh
+	// to the time they were last refreshed.
+	CplLastRefreshedAt map[uint]time.Time `json:"cplLastRefreshedAt"`
+}
+
+func (h *handler) refreshState(w http.ResponseWriter, _ *http.Request) {
+	s := h.dht.RefreshState()
+	out := RefreshState{
+		AutoRefresh:        s.AutoRefresh,
+		Interval:           s.Interval,
+		Running:            s.Running,
+		Refreshes:          s.Refreshes,
+		LastStartedAt:      s.LastStartedAt,
+		LastFinishedAt:     s.LastFinishedAt,
+		CplLastRefreshedAt: s.CplLastRefreshedAt,
+	}
+	if s.LastError != nil {
+		out.LastError = s.LastError.Error()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// refresh forces a routing table refresh and waits for it to finish.
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+	select {
+	case err := <-h.dht.ForceRefresh():
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	case <-r.Context().Done():
+		return
+	}
+	h.refreshState(w, r)
+}
+
+// Ping is served by POST /ping.
+type Ping struct {
+	Peer peer.ID       `json:"peer"`
+	RTT  time.Duration `json:"rttNs"`
+}
+
+func (h *handler) ping(w http.ResponseWriter, r *http.Request) {
+	p, err := peer.Decode(r.URL.Query().Get("peer"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer: %w", err))
+		return
+	}
+	start := time.Now()
+	if err := h.dht.Ping(r.Context(), p); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Ping{Peer: p, RTT: time.Since(start)})
+}
+
+// Lookup is served by POST /lookup.
+type Lookup struct {
+	Kind     string          `json:"kind"`
+	Key      string          `json:"key"`
+	Duration time.Duration   `json:"durationNs"`
+	Peers    []peer.AddrInfo `json:"peers"`
+}
+
+// lookup runs the lookup of the kind query parameter for the key query
+// parameter:
+//   - closest-peers finds the peers closest to a CID, a peer ID or a raw key
+//   - find-peer finds the addresses of a peer ID
+//   - find-providers finds the providers of a CID
+func (h *handler) lookup(w http.ResponseWriter, r *http.Request) {
+	kind, key := r.URL.Query().Get("kind"), r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing key"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), LookupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var peers []peer.AddrInfo
+	switch kind {
+	case "closest-peers":
+		ids, err := h.dht.GetClosestPeers(ctx, parseKey(key))
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		for _, p := range ids {
+			peers = append(peers, h.dht.Host().Peerstore().PeerInfo(p))
+		}
+	case "find-peer":
+		p, err := peer.Decode(key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer: %w", err))
+			return
+		}
+		ai, err := h.dht.FindPeer(ctx, p)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		peers = append(peers, ai)
+	case "find-providers":
+		c, err := cid.Decode(key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cid: %w", err))
+			return
+		}
+		peers, err = h.dht.FindProviders(ctx, c)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown lookup kind %q", kind))
+		return
+	}
+	if peers == nil {
+		peers = []peer.AddrInfo{}
+	}
+	writeJSON(w, http.StatusOK, Lookup{Kind: kind, Key: key, Duration: time.Since(start), Peers: peers})
+}
+
+// parseKey returns the DHT key of a CID or a peer ID, or key itself.
+func parseKey(key string) string {
+	if c, err := cid.Decode(key); err == nil {
+		return string(c.Hash())
+	}
+	if p, err := peer.Decode(key); err == nil {
+		return string(p)
+	}
+	return key
+}
+
+func encodeKey(key string) string {
+	s, _ := multibase.Encode(multibase.Base32, []byte(key))
+	return s
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debugw("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func setupDHT(ctx context.Context, t *testing.T) *dht.IpfsDHT {
+	h, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableReuseport), new(bhost.HostOpts))
+	require.NoError(t, err)
+	h.Start()
+	t.Cleanup(func() { h.Close() })
+
+	d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.DisableAutoRefresh(), dht.ProtocolPrefix("/test"))
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func do(t *testing.T, h http.Handler, method, target string, out interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	if out != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d1, d2 := setupDHT(ctx, t), setupDHT(ctx, t)
+	require.NoError(t, d1.Host().Connect(ctx, peer.AddrInfo{ID: d2.PeerID(), Addrs: d2.Host().Addrs()}))
+	require.Eventually(t, func() bool { return d1.RoutingTable().Find(d2.PeerID()) != "" }, 5*time.Second, time.Millisecond)
+	h := NewHandler(d1)
+
+	var rt RoutingTable
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/routing-table", &rt))
+	require.Equal(t, d1.PeerID(), rt.Self)
+	require.Equal(t, 1, rt.Size)
+	bucket := rt.Buckets[len(rt.Buckets)-1]
+	require.Equal(t, d2.PeerID(), bucket.Peers[0].ID)
+
+	var mode Mode
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/mode", &mode))
+	require.Equal(t, Mode{Configured: "server", Server: true}, mode)
+
+	var div []DiversityStats
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/diversity", &div))
+
+	var e errorResponse
+	require.Equal(t, http.StatusServiceUnavailable, do(t, h, "GET", "/network-size", &e))
+	require.NotEmpty(t, e.Error)
+
+	mh, err := multihash.Sum([]byte("hello"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mh)
+	require.NoError(t, d1.ProviderStore().AddProvider(ctx, c.Hash(), peer.AddrInfo{ID: d2.PeerID()}))
+	var counts ProviderCounts
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/providers", &counts))
+	require.Equal(t, ProviderCounts{Records: 1}, counts)
+
+	var queries []Query
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/queries", &queries))
+	require.Empty(t, queries)
+
+	var ping Ping
+	require.Equal(t, http.StatusOK, do(t, h, "POST", "/ping?peer="+d2.PeerID().String(), &ping))
+	require.Equal(t, d2.PeerID(), ping.Peer)
+	require.Equal(t, http.StatusBadRequest, do(t, h, "POST", "/ping?peer=foo", &e))
+	require.Equal(t, http.StatusMethodNotAllowed, do(t, h, "GET", "/ping", nil))
+
+	var lookup Lookup
+	require.Equal(t, http.StatusOK, do(t, h, "POST", "/lookup?kind=closest-peers&key="+c.String(), &lookup))
+	require.Len(t, lookup.Peers, 1)
+	require.Equal(t, d2.PeerID(), lookup.Peers[0].ID)
+	require.Equal(t, http.StatusOK, do(t, h, "POST", "/lookup?kind=find-peer&key="+d2.PeerID().String(), &lookup))
+	require.Equal(t, d2.PeerID(), lookup.Peers[0].ID)
+	require.Equal(t, http.StatusOK, do(t, h, "POST", "/lookup?kind=find-providers&key="+c.String(), &lookup))
+	require.Equal(t, d2.PeerID(), lookup.Peers[0].ID)
+	require.Equal(t, http.StatusBadRequest, do(t, h, "POST", "/lookup?kind=foo&key=bar", &e))
+
+	var state RefreshState
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/refresh", &state))
+	require.False(t, state.AutoRefresh)
+	require.Zero(t, state.Refreshes)
+	require.Equal(t, http.StatusOK, do(t, h, "POST", "/refresh", &state))
+	require.Equal(t, 1, state.Refreshes)
+	require.Empty(t, state.LastError)
+	require.NotEmpty(t, state.CplLastRefreshedAt)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 
@@ -123,6 +124,10 @@ type IpfsDHT struct {
 	routingTablePeerFilter RouteTableFilterFunc
 	rtPeerDiversityFilter  peerdiversity.PeerIPGroupFilter
 
+	// closerPeers is where the closer peers sent to other peers come from, nil
+	// for the routing table. See CloserPeers.
+	closerPeers CloserPeersFunc
+
 	autoRefresh bool
 
 	// timeout for the lookupCheck operation
@@ -138,6 +143,9 @@ type IpfsDHT struct {
 
 	maxRecordAge time.Duration
 
+	// how often expired and invalid value records are removed, zero if never
+	recordGCInterval time.Duration
+
 	// Allows disabling dht subsystems. These should _only_ be set on
 	// "forked" DHTs (e.g., DHTs with custom protocols and/or private
 	// networks).
@@ -158,6 +166,59 @@ type IpfsDHT struct {
 	// a bound channel to limit asynchronicity of in-flight ADD_PROVIDER RPCs
 	optProvJobsPool chan struct{}
 
+	// sign the provider records we announce, and only accept signed ones
+	// when looking for providers
+	signProviderRecords, requireSignedProviderRecords bool
+
+	// also announce encrypted provider records under the double-hashed key,
+	// and look for providers by double-hashed key only
+	encryptProviderRecords, privateLookups bool
+
+	// length of the key prefix sent when looking for providers, 0 if the
+	// whole key is sent, and cap on the provider records served by prefix
+	prefixLookupBits, maxPrefixLookupResults int
+	prefixLookupFallback, servePrefixLookups bool
+
+	// minimum time a lookup waits for a peer before giving its slot to
+	// another one if the peer is slower than expected, 0 if disabled
+	slowPeerMinDelay time.Duration
+
+	// percentile of the recent lookup request latencies after which a
+	// lookup hedges a request, 0 if disabled, and cap on the hedged
+	// requests in flight per lookup
+	hedgePercentile   float64
+	maxHedgedRequests int
+	queryLatencies    *queryLatencies
+
+	// number of disjoint paths lookups are split into
+	disjointPaths int
+
+	// recent GetClosestPeers results seeding lookups, nil if disabled
+	lookupCache *lookupCache
+
+	// traffic exchanged per remote peer and operation, nil if disabled
+	traffic *trafficLedger
+
+	// lookups in progress
+	inFlight inFlightQueries
+
+	// re-announces provided keys, nil if disabled
+	reprovider *reprovider
+
+	// republishes value records, nil if disabled
+	republisher *republisher
+
+	// limits what remote peers can send and store, nil if unlimited
+	admission *admission
+
+	// bounds the number of inbound requests handled concurrently, nil if
+	// unbounded
+	scheduler *requestScheduler
+
+	// where routing table snapshots are saved, nil if disabled
+	rtSnapshotStore    ds.Batching
+	rtSnapshotInterval time.Duration
+
 	// configuration variables for tests
 	testAddressUpdateProcessing bool
 
@@ -203,13 +264,19 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 	dht.autoRefresh = cfg.RoutingTable.AutoRefresh
 
 	dht.maxRecordAge = cfg.MaxRecordAge
+	dht.recordGCInterval = cfg.RecordGCInterval
 	dht.enableProviders = cfg.EnableProviders
 	dht.enableValues = cfg.EnableValues
 	dht.disableFixLowPeers = cfg.DisableFixLowPeers
 
 	dht.Validator = cfg.Validator
 	dht.msgSender = cfg.MsgSenderBuilder(h, dht.protocols)
-	dht.protoMessenger, err = pb.NewProtocolMessenger(dht.msgSender)
+	var msgSender pb.MessageSender = dht.msgSender
+	if cfg.TrafficAccounting.MaxPeers > 0 {
+		dht.traffic = newTrafficLedger(cfg.TrafficAccounting.MaxPeers)
+		msgSender = &accountingMessageSender{MessageSender: dht.msgSender, ledger: dht.traffic}
+	}
+	dht.protoMessenger, err = pb.NewProtocolMessenger(msgSender)
 	if err != nil {
 		return nil, err
 	}
@@ -250,6 +317,22 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 
 	dht.rtRefreshManager.Start()
 
+	if dht.reprovider != nil {
+		dht.reprovider.start()
+	}
+
+	if dht.republisher != nil {
+		dht.republisher.start()
+	}
+
+	if dht.enableValues && dht.recordGCInterval > 0 {
+		dht.runRecordGCLoop()
+	}
+
+	if dht.rtSnapshotStore != nil {
+		dht.runRTSnapshotLoop()
+	}
+
 	// listens to the fix low peers chan and tries to fix the Routing Table
 	if !dht.disableFixLowPeers {
 		dht.runFixLowPeersLoop()
@@ -310,6 +393,7 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 		rtPeerDiversityFilter:  cfg.RoutingTable.DiversityFilter,
 		addrFilter:             cfg.AddressFilter,
 		onRequestHook:          cfg.OnRequestHook,
+		closerPeers:            cfg.CloserPeers,
 
 		fixLowPeersChan: make(chan struct{}, 1),
 
@@ -318,6 +402,29 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 
 		enableOptProv:   cfg.EnableOptimisticProvide,
 		optProvJobsPool: nil,
+
+		signProviderRecords:          cfg.SignProviderRecords,
+		requireSignedProviderRecords: cfg.RequireSignedProviderRecords,
+
+		encryptProviderRecords: cfg.EncryptProviderRecords,
+		privateLookups:         cfg.PrivateLookups,
+
+		prefixLookupBits:       cfg.PrefixLookups.Bits,
+		prefixLookupFallback:   cfg.PrefixLookups.FullKeyFallback,
+		servePrefixLookups:     cfg.PrefixLookups.Serve,
+		maxPrefixLookupResults: cfg.PrefixLookups.MaxResults,
+
+		slowPeerMinDelay: cfg.SlowPeerMinDelay,
+
+		hedgePercentile:   cfg.Hedging.Percentile,
+		maxHedgedRequests: cfg.Hedging.MaxExtra,
+		queryLatencies:    newQueryLatencies(),
+
+		disjointPaths: cfg.DisjointPaths,
+	}
+
+	if cfg.LookupCache.Size > 0 {
+		dht.lookupCache = newLookupCache(cfg.LookupCache.Size, cfg.LookupCache.TTL)
 	}
 
 	var maxLastSuccessfulOutboundThreshold time.Duration
@@ -365,12 +472,35 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 	if cfg.ProviderStore != nil {
 		dht.providerStore = cfg.ProviderStore
 	} else {
-		dht.providerStore, err = providers.NewProviderManager(h.ID(), dht.peerstore, cfg.Datastore)
+		var pmOpts []providers.Option
+		if cfg.PrefixLookups.Serve {
+			pmOpts = append(pmOpts, providers.IndexByPrefix())
+		}
+		dht.providerStore, err = providers.NewProviderManager(h.ID(), dht.peerstore, cfg.Datastore, pmOpts...)
 		if err != nil {
 			return nil, fmt.Errorf("initializing default provider manager (%v)", err)
 		}
 	}
 
+	if cfg.Reprovider.Enabled && cfg.EnableProviders {
+		dht.reprovider = newReprovider(dht, cfg.Datastore, cfg.Reprovider.Interval)
+	}
+
+	if cfg.Republisher.Enabled && cfg.EnableValues {
+		dht.republisher = newRepublisher(dht, cfg.Datastore, cfg.Republisher.Interval)
+	}
+
+	dht.admission, err = newAdmission(cfg, providers.ProvideValidity, dht.providerStore)
+	if err != nil {
+		return nil, err
+	}
+	dht.scheduler = newRequestScheduler(cfg)
+
+	if cfg.RoutingTable.Persist {
+		dht.rtSnapshotStore = cfg.Datastore
+		dht.rtSnapshotInterval = cfg.RoutingTable.SnapshotInterval
+	}
+
 	dht.rtFreezeTimeout = rtFreezeTimeout
 
 	return dht, nil
@@ -380,7 +510,7 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 // answer it correctly
 func (dht *IpfsDHT) lookupCheck(ctx context.Context, p peer.ID) error {
 	// lookup request to p requesting for its own peer.ID
-	peerids, err := dht.protoMessenger.GetClosestPeers(ctx, p, p)
+	peerids, err := dht.protoMessenger.GetClosestPeers(ContextWithOperation(ctx, OperationRefresh), p, p)
 	// p is expected to return at least 1 peer id, unless our routing table has
 	// less than bucketSize peers, in which case we aren't picky about who we
 	// add to the routing table.
@@ -397,7 +527,7 @@ func makeRtRefreshManager(dht *IpfsDHT, cfg dhtcfg.Config, maxLastSuccessfulOutb
 	}
 
 	queryFnc := func(ctx context.Context, key string) error {
-		_, err := dht.GetClosestPeers(ctx, key)
+		_, err := dht.GetClosestPeers(ContextWithOperation(ctx, OperationRefresh), key)
 		return err
 	}
 
@@ -447,6 +577,8 @@ func makeRoutingTable(dht *IpfsDHT, cfg dhtcfg.Config, maxLastSuccessfulOutbound
 		cmgr.Unprotect(p, kbucketTag)
 		cmgr.UntagPeer(p, kbucketTag)
 
+		dht.invalidateCachedLookups(p)
+
 		// try to fix the RT
 		dht.fixRTIfNeeded()
 	}
@@ -469,6 +601,13 @@ func (dht *IpfsDHT) Mode() ModeOpt {
 	return dht.auto
 }
 
+// IsServer reports whether the DHT currently answers the requests of remote
+// peers. In ModeAuto and ModeAutoServer, it changes with the reachability of
+// the node.
+func (dht *IpfsDHT) IsServer() bool {
+	return dht.getMode() == modeServer
+}
+
 // runFixLowPeersLoop manages simultaneous requests to fixLowPeers
 func (dht *IpfsDHT) runFixLowPeersLoop() {
 	dht.wg.Add(1)
@@ -505,10 +644,14 @@ func (dht *IpfsDHT) fixLowPeers() {
 		dht.peerFound(p)
 	}
 
-	// TODO Active Bootstrapping
-	// We should first use non-bootstrap peers we knew of from previous
-	// snapshots of the Routing Table before we connect to the bootstrappers.
+	// Active Bootstrapping
+	// We first use non-bootstrap peers we knew of from previous snapshots of
+	// the Routing Table before we connect to the bootstrappers.
 	// See https://github.com/libp2p/go-libp2p-kad-dht/issues/387.
+	if dht.routingTable.Size() == 0 && dht.rtSnapshotStore != nil {
+		dht.restoreRoutingTable()
+	}
+
 	if dht.routingTable.Size() == 0 && dht.bootstrapPeers != nil {
 		bootstrapPeers := dht.bootstrapPeers()
 		if len(bootstrapPeers) == 0 {
@@ -604,6 +747,22 @@ func (dht *IpfsDHT) putLocal(ctx context.Context, key string, rec *recpb.Record)
 	return dht.datastore.Put(ctx, mkDsKey(key), data)
 }
 
+// putLock returns the striped lock serializing the writes of the record key.
+func (dht *IpfsDHT) putLock(key []byte) *sync.Mutex {
+	var indexForLock byte
+	if len(key) > 0 {
+		indexForLock = key[len(key)-1]
+	}
+	return &dht.stripedPutLocks[indexForLock]
+}
+
+// closeToKey returns whether this node is one of the bucketSize closest peers
+// to key in its routing table.
+func (dht *IpfsDHT) closeToKey(key string) bool {
+	nearest := dht.routingTable.NearestPeers(kb.ConvertKey(key), dht.bucketSize)
+	return len(nearest) < dht.bucketSize || kb.Closer(dht.self, nearest[len(nearest)-1], key)
+}
+
 func (dht *IpfsDHT) rtPeerLoop() {
 	dht.wg.Add(1)
 	go func() {
@@ -745,9 +904,18 @@ func (dht *IpfsDHT) FindLocal(ctx context.Context, id peer.ID) peer.AddrInfo {
 	return peer.AddrInfo{}
 }
 
+// queryTarget returns the Kademlia ID looked for by pmes.
+func queryTarget(pmes *pb.Message) kb.ID {
+	if pmes.GetKeyPrefixBits() > 0 {
+		// the key is a prefix of the Kademlia ID looked for
+		return prefixKadID(pmes.GetKey())
+	}
+	return kb.ConvertKey(string(pmes.GetKey()))
+}
+
 // nearestPeersToQuery returns the routing tables closest peers.
 func (dht *IpfsDHT) nearestPeersToQuery(pmes *pb.Message, count int) []peer.ID {
-	closer := dht.routingTable.NearestPeers(kb.ConvertKey(string(pmes.GetKey())), count)
+	closer := dht.routingTable.NearestPeers(queryTarget(pmes), count)
 	return closer
 }
 
@@ -781,6 +949,30 @@ func (dht *IpfsDHT) betterPeersToQuery(pmes *pb.Message, from peer.ID, count int
 	return filtered
 }
 
+// closerPeerInfos returns the count closest peers to send in response to pmes,
+// along with their addresses, excluding the requester. They come from the
+// CloserPeers option if set, from the routing table otherwise.
+func (dht *IpfsDHT) closerPeerInfos(pmes *pb.Message, from peer.ID, count int) []peer.AddrInfo {
+	if dht.closerPeers == nil {
+		// TODO: pstore.PeerInfos should move to core (=> peerstore.AddrInfos).
+		return pstore.PeerInfos(dht.peerstore, dht.betterPeersToQuery(pmes, from, count))
+	}
+
+	// ask for two more peers, in case the requester and self are among them
+	closer := dht.closerPeers(queryTarget(pmes), count+2)
+	infos := make([]peer.AddrInfo, 0, count)
+	for _, ai := range closer {
+		if ai.ID == from || ai.ID == dht.self {
+			continue
+		}
+		infos = append(infos, ai)
+		if len(infos) == count {
+			break
+		}
+	}
+	return infos
+}
+
 func (dht *IpfsDHT) setMode(m mode) error {
 	dht.modeLk.Lock()
 	defer dht.modeLk.Unlock()
@@ -859,6 +1051,12 @@ func (dht *IpfsDHT) Close() error {
 	dht.cancel()
 	dht.wg.Wait()
 
+	if dht.rtSnapshotStore != nil {
+		if err := dht.snapshotRoutingTable(context.Background()); err != nil {
+			logger.Warnw("failed to save routing table snapshot", "error", err)
+		}
+	}
+
 	var wg sync.WaitGroup
 	closes := [...]func() error{
 		dht.rtRefreshManager.Close,
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/libp2p/go-libp2p-kad-dht/rtrefresh"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	"github.com/multiformats/go-multiaddr"
@@ -82,3 +83,8 @@ func (dht *IpfsDHT) RefreshRoutingTable() <-chan error {
 func (dht *IpfsDHT) ForceRefresh() <-chan error {
 	return dht.rtRefreshManager.Refresh(true)
 }
+
+// RefreshState returns the state of the routing table refreshes.
+func (dht *IpfsDHT) RefreshState() rtrefresh.State {
+	return dht.rtRefreshManager.State()
+}
@@ -52,6 +52,11 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 		var req pb.Message
 		msgbytes, err := r.ReadMsg()
 		msgLen := len(msgbytes)
+		if ctx.Err() != nil {
+			// the DHT was closed while waiting for the request
+			r.ReleaseMsg(msgbytes)
+			return false
+		}
 		if err != nil {
 			r.ReleaseMsg(msgbytes)
 			if err == io.EOF {
@@ -88,6 +93,9 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 		ctx := metrics.ContextWithAttributes(ctx, attrMsgType)
 
 		metrics.RecordMessageRecvOK(ctx, int64(msgLen))
+		if dht.traffic != nil {
+			dht.traffic.record(mPeer, OperationServe, Traffic{MessagesReceived: 1, BytesReceived: uint64(msgLen)})
+		}
 
 		if dht.onRequestHook != nil {
 			dht.onRequestHook(ctx, s, &req)
@@ -103,12 +111,42 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 			return false
 		}
 
+		if dht.admission != nil {
+			if err := dht.admission.allow(ctx, req.GetType(), mPeer); err != nil {
+				if c := baseLogger.Check(zap.DebugLevel, "rejecting message"); c != nil {
+					c.Write(zap.String("from", mPeer.String()),
+						zap.Int32("type", int32(req.GetType())),
+						zap.Error(err))
+				}
+				return false
+			}
+		}
+
+		// while the node is overloaded, requests are shed instead of handled
+		release := func() {}
+		if dht.scheduler != nil {
+			release, err = dht.scheduler.acquire(ctx, req.GetType())
+			if err != nil {
+				metrics.RecordRequestRejected(ctx, rejectOverloaded)
+				if c := baseLogger.Check(zap.DebugLevel, "shedding message"); c != nil {
+					c.Write(zap.String("from", mPeer.String()),
+						zap.Int32("type", int32(req.GetType())),
+						zap.Error(err))
+				}
+				handler, release = dht.shedHandler(req.GetType()), func() {}
+				if handler == nil {
+					return false
+				}
+			}
+		}
+
 		if c := baseLogger.Check(zap.DebugLevel, "handling message"); c != nil {
 			c.Write(zap.String("from", mPeer.String()),
 				zap.Int32("type", int32(req.GetType())),
 				zap.Binary("key", req.GetKey()))
 		}
 		resp, err := handler(ctx, mPeer, &req)
+		release()
 		if err != nil {
 			metrics.RecordMessageHandleErr(ctx)
 			if c := baseLogger.Check(zap.DebugLevel, "error handling message"); c != nil {
@@ -144,6 +182,10 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 			return false
 		}
 
+		if dht.traffic != nil {
+			dht.traffic.record(mPeer, OperationServe, Traffic{MessagesSent: 1, BytesSent: uint64(proto.Size(resp))})
+		}
+
 		elapsedTime := time.Since(startTime)
 
 		if c := baseLogger.Check(zap.DebugLevel, "responded to message"); c != nil {
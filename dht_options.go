@@ -3,6 +3,7 @@ package dht
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -221,6 +222,25 @@ func MaxRecordAge(maxAge time.Duration) Option {
 	}
 }
 
+// RecordGCInterval configures how often the value records stored locally are
+// scanned, to remove the ones older than MaxRecordAge or that no longer pass
+// validation. Otherwise records are only removed when they are read.
+//
+// Every run lists all the keys of the datastore, including the ones of the
+// provider records and of anything else sharing the datastore, and reads the
+// entries at its root. This can be expensive on large datastores.
+//
+// A zero interval disables the garbage collection. Disabled by default.
+func RecordGCInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if interval < 0 {
+			return fmt.Errorf("record GC interval must not be negative, got %s", interval)
+		}
+		c.RecordGCInterval = interval
+		return nil
+	}
+}
+
 // DisableAutoRefresh completely disables 'auto-refresh' on the DHT routing
 // table. This means that we will neither refresh the routing table periodically
 // nor when the routing table size goes below the minimum threshold.
@@ -305,6 +325,35 @@ func RoutingTablePeerDiversityFilter(pg peerdiversity.PeerIPGroupFilter) Option
 	}
 }
 
+// RoutingTablePersistence enables saving snapshots of the routing table peers,
+// along with their addresses, to the DHT datastore every
+// RoutingTableSnapshotInterval and when the DHT is closed. Whenever the
+// routing table is empty, e.g. after a restart, the peers from the last
+// snapshot that still answer DHT requests are added back before falling back
+// to the bootstrap peers.
+//
+// Defaults to disabled.
+func RoutingTablePersistence(enable bool) Option {
+	return func(c *dhtcfg.Config) error {
+		c.RoutingTable.Persist = enable
+		return nil
+	}
+}
+
+// RoutingTableSnapshotInterval configures how often a snapshot of the routing
+// table is saved when RoutingTablePersistence is enabled.
+//
+// The default value is 10 minutes.
+func RoutingTableSnapshotInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("routing table snapshot interval must be positive, got %s", interval)
+		}
+		c.RoutingTable.SnapshotInterval = interval
+		return nil
+	}
+}
+
 // disableFixLowPeersRoutine disables the "fixLowPeers" routine in the DHT.
 // This is ONLY for tests.
 func disableFixLowPeersRoutine(t *testing.T) Option {
@@ -352,6 +401,263 @@ func OptimisticProvideJobsPoolSize(size int) Option {
 	}
 }
 
+// EnableSignedProviderRecords attaches a provider record signed with the host's
+// private key to every provider announcement sent by the DHT. Peers storing the
+// announcement keep the signed record and hand it out to clients looking for
+// providers, which lets them verify the addresses of this node end-to-end.
+//
+// Defaults to disabled.
+func EnableSignedProviderRecords() Option {
+	return func(c *dhtcfg.Config) error {
+		c.SignProviderRecords = true
+		return nil
+	}
+}
+
+// RequireSignedProviderRecords restricts the providers returned by
+// FindProviders and FindProvidersAsync to the ones whose addresses are backed
+// by a valid signed provider record. Signed provider records are always
+// verified when present, and their addresses take precedence over the unsigned
+// ones regardless of this option.
+//
+// Defaults to disabled.
+func RequireSignedProviderRecords() Option {
+	return func(c *dhtcfg.Config) error {
+		c.RequireSignedProviderRecords = true
+		return nil
+	}
+}
+
+// EnableEncryptedProviderRecords makes Provide and ProvideMany also announce a
+// provider record encrypted with a key derived from the provided multihash. It
+// is stored under the double-hashed multihash (see providers.DoubleHash) by the
+// peers closest to it, so that clients using EnablePrivateLookups can find this
+// node without revealing which content they are looking for. Failing to
+// announce the encrypted record doesn't fail the provide.
+//
+// Defaults to disabled.
+func EnableEncryptedProviderRecords() Option {
+	return func(c *dhtcfg.Config) error {
+		c.EncryptProviderRecords = true
+		return nil
+	}
+}
+
+// EnablePrivateLookups makes FindProviders and FindProvidersAsync look for
+// providers by double-hashed multihash, so that the peers queried along the
+// way don't learn which content is looked for. Only the providers announcing
+// encrypted provider records (see EnableEncryptedProviderRecords) can be
+// found this way, there is no fallback to regular lookups.
+//
+// Defaults to disabled.
+func EnablePrivateLookups() Option {
+	return func(c *dhtcfg.Config) error {
+		c.PrivateLookups = true
+		return nil
+	}
+}
+
+// PrefixLookups makes FindProviders and FindProvidersAsync only send the first
+// bits bits of the Kademlia ID of the key looked for, rather than the key
+// itself. The peers queried return the provider records of every key matching
+// the prefix and the unrelated ones are filtered out locally, so that they
+// can't tell which of these keys is looked for. Shorter prefixes hide the key
+// among more keys, but the lookup may miss providers once more than the
+// bucket size of peers share the prefix. Signed provider records aren't
+// returned by prefix, see RequireSignedProviderRecords.
+//
+// Prefix lookups are an extension of GET_PROVIDERS, see ServePrefixLookups.
+// The other peers answer with the providers of the prefix itself, only the closer
+// peers they return are used, so providers known only to them aren't found.
+// See PrefixLookupsFullKeyFallback.
+//
+// Defaults to 0, which disables prefix lookups.
+func PrefixLookups(bits int) Option {
+	return func(c *dhtcfg.Config) error {
+		if bits < 0 || bits > 256 {
+			return fmt.Errorf("prefix length must be between 0 and 256 bits, got %d", bits)
+		}
+		c.PrefixLookups.Bits = bits
+		return nil
+	}
+}
+
+// PrefixLookupsFullKeyFallback makes the lookups by prefix (see PrefixLookups)
+// ask the peers that don't support them for the full key instead, which finds
+// the providers they know of but reveals the key looked for to them.
+//
+// Defaults to disabled.
+func PrefixLookupsFullKeyFallback() Option {
+	return func(c *dhtcfg.Config) error {
+		c.PrefixLookups.FullKeyFallback = true
+		return nil
+	}
+}
+
+// ServePrefixLookups answers the lookups by prefix of other peers (see
+// PrefixLookups), otherwise they are answered as regular GET_PROVIDERS
+// requests. The default provider store then indexes the provider records by
+// prefix, see providers.IndexByPrefix. A custom ProviderStore must implement
+// providers.PrefixProviderStore.
+//
+// Defaults to disabled.
+func ServePrefixLookups() Option {
+	return func(c *dhtcfg.Config) error {
+		c.PrefixLookups.Serve = true
+		return nil
+	}
+}
+
+// MaxPrefixLookupResults caps the number of provider records returned to a
+// single lookup by prefix (see ServePrefixLookups).
+//
+// Defaults to 256.
+func MaxPrefixLookupResults(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		if n <= 0 {
+			return fmt.Errorf("max prefix lookup results must be positive, got %d", n)
+		}
+		c.PrefixLookups.MaxResults = n
+		return nil
+	}
+}
+
+// SlowPeerReplacement makes lookups stop waiting on peers answering slower than
+// expected. Once a request has been running for longer than both the latency
+// expected from the peer and minDelay, the peer is considered slow and its
+// concurrency slot is given to the next closest peer. The slow request isn't
+// cancelled, and its response is still used. A lookup runs at most as many
+// requests on top of its concurrency (see Concurrency) at a time, unless
+// HedgedRequests sets another limit.
+//
+// The expected latency is estimated by the message sender, see
+// pb.MessageSenderWithLatency. Peers with an unknown latency are never
+// considered slow.
+//
+// Defaults to 0, which disables slow peer replacement.
+func SlowPeerReplacement(minDelay time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if minDelay < 0 {
+			return fmt.Errorf("slow peer delay must not be negative, got %s", minDelay)
+		}
+		c.SlowPeerMinDelay = minDelay
+		return nil
+	}
+}
+
+// DisjointPaths splits lookups into d disjoint paths, as described by
+// S/Kademlia. The seed peers of a lookup are dealt to the paths, and each path
+// then runs its own lookup, ignoring the peers another path heard of first, so
+// that no peer is used by two paths. The closest peers found by all the paths
+// are merged once they all terminated.
+//
+// A lookup then finds the closest peers to its target as long as one of its
+// paths doesn't go through an adversarial peer, at the cost of d times more
+// concurrent requests (see Concurrency).
+//
+// Defaults to 1.
+func DisjointPaths(d int) Option {
+	return func(c *dhtcfg.Config) error {
+		if d < 1 {
+			return fmt.Errorf("number of disjoint paths must be positive, got %d", d)
+		}
+		c.DisjointPaths = d
+		return nil
+	}
+}
+
+// LookupCache keeps the closest peers found by the size latest GetClosestPeers
+// lookups for ttl. Lookups for targets close to cached ones start from the
+// closest cached peers as well as from the closest peers in the routing table,
+// which saves hops to bursts of lookups for nearby keys, e.g. when providing
+// many keys. Cached results are dropped once one of their peers is removed from
+// the routing table or fails to answer a request.
+//
+// Defaults to a size of 0, which disables the cache.
+func LookupCache(size int, ttl time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if size < 0 {
+			return fmt.Errorf("lookup cache size must not be negative, got %d", size)
+		}
+		if size > 0 && ttl <= 0 {
+			return fmt.Errorf("lookup cache ttl must be positive, got %s", ttl)
+		}
+		c.LookupCache.Size = size
+		c.LookupCache.TTL = ttl
+		return nil
+	}
+}
+
+// TrafficAccounting accounts the messages and bytes exchanged with remote peers
+// to each of them, and to the operation they were exchanged for (see
+// Operation). Only the maxPeers peers exchanged with the most recently are
+// remembered. The heaviest peers and operations are returned by
+// IpfsDHT.TopPeersByTraffic and IpfsDHT.TopOperationsByTraffic.
+//
+// Defaults to 0, which disables traffic accounting.
+func TrafficAccounting(maxPeers int) Option {
+	return func(c *dhtcfg.Config) error {
+		if maxPeers < 0 {
+			return fmt.Errorf("traffic accounting max peers must not be negative, got %d", maxPeers)
+		}
+		c.TrafficAccounting.MaxPeers = maxPeers
+		return nil
+	}
+}
+
+// HedgedRequests makes lookups send extra requests to cut their tail latency.
+// Once a request has been running for longer than the given percentile of the
+// latencies of the latest requests sent by lookups, the next closest peer not
+// queried yet is queried as well. The slow request isn't cancelled.
+//
+// A lookup runs at most maxExtra requests on top of its concurrency (see
+// Concurrency) at a time, including the ones replacing slow peers (see
+// SlowPeerReplacement).
+//
+// Defaults to a percentile of 0, which disables hedging.
+func HedgedRequests(percentile float64, maxExtra int) Option {
+	return func(c *dhtcfg.Config) error {
+		if percentile < 0 || percentile > 100 {
+			return fmt.Errorf("hedging percentile must be between 0 and 100, got %v", percentile)
+		}
+		if percentile > 0 && maxExtra <= 0 {
+			return fmt.Errorf("max extra hedged requests must be positive, got %d", maxExtra)
+		}
+		c.Hedging.Percentile = percentile
+		c.Hedging.MaxExtra = maxExtra
+		return nil
+	}
+}
+
+// EnableReprovider enables the built-in reprovider. Every key announced with
+// Provide (with brdcst set to true) is remembered in the DHT datastore and
+// re-announced to the network every ReprovideInterval, so that provider
+// records don't expire after amino.DefaultProvideValidity. Keys can be removed
+// from the reprovided set with StopProviding.
+//
+// Defaults to disabled.
+func EnableReprovider() Option {
+	return func(c *dhtcfg.Config) error {
+		c.Reprovider.Enabled = true
+		return nil
+	}
+}
+
+// ReprovideInterval configures how often the reprovider re-announces the
+// provided keys. It must be shorter than amino.DefaultProvideValidity,
+// otherwise records would expire between two runs.
+//
+// The default value is amino.DefaultReprovideInterval
+func ReprovideInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if interval <= 0 || interval >= amino.DefaultProvideValidity {
+			return fmt.Errorf("reprovide interval must be in (0, %s), got %s", amino.DefaultProvideValidity, interval)
+		}
+		c.Reprovider.Interval = interval
+		return nil
+	}
+}
+
 // AddressFilter allows to configure the address filtering function.
 // This function is run before addresses are added to the peerstore.
 // It is most useful to avoid adding localhost / local addresses.
@@ -381,3 +687,172 @@ func OnRequestHook(f func(ctx context.Context, s network.Stream, req *pb.Message
 		return nil
 	}
 }
+
+// CloserPeersFunc returns the count peers closest to target known to the node,
+// along with their addresses.
+type CloserPeersFunc = dhtcfg.CloserPeersFunc
+
+// CloserPeers sets where the closer peers sent in response to the requests of
+// other peers come from, instead of the routing table. It lets DHTs that know
+// more of the network than the routing table holds, like fullrt.FullRT, serve
+// requests with the handlers of the IpfsDHT.
+func CloserPeers(f CloserPeersFunc) Option {
+	return func(c *dhtcfg.Config) error {
+		c.CloserPeers = f
+		return nil
+	}
+}
+
+// MaxRecordsPerPeer limits the number of value records a single remote peer
+// can store on this node with PUT_VALUE. Records count towards the limit until
+// they expire (see MaxRecordAge).
+//
+// Defaults to unlimited.
+func MaxRecordsPerPeer(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		if n < 0 {
+			return fmt.Errorf("max records per peer must not be negative, got %d", n)
+		}
+		c.Admission.MaxRecordsPerPeer = n
+		return nil
+	}
+}
+
+// MaxProvidersPerPeer limits the number of keys a single remote peer can
+// announce itself as a provider for with ADD_PROVIDER. Provider records count
+// towards the limit until they expire (see amino.DefaultProvideValidity).
+//
+// Defaults to unlimited.
+func MaxProvidersPerPeer(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		if n < 0 {
+			return fmt.Errorf("max providers per peer must not be negative, got %d", n)
+		}
+		c.Admission.MaxProvidersPerPeer = n
+		return nil
+	}
+}
+
+// MaxStoredRecords sets the global storage budget of the node: the number of
+// provider records held by the provider store, as counted by
+// providers.ProviderManager.CountProviders, including the ones stored before
+// the node started. ADD_PROVIDER requests are rejected once the budget is
+// exhausted, the records are released from it once garbage collected. Value
+// records are only limited per peer, see MaxRecordsPerPeer.
+//
+// A custom ProviderStore must count its records the same way.
+//
+// Defaults to unlimited.
+func MaxStoredRecords(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		if n < 0 {
+			return fmt.Errorf("max stored records must not be negative, got %d", n)
+		}
+		c.Admission.MaxStoredRecords = n
+		return nil
+	}
+}
+
+// RateLimit limits how many requests of type t each remote peer can send,
+// using a token bucket refilled with perSecond tokens every second and holding
+// at most burst tokens. Requests exceeding the limit are dropped and their
+// stream is reset.
+//
+// Defaults to unlimited for every message type.
+func RateLimit(t pb.Message_MessageType, perSecond float64, burst int) Option {
+	return func(c *dhtcfg.Config) error {
+		if perSecond <= 0 || burst < 1 {
+			return fmt.Errorf("invalid rate limit for %s: rate %f, burst %d", t, perSecond, burst)
+		}
+		if c.Admission.RateLimits == nil {
+			c.Admission.RateLimits = make(map[pb.Message_MessageType]dhtcfg.RateLimit)
+		}
+		c.Admission.RateLimits[t] = dhtcfg.RateLimit{Rate: perSecond, Burst: burst}
+		return nil
+	}
+}
+
+// MaxConcurrentRequests bounds the number of inbound requests handled at the
+// same time. Requests beyond the limit wait in a queue, and are served by
+// priority (see RequestPriority) as handlers complete.
+//
+// When the queue is full, or a request waited longer than the queue timeout
+// (see RequestQueue), load is shed: FIND_NODE, GET_VALUE and GET_PROVIDERS
+// requests are answered with closer peers only, and other requests have their
+// stream reset.
+//
+// Defaults to unbounded.
+func MaxConcurrentRequests(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		if n < 0 {
+			return fmt.Errorf("max concurrent requests must not be negative, got %d", n)
+		}
+		c.RequestScheduler.MaxConcurrency = n
+		return nil
+	}
+}
+
+// RequestQueue configures how many inbound requests can wait for a handler
+// when MaxConcurrentRequests is reached, and how long they can wait before
+// load is shed.
+//
+// Defaults to 256 requests waiting at most one second.
+func RequestQueue(size int, timeout time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if size < 0 || timeout <= 0 {
+			return fmt.Errorf("invalid request queue: size %d, timeout %s", size, timeout)
+		}
+		c.RequestScheduler.QueueSize = size
+		c.RequestScheduler.QueueTimeout = timeout
+		return nil
+	}
+}
+
+// RequestPriority sets the priority of inbound requests of type t when they
+// are queued because of MaxConcurrentRequests. Requests with a lower value are
+// served first, requests with the same priority are served in order.
+//
+// Defaults to 0 for PING and FIND_NODE, 1 for GET_VALUE, 2 for PUT_VALUE and
+// ADD_PROVIDER, and 3 for GET_PROVIDERS, GET_ENCRYPTED_PROVIDERS and
+// GET_PROVIDERS_BATCH.
+func RequestPriority(t pb.Message_MessageType, priority int) Option {
+	return func(c *dhtcfg.Config) error {
+		priorities := make(map[pb.Message_MessageType]int, len(c.RequestScheduler.Priorities)+1)
+		for k, v := range c.RequestScheduler.Priorities {
+			priorities[k] = v
+		}
+		priorities[t] = priority
+		c.RequestScheduler.Priorities = priorities
+		return nil
+	}
+}
+
+// EnableRepublisher enables the built-in record republisher. Records put
+// with PutValue, as well as records received from peers while this node is
+// among the closest peers to their key, are remembered in the DHT datastore.
+// Every RepublishInterval they are pushed to the closest peers that don't have
+// them or hold an older version. Records authored locally can be removed from
+// the republished set with StopRepublishing.
+//
+// Defaults to disabled.
+func EnableRepublisher() Option {
+	return func(c *dhtcfg.Config) error {
+		c.Republisher.Enabled = true
+		return nil
+	}
+}
+
+// RepublishInterval configures how often the republisher pushes records to
+// the closest peers. It should be shorter than MaxRecordAge, otherwise records
+// would expire between two runs.
+//
+// The default value is amino.DefaultRepublishInterval
+func RepublishInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("republish interval must be positive, got %s", interval)
+		}
+		c.Republisher.Interval = interval
+		return nil
+	}
+}
@@ -198,6 +198,33 @@ func connect(t *testing.T, ctx context.Context, a, b *IpfsDHT) {
 	wait(t, ctx, b, a)
 }
 
+// providerCheck reports whether a server stores the expected provider records
+// of a key.
+type providerCheck func(s *IpfsDHT, key multihash.Multihash) bool
+
+// storesProviders checks that a server stores n providers of the key.
+func storesProviders(n int) providerCheck {
+	return func(s *IpfsDHT, key multihash.Multihash) bool {
+		provs, err := s.providerStore.GetProviders(context.Background(), key)
+		return err == nil && len(provs) == n
+	}
+}
+
+// waitForProviders waits until check passes for key on every server.
+// ADD_PROVIDER messages are sent without waiting for a response, so the
+// records aren't stored yet when Provide returns.
+func waitForProviders(t *testing.T, servers []*IpfsDHT, key multihash.Multihash, check providerCheck) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		for _, s := range servers {
+			if !check(s, key) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond, "provider records of %s not stored", key)
+}
+
 func bootstrap(t *testing.T, ctx context.Context, dhts []*IpfsDHT) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -2010,6 +2037,19 @@ func TestPing(t *testing.T) {
 	assert.NoError(t, ds[0].Ping(context.Background(), ds[1].PeerID()))
 }
 
+func TestNoRequestsServedAfterClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := setupDHTS(t, ctx, 2)
+	ds[0].Host().Peerstore().AddAddrs(ds[1].PeerID(), ds[1].Host().Addrs(), peerstore.AddressTTL)
+	require.NoError(t, ds[0].Ping(ctx, ds[1].PeerID()))
+
+	// the stream of the first ping is still open, requests sent on it aren't
+	// answered anymore
+	require.NoError(t, ds[1].Close())
+	require.Error(t, ds[0].Ping(ctx, ds[1].PeerID()))
+}
+
 func TestClientModeAtInit(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// pathClaims assigns each peer heard of by a disjoint lookup to the single path
+// allowed to use it. It is safe for concurrent use.
+type pathClaims struct {
+	mu    sync.Mutex
+	owner map[peer.ID]int
+}
+
+// newPathClaims returns the claims of paths, each path claiming its seed peers.
+func newPathClaims(paths [][]peer.ID) *pathClaims {
+	c := &pathClaims{owner: make(map[peer.ID]int)}
+	for i, seeds := range paths {
+		for _, p := range seeds {
+			c.owner[p] = i
+		}
+	}
+	return c
+}
+
+// claim assigns p to path, unless another path heard of it first. It returns
+// whether path may use p.
+func (c *pathClaims) claim(p peer.ID, path int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	owner, ok := c.owner[p]
+	if !ok {
+		c.owner[p] = path
+		return true
+	}
+	return owner == path
+}
+
+// splitSeedPeers deals the seed peers, sorted by distance to the target, to
+// the disjoint paths of a lookup, so that each path starts from peers as close
+// to the target as the others.
+func (dht *IpfsDHT) splitSeedPeers(seedPeers []peer.ID) [][]peer.ID {
+	n := min(dht.disjointPaths, len(seedPeers))
+	if n <= 1 {
+		return [][]peer.ID{seedPeers}
+	}
+	paths := make([][]peer.ID, n)
+	for i, p := range seedPeers {
+		paths[i%n] = append(paths[i%n], p)
+	}
+	return paths
+}
+
+// mergePaths merges the peers known by the disjoint paths of a lookup. The
+// lookup is only complete if all of its paths are.
+func (dht *IpfsDHT) mergePaths(queries []*query) (*lookupWithFollowupResult, *qpeerset.QueryPeerset) {
+	merged := qpeerset.NewQueryPeersetForKadID(queries[0].targetKadID)
+	completed := true
+	for _, q := range queries {
+		completed = completed && (q.isLookupTermination() || q.isStarvationTermination())
+		for _, p := range q.queryPeers.GetClosestInStates(qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried, qpeerset.PeerUnreachable) {
+			// the paths are disjoint, each peer is only known by one of them
+			merged.TryAdd(p, q.queryPeers.GetReferrer(p))
+			merged.SetState(p, q.queryPeers.GetState(p))
+		}
+	}
+	return newLookupResult(merged, dht.bucketSize, completed), merged
+}
@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisjointPaths(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 6)
+	for i, a := range servers {
+		for _, b := range servers[i+1:] {
+			connect(t, ctx, a, b)
+		}
+	}
+	d := setupDHT(ctx, t, false, DisjointPaths(2))
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+
+	evCtx, cancelEvents := context.WithCancel(ctx)
+	evCtx, events := RegisterForLookupEvents(evCtx)
+	queried := make(map[uuid.UUID][]peer.ID)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			if ev.Request != nil {
+				for _, p := range ev.Request.Waiting {
+					queried[ev.ID] = append(queried[ev.ID], p.Peer)
+				}
+			}
+		}
+	}()
+
+	ctxT, cancelT := context.WithTimeout(evCtx, 10*time.Second)
+	defer cancelT()
+	peers, err := d.GetClosestPeers(ctxT, "foo")
+	require.NoError(t, err)
+	cancelEvents()
+	<-done
+
+	require.Len(t, peers, len(servers))
+	require.Len(t, queried, 2)
+	usedBy := make(map[peer.ID]uuid.UUID)
+	for id, ps := range queried {
+		require.NotEmpty(t, ps)
+		for _, p := range ps {
+			other, ok := usedBy[p]
+			require.False(t, ok && other != id, "peer %s queried by two paths", p)
+			usedBy[p] = id
+		}
+	}
+}
+
+func TestSplitSeedPeers(t *testing.T) {
+	d := &IpfsDHT{disjointPaths: 3}
+	seeds := []peer.ID{"a", "b", "c", "d", "e"}
+	paths := d.splitSeedPeers(seeds)
+	require.Equal(t, [][]peer.ID{{"a", "d"}, {"b", "e"}, {"c"}}, paths)
+
+	// never more paths than seed peers
+	require.Len(t, d.splitSeedPeers(seeds[:2]), 2)
+
+	claims := newPathClaims(paths)
+	require.True(t, claims.claim("a", 0))
+	require.False(t, claims.claim("a", 1))
+	require.True(t, claims.claim("f", 2))
+	require.False(t, claims.claim("f", 0))
+}
@@ -3,7 +3,9 @@ package dht
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -70,6 +72,7 @@ func NewLookupEvent(
 		Node:      NewPeerKadID(node),
 		ID:        id,
 		Key:       NewKeyKadID(key),
+		Time:      time.Now(),
 		Request:   request,
 		Response:  response,
 		Terminate: terminate,
@@ -85,6 +88,8 @@ type LookupEvent struct {
 	ID uuid.UUID
 	// Key is the Kademlia key used as a lookup target.
 	Key *KeyKadID
+	// Time is the time the event happened at.
+	Time time.Time
 	// Request, if not nil, describes a state update event, associated with an outgoing query request.
 	Request *LookupUpdateEvent
 	// Response, if not nil, describes a state update event, associated with an outgoing query response.
@@ -127,6 +132,8 @@ type LookupUpdateEvent struct {
 	Queried []*PeerKadID
 	// Unreachable is a set of peers whose state in the lookup's peerset is being set to "unreachable".
 	Unreachable []*PeerKadID
+	// Error, if not empty, is the error that made Cause unreachable.
+	Error string `json:",omitempty"`
 }
 
 // LookupTerminateEvent describes a lookup termination event.
@@ -148,6 +155,21 @@ func (r LookupTerminationReason) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.String())
 }
 
+// UnmarshalJSON sets the lookup termination reason from its JSON encoding.
+func (r *LookupTerminationReason) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	for _, reason := range []LookupTerminationReason{LookupStopped, LookupCancelled, LookupStarvation, LookupCompleted} {
+		if reason.String() == s {
+			*r = reason
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown lookup termination reason: %q", s)
+}
+
 func (r LookupTerminationReason) String() string {
 	switch r {
 	case LookupStopped:
@@ -185,6 +207,9 @@ type lookupEventChannel struct {
 	mu  sync.Mutex
 	ctx context.Context
 	ch  chan<- *LookupEvent
+	// next is the channel registered before this one on the context, if any,
+	// the events are sent to both.
+	next *lookupEventChannel
 }
 
 // waitThenClose is spawned in a goroutine when the channel is registered. This
@@ -200,21 +225,22 @@ func (e *lookupEventChannel) waitThenClose() {
 }
 
 // send sends an event on the event channel, aborting if either the passed or
-// the internal context expire.
+// the internal context expire, then on the next channel if any.
 func (e *lookupEventChannel) send(ctx context.Context, ev *LookupEvent) {
 	e.mu.Lock()
-	// Closed.
-	if e.ch == nil {
-		e.mu.Unlock()
-		return
-	}
-	// in case the passed context is unrelated, wait on both.
-	select {
-	case e.ch <- ev:
-	case <-e.ctx.Done():
-	case <-ctx.Done():
+	// Skipped once closed.
+	if e.ch != nil {
+		// in case the passed context is unrelated, wait on both.
+		select {
+		case e.ch <- ev:
+		case <-e.ctx.Done():
+		case <-ctx.Done():
+		}
 	}
 	e.mu.Unlock()
+	if e.next != nil {
+		e.next.send(ctx, ev)
+	}
 }
 
 // RegisterForLookupEvents registers a lookup event channel with the given context.
@@ -0,0 +1,314 @@
+package fullrt
+
+import (
+	"container/heap"
+	"context"
+	"maps"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	kadkey "github.com/libp2p/go-libp2p-xor/key"
+)
+
+// readyVerifiedFraction is the fraction of the known peers that must have been
+// verified within the crawl interval for a continuously crawled FullRT to be
+// ready.
+const readyVerifiedFraction = 0.9
+
+// crawlItem is a peer tracked by the continuous crawler.
+type crawlItem struct {
+	id    peer.ID
+	addrs []ma.Multiaddr
+	// verifiedAt is the last time the peer answered a query, zero if never.
+	verifiedAt time.Time
+	index      int
+}
+
+// crawlQueue is a priority queue of peers, the least recently verified first.
+// It implements heap.Interface.
+type crawlQueue []*crawlItem
+
+func (q crawlQueue) Len() int { return len(q) }
+
+func (q crawlQueue) Less(i, j int) bool { return q[i].verifiedAt.Before(q[j].verifiedAt) }
+
+func (q crawlQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *crawlQueue) Push(x any) {
+	it := x.(*crawlItem)
+	it.index = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *crawlQueue) Pop() any {
+	old := *q
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	it.index = -1
+	*q = old[:n-1]
+	return it
+}
+
+// continuousCrawl holds the state of the continuous crawler, see
+// WithContinuousCrawl.
+type continuousCrawl struct {
+	tick     time.Duration
+	interval time.Duration
+
+	lk     sync.Mutex
+	queue  crawlQueue
+	byPeer map[peer.ID]*crawlItem
+	// failed holds the peers that couldn't be verified, so that they are not
+	// queued again when other peers return them until the interval elapsed.
+	failed map[peer.ID]time.Time
+}
+
+func newContinuousCrawl(tick, interval time.Duration) *continuousCrawl {
+	return &continuousCrawl{
+		tick:     tick,
+		interval: interval,
+		byPeer:   make(map[peer.ID]*crawlItem),
+		failed:   make(map[peer.ID]time.Time),
+	}
+}
+
+// add queues a peer that isn't queued yet, unless it failed recently. If the
+// peer is queued, its addresses are updated.
+func (c *continuousCrawl) add(ai *peer.AddrInfo, verifiedAt time.Time, now time.Time) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if it, ok := c.byPeer[ai.ID]; ok {
+		if len(ai.Addrs) > 0 {
+			it.addrs = ai.Addrs
+		}
+		return
+	}
+	if failedAt, ok := c.failed[ai.ID]; ok && now.Sub(failedAt) < c.interval {
+		return
+	}
+	delete(c.failed, ai.ID)
+	it := &crawlItem{id: ai.ID, addrs: ai.Addrs, verifiedAt: verifiedAt}
+	heap.Push(&c.queue, it)
+	c.byPeer[ai.ID] = it
+}
+
+// verified records that the peer answered a query.
+func (c *continuousCrawl) verified(p peer.ID, now time.Time) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if it, ok := c.byPeer[p]; ok {
+		it.verifiedAt = now
+		heap.Fix(&c.queue, it.index)
+	}
+}
+
+// fail removes the peer from the queue.
+func (c *continuousCrawl) fail(p peer.ID, now time.Time) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if it, ok := c.byPeer[p]; ok {
+		heap.Remove(&c.queue, it.index)
+		delete(c.byPeer, p)
+	}
+	c.failed[p] = now
+}
+
+// next returns the peers to verify at this tick: the least recently verified
+// ones, never verified peers first, and just enough of them for every peer to
+// be verified once per interval. Peers overdue, e.g. after a burst of new
+// peers, are verified over the next ticks rather than all at once. If all is
+// true, every queued peer is returned.
+func (c *continuousCrawl) next(now time.Time, all bool) []*peer.AddrInfo {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	for p, failedAt := range c.failed {
+		if now.Sub(failedAt) >= c.interval {
+			delete(c.failed, p)
+		}
+	}
+
+	n := len(c.queue)
+	if !all {
+		n = int(math.Ceil(float64(len(c.queue)) * float64(c.tick) / float64(c.interval)))
+	}
+	// the queue is only partially ordered, pop the peers to get them in order
+	// and push them back afterwards
+	var popped []*crawlItem
+	for len(c.queue) > 0 && len(popped) < n {
+		popped = append(popped, heap.Pop(&c.queue).(*crawlItem))
+	}
+
+	peers := make([]*peer.AddrInfo, len(popped))
+	for i, it := range popped {
+		peers[i] = &peer.AddrInfo{ID: it.id, Addrs: it.addrs}
+		heap.Push(&c.queue, it)
+	}
+	return peers
+}
+
+// verifiedFraction returns the fraction of the queued peers verified within the
+// interval.
+func (c *continuousCrawl) verifiedFraction(now time.Time) float64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if len(c.queue) == 0 {
+		return 0
+	}
+	verified := 0
+	for _, it := range c.queue {
+		if now.Sub(it.verifiedAt) < c.interval {
+			verified++
+		}
+	}
+	return float64(verified) / float64(len(c.queue))
+}
+
+// reseed queues the given peers, even if they failed recently.
+func (c *continuousCrawl) reseed(peers []*peer.AddrInfo) {
+	c.lk.Lock()
+	for _, ai := range peers {
+		delete(c.failed, ai.ID)
+	}
+	c.lk.Unlock()
+
+	now := time.Now()
+	for _, ai := range peers {
+		c.add(ai, time.Time{}, now)
+	}
+}
+
+func (c *continuousCrawl) len() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return len(c.queue)
+}
+
+// runContinuousCrawler verifies a share of the known peers every tick and
+// updates the routing table in place, instead of replacing it with the result
+// of a full crawl every crawl interval.
+func (dht *FullRT) runContinuousCrawler(ctx context.Context) {
+	defer dht.wg.Done()
+	c := dht.continuous
+
+	// start from the peers loaded from the datastore, if any, as verified when
+	// they were saved
+	now := time.Now()
+	dht.rtLk.RLock()
+	loadedAt := dht.lastCrawlTime
+	dht.rtLk.RUnlock()
+	dht.peerAddrsLk.RLock()
+	for p, addrs := range dht.peerAddrs {
+		c.add(&peer.AddrInfo{ID: p, Addrs: addrs}, loadedAt, now)
+	}
+	dht.peerAddrsLk.RUnlock()
+
+	t := time.NewTicker(c.tick)
+	defer t.Stop()
+	lastSave := now
+
+	initialTrigger := make(chan struct{}, 1)
+	initialTrigger <- struct{}{}
+
+	for {
+		all := false
+		select {
+		case <-t.C:
+		case <-initialTrigger:
+		case <-dht.triggerRefresh:
+			all = true
+		case <-ctx.Done():
+			return
+		}
+
+		if c.len() == 0 {
+			c.reseed(dht.bootstrapPeers)
+		}
+
+		limitErrOnce := sync.Once{}
+		dht.peerQuerier.QueryPeers(ctx, c.next(time.Now(), all),
+			func(p peer.ID, rtPeers []*peer.AddrInfo) {
+				now := time.Now()
+				c.verified(p, now)
+				for _, ai := range rtPeers {
+					if ai.ID != dht.self {
+						c.add(ai, time.Time{}, now)
+					}
+				}
+				if dht.rtPeerFilter(dht, p) {
+					dht.addPeer(p)
+				} else {
+					dht.removePeer(p)
+				}
+			},
+			func(p peer.ID, err error) {
+				if ctx.Err() != nil {
+					return
+				}
+				dht.logLimitErr(&limitErrOnce, err)
+				c.fail(p, time.Now())
+				dht.removePeer(p)
+			})
+
+		if dht.crawlStore != nil && ctx.Err() == nil && time.Since(lastSave) >= dht.crawlerInterval {
+			dht.peerAddrsLk.RLock()
+			peerAddrs := maps.Clone(dht.peerAddrs)
+			dht.peerAddrsLk.RUnlock()
+			lastSave = time.Now()
+			if len(peerAddrs) > 0 {
+				if err := dht.saveCrawl(ctx, peerAddrs, lastSave); err != nil {
+					logger.Warnw("failed to save crawl results", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// addPeer adds a verified peer to the routing table, or updates its addresses.
+func (dht *FullRT) addPeer(p peer.ID) {
+	kadKey := kadkey.KbucketIDToKey(kb.ConvertPeerID(p))
+	addrs := dht.h.Peerstore().Addrs(p)
+
+	dht.peerAddrsLk.Lock()
+	dht.peerAddrs[p] = addrs
+	dht.peerAddrsLk.Unlock()
+
+	dht.kMapLk.Lock()
+	dht.keyToPeerMap[string(kadKey)] = p
+	dht.kMapLk.Unlock()
+
+	dht.rtLk.Lock()
+	dht.rt.Add(kadKey)
+	dht.rtLk.Unlock()
+}
+
+// removePeer removes a peer from the routing table.
+func (dht *FullRT) removePeer(p peer.ID) {
+	kadKey := kadkey.KbucketIDToKey(kb.ConvertPeerID(p))
+
+	dht.rtLk.Lock()
+	// Trie.Remove removes whichever key is on the path of kadKey, so check
+	// that the peer is in the trie first
+	if _, found := dht.rt.Find(kadKey); found {
+		dht.rt.Remove(kadKey)
+	}
+	dht.rtLk.Unlock()
+
+	dht.kMapLk.Lock()
+	delete(dht.keyToPeerMap, string(kadKey))
+	dht.kMapLk.Unlock()
+
+	dht.peerAddrsLk.Lock()
+	delete(dht.peerAddrs, p)
+	dht.peerAddrsLk.Unlock()
+}
@@ -0,0 +1,176 @@
+package fullrt
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func withRoutingTablePeerFilter(f dht.RouteTableFilterFunc) Option {
+	return func(c *config) error {
+		c.rtPeerFilter = f
+		return nil
+	}
+}
+
+// fakeNetwork is a crawler.PeerQuerier answering with the peers each peer
+// knows, or failing for the peers that are down.
+type fakeNetwork struct {
+	crawler.Crawler
+
+	mu      sync.Mutex
+	known   map[peer.ID][]peer.ID
+	down    map[peer.ID]bool
+	queried map[peer.ID]int
+}
+
+func (n *fakeNetwork) QueryPeers(_ context.Context, peers []*peer.AddrInfo, handleSuccess crawler.HandleQueryResult, handleFail crawler.HandleQueryFail) {
+	for _, ai := range peers {
+		n.mu.Lock()
+		n.queried[ai.ID]++
+		down := n.down[ai.ID]
+		var rtPeers []*peer.AddrInfo
+		for _, p := range n.known[ai.ID] {
+			rtPeers = append(rtPeers, &peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}})
+		}
+		n.mu.Unlock()
+		if down {
+			handleFail(ai.ID, context.DeadlineExceeded)
+		} else {
+			handleSuccess(ai.ID, rtPeers)
+		}
+	}
+}
+
+func (n *fakeNetwork) setDown(p peer.ID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down[p] = true
+}
+
+func TestContinuousCrawl(t *testing.T) {
+	// every peer knows the next ones
+	peers := make([]peer.ID, 20)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+	}
+	n := &fakeNetwork{known: make(map[peer.ID][]peer.ID), down: make(map[peer.ID]bool), queried: make(map[peer.ID]int)}
+	for i, p := range peers {
+		n.known[p] = peers[i+1 : min(i+4, len(peers))]
+	}
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	rt, err := NewFullRT(h, "/test",
+		WithCrawler(n),
+		WithContinuousCrawl(10*time.Millisecond),
+		WithCrawlInterval(time.Second),
+		withRoutingTablePeerFilter(func(interface{}, peer.ID) bool { return true }),
+		DHTOption(dht.BucketSize(len(peers)), dht.BootstrapPeers(peer.AddrInfo{ID: peers[0]})),
+	)
+	require.NoError(t, err)
+	defer rt.Close()
+
+	require.Eventually(t, func() bool { return len(rt.Stat()) == len(peers) }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, rt.Ready, 5*time.Second, 10*time.Millisecond)
+	cp, err := rt.GetClosestPeers(context.Background(), "foo")
+	require.NoError(t, err)
+	require.Len(t, cp, len(peers))
+
+	// unreachable peers are removed from the routing table once verified
+	n.setDown(peers[5])
+	require.Eventually(t, func() bool { return len(rt.Stat()) == len(peers)-1 }, 5*time.Second, 10*time.Millisecond)
+	cp, err = rt.GetClosestPeers(context.Background(), "foo")
+	require.NoError(t, err)
+	require.NotContains(t, cp, peers[5])
+	require.True(t, rt.Ready())
+
+	// peers are verified in small batches rather than all at once
+	n.mu.Lock()
+	before := n.queried[peers[10]]
+	n.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	n.mu.Lock()
+	after := n.queried[peers[10]]
+	n.mu.Unlock()
+	require.LessOrEqual(t, after-before, 1)
+}
+
+func TestContinuousCrawlRequiresPeerQuerier(t *testing.T) {
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	_, err = NewFullRT(h, "/test", WithCrawler(idleCrawler{}), WithContinuousCrawl(time.Second))
+	require.Error(t, err)
+}
+
+func TestCrawlQueueNext(t *testing.T) {
+	now := time.Now()
+	c := newContinuousCrawl(time.Minute, 10*time.Minute)
+	var peers []peer.ID
+	for i := 0; i < 20; i++ {
+		p := test.RandPeerIDFatal(t)
+		peers = append(peers, p)
+		// peers[0] is the least recently verified
+		c.add(&peer.AddrInfo{ID: p}, now.Add(-time.Duration(20-i)*time.Second), now)
+	}
+
+	// a tenth of the peers per tick, the least recently verified first
+	next := c.next(now, false)
+	require.Len(t, next, 2)
+	require.Equal(t, peers[0], next[0].ID)
+	require.Equal(t, peers[1], next[1].ID)
+	require.Len(t, c.next(now, true), len(peers))
+
+	c.verified(peers[0], now)
+	c.verified(peers[1], now)
+	require.Equal(t, peers[2], c.next(now, false)[0].ID)
+
+	// never verified peers come first, the peers overdue are capped too
+	unverified := test.RandPeerIDFatal(t)
+	c.add(&peer.AddrInfo{ID: unverified}, time.Time{}, now)
+	next = c.next(now, false)
+	require.Len(t, next, 3)
+	require.Equal(t, unverified, next[0].ID)
+	require.Len(t, c.next(now.Add(10*time.Minute), false), 3)
+	require.InDelta(t, float64(len(peers))/float64(len(peers)+1), c.verifiedFraction(now), 0.001)
+
+	// failed peers are not queued again until the interval elapsed
+	c.fail(peers[3], now)
+	require.Equal(t, len(peers), c.len())
+	c.add(&peer.AddrInfo{ID: peers[3]}, time.Time{}, now.Add(time.Minute))
+	require.Equal(t, len(peers), c.len())
+	c.add(&peer.AddrInfo{ID: peers[3]}, time.Time{}, now.Add(10*time.Minute))
+	require.Equal(t, len(peers)+1, c.len())
+}
+
+func TestCrawlQueueNextUnverified(t *testing.T) {
+	now := time.Now()
+	c := newContinuousCrawl(time.Minute, 10*time.Minute)
+	for i := 0; i < 100; i++ {
+		c.add(&peer.AddrInfo{ID: test.RandPeerIDFatal(t)}, time.Time{}, now)
+	}
+
+	// peers returned by a crawl are only verified a tenth at a time
+	seen := make(map[peer.ID]struct{})
+	for i := 0; i < 10; i++ {
+		next := c.next(now, false)
+		require.Len(t, next, 10)
+		for _, ai := range next {
+			require.NotContains(t, seen, ai.ID)
+			seen[ai.ID] = struct{}{}
+			c.verified(ai.ID, now)
+		}
+	}
+	require.Len(t, seen, 100)
+}
@@ -68,8 +68,9 @@ const rtRefreshLimitsMsg = `Accelerated DHT client was unable to fully refresh i
 //
 // Running FullRT by itself (i.e. without a companion IpfsDHT) will run into some issues. The most critical is that
 // running a FullRT node will not currently keep you connected to the k closest peers which means that your peer's
-// addresses may not be discoverable in the DHT. Additionally, FullRT is only a DHT client and not a server which means it does not contribute capacity to the network.
-// If you want to run a server you should also run an IpfsDHT instance in server mode.
+// addresses may not be discoverable in the DHT. Additionally, FullRT is only a DHT client and not a server unless
+// WithServerMode is set, which means it does not contribute capacity to the network.
+// If you want to run a server you should either set WithServerMode or also run an IpfsDHT instance in server mode.
 //
 // FullRT has a Ready function that indicates the routing table has been refreshed recently. It is currently within the
 // discretion of the application as to how much they care about whether the routing table is ready.
@@ -89,11 +90,21 @@ type FullRT struct {
 	crawlerInterval time.Duration
 	lastCrawlTime   time.Time
 
+	// where crawl results are saved, nil if disabled
+	crawlStore  ds.Batching
+	crawlMaxAge time.Duration
+
 	crawler        crawler.Crawler
 	protoMessenger *dht_pb.ProtocolMessenger
 	messageSender  dht_pb.MessageSender
 
+	// peerQuerier and continuous are set if the routing table is crawled
+	// continuously, see WithContinuousCrawl.
+	peerQuerier crawler.PeerQuerier
+	continuous  *continuousCrawl
+
 	filterFromTable kaddht.QueryFilterFunc
+	rtPeerFilter    kaddht.RouteTableFilterFunc
 	rtLk            sync.RWMutex
 	rt              *trie.Trie
 
@@ -119,6 +130,12 @@ type FullRT struct {
 	peerConnectednessSubscriber event.Subscription
 
 	ipDiversityFilterLimit int
+
+	// server answers the DHT requests of other peers, nil unless
+	// WithServerMode is set.
+	server *kaddht.IpfsDHT
+	// serverProtocols are the protocols the requests are answered on.
+	serverProtocols []protocol.ID
 }
 
 // NewFullRT creates a DHT client that tracks the full network. It takes a protocol prefix for the given network,
@@ -138,6 +155,7 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 		waitFrac:               0.3,
 		timeoutPerOp:           5 * time.Second,
 		ipDiversityFilterLimit: amino.DefaultMaxPeersPerIPGroup,
+		rtPeerFilter:           kaddht.PublicRoutingTableFilter,
 	}
 	if err := fullrtcfg.apply(options...); err != nil {
 		return nil, err
@@ -175,6 +193,13 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 			return nil, err
 		}
 	}
+	var peerQuerier crawler.PeerQuerier
+	if fullrtcfg.crawlTick > 0 {
+		var ok bool
+		if peerQuerier, ok = fullrtcfg.crawler.(crawler.PeerQuerier); !ok {
+			return nil, fmt.Errorf("crawler %T does not support continuous crawls", fullrtcfg.crawler)
+		}
+	}
 
 	sub, err := h.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged), eventbus.Name("fullrt-dht"))
 	if err != nil {
@@ -211,6 +236,7 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 		messageSender:   ms,
 		protoMessenger:  protoMessenger,
 		filterFromTable: kaddht.PublicQueryFilter,
+		rtPeerFilter:    fullrtcfg.rtPeerFilter,
 		rt:              trie.New(),
 		keyToPeerMap:    make(map[string]peer.ID),
 		bucketSize:      dhtcfg.BucketSize,
@@ -230,8 +256,32 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 		peerConnectednessSubscriber: sub,
 	}
 
+	if fullrtcfg.crawlMaxAge > 0 {
+		rt.crawlStore = dhtcfg.Datastore
+		rt.crawlMaxAge = fullrtcfg.crawlMaxAge
+		if _, err := rt.loadCrawl(ctx); err != nil {
+			logger.Warnw("failed to load crawl results", "error", err)
+		}
+	}
+
+	if fullrtcfg.server {
+		rt.server, err = rt.newServer(ctx, dhtcfg, fullrtcfg.serverOpts)
+		if err != nil {
+			cancel()
+			_ = pm.Close()
+			return nil, fmt.Errorf("failed to create the DHT server: %w", err)
+		}
+		rt.serverProtocols = []protocol.ID{dhtcfg.ProtocolPrefix + "/kad/1.0.0"}
+	}
+
 	rt.wg.Add(2)
-	go rt.runCrawler(ctx)
+	if peerQuerier != nil {
+		rt.peerQuerier = peerQuerier
+		rt.continuous = newContinuousCrawl(fullrtcfg.crawlTick, fullrtcfg.crawlInterval)
+		go rt.runContinuousCrawler(ctx)
+	} else {
+		go rt.runCrawler(ctx)
+	}
 	go rt.runSubscriber()
 	return rt, nil
 }
@@ -281,20 +331,29 @@ func (dht *FullRT) Stat() map[string]peer.ID {
 // Ready indicates that the routing table has been refreshed recently. It is recommended to be used for operations where
 // it is important for the operation to be particularly accurate (e.g. bulk publishing where you do not want to
 // republish for as long as you can).
+//
+// When the routing table is crawled continuously (see WithContinuousCrawl), the routing table is refreshed recently
+// if most of the known peers were verified within the crawl interval.
 func (dht *FullRT) Ready() bool {
-	dht.rtLk.RLock()
-	lastCrawlTime := dht.lastCrawlTime
-	dht.rtLk.RUnlock()
+	if dht.continuous != nil {
+		if dht.continuous.verifiedFraction(time.Now()) < readyVerifiedFraction {
+			return false
+		}
+	} else {
+		dht.rtLk.RLock()
+		lastCrawlTime := dht.lastCrawlTime
+		dht.rtLk.RUnlock()
 
-	if time.Since(lastCrawlTime) > dht.crawlerInterval {
-		return false
+		if time.Since(lastCrawlTime) > dht.crawlerInterval {
+			return false
+		}
 	}
 
 	// TODO: This function needs to be better defined. Perhaps based on going through the peer map and seeing when the
 	// last time we were connected to any of them was.
-	dht.peerAddrsLk.RLock()
+	dht.kMapLk.RLock()
 	rtSize := len(dht.keyToPeerMap)
-	dht.peerAddrsLk.RUnlock()
+	dht.kMapLk.RUnlock()
 
 	return rtSize > len(dht.bootstrapPeers)+1
 }
@@ -307,7 +366,10 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 	defer dht.wg.Done()
 	t := time.NewTicker(dht.crawlerInterval)
 
-	foundPeers := make(map[peer.ID][]ma.Multiaddr)
+	// start crawling from the peers loaded from the datastore, if any
+	dht.peerAddrsLk.RLock()
+	foundPeers := maps.Clone(dht.peerAddrs)
+	dht.peerAddrsLk.RUnlock()
 	foundPeersLk := sync.Mutex{}
 
 	initialTrigger := make(chan struct{}, 1)
@@ -337,7 +399,7 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 		limitErrOnce := sync.Once{}
 		dht.crawler.Run(ctx, addrs,
 			func(p peer.ID, rtPeers []*peer.AddrInfo) {
-				keep := kaddht.PublicRoutingTableFilter(dht, p)
+				keep := dht.rtPeerFilter(dht, p)
 				if !keep {
 					return
 				}
@@ -347,18 +409,7 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 				foundPeers[p] = dht.h.Peerstore().Addrs(p)
 			},
 			func(p peer.ID, err error) {
-				dialErr, ok := err.(*swarm.DialError)
-				if ok {
-					for _, transportErr := range dialErr.DialErrors {
-						if errors.Is(transportErr.Cause, network.ErrResourceLimitExceeded) {
-							limitErrOnce.Do(func() { logger.Errorf(rtRefreshLimitsMsg) })
-						}
-					}
-				}
-				// note that DialError implements Unwrap() which returns the Cause, so this covers that case
-				if errors.Is(err, network.ErrResourceLimitExceeded) {
-					limitErrOnce.Do(func() { logger.Errorf(rtRefreshLimitsMsg) })
-				}
+				dht.logLimitErr(&limitErrOnce, err)
 			})
 		dur := time.Since(start)
 		logger.Infof("crawl took %v", dur)
@@ -381,16 +432,47 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 		dht.keyToPeerMap = kPeerMap
 		dht.kMapLk.Unlock()
 
+		crawlTime := time.Now()
 		dht.rtLk.Lock()
 		dht.rt = newRt
-		dht.lastCrawlTime = time.Now()
+		dht.lastCrawlTime = crawlTime
 		dht.rtLk.Unlock()
+
+		if dht.crawlStore != nil && len(peerAddrs) > 0 && ctx.Err() == nil {
+			if err := dht.saveCrawl(ctx, peerAddrs, crawlTime); err != nil {
+				logger.Warnw("failed to save crawl results", "error", err)
+			}
+		}
+	}
+}
+
+// logLimitErr logs once that the routing table can't be fully refreshed if err
+// was caused by the resource manager limits.
+func (dht *FullRT) logLimitErr(once *sync.Once, err error) {
+	dialErr, ok := err.(*swarm.DialError)
+	if ok {
+		for _, transportErr := range dialErr.DialErrors {
+			if errors.Is(transportErr.Cause, network.ErrResourceLimitExceeded) {
+				once.Do(func() { logger.Errorf(rtRefreshLimitsMsg) })
+			}
+		}
+	}
+	// note that DialError implements Unwrap() which returns the Cause, so this covers that case
+	if errors.Is(err, network.ErrResourceLimitExceeded) {
+		once.Do(func() { logger.Errorf(rtRefreshLimitsMsg) })
 	}
 }
 
 func (dht *FullRT) Close() error {
+	for _, p := range dht.serverProtocols {
+		dht.h.RemoveStreamHandler(p)
+	}
 	dht.cancel()
 	dht.wg.Wait()
+	if dht.server != nil {
+		// closes the provider manager too
+		return dht.server.Close()
+	}
 	return dht.ProviderManager.Close()
 }
 
@@ -448,6 +530,13 @@ func (dht *FullRT) CheckPeers(ctx context.Context, peers ...peer.ID) (int, int)
 	return int(success), total
 }
 
+// Ping sends a ping message to the passed peer and waits for a response.
+func (dht *FullRT) Ping(ctx context.Context, p peer.ID) error {
+	ctx, span := internal.StartSpan(ctx, "FullRT.Ping", trace.WithAttributes(attribute.Stringer("PeerID", p)))
+	defer span.End()
+	return dht.protoMessenger.Ping(ctx, p)
+}
+
 func workers(numWorkers int, fn func(peer.AddrInfo), inputs <-chan peer.AddrInfo) {
 	jobs := make(chan peer.AddrInfo)
 	defer close(jobs)
@@ -485,22 +574,29 @@ func (dht *FullRT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID,
 	// filtered out by the diversity filter. Multiple calls to ClosestN are
 	// expensive, but increasing the `count` parameter is cheap.
 	step := dht.bucketSize + 2*dht.ipDiversityFilterLimit
-	for nClosest := 0; nClosest < dht.rt.Size(); nClosest += step {
+	dht.rtLk.RLock()
+	rtSize := dht.rt.Size()
+	dht.rtLk.RUnlock()
+	for nClosest := 0; nClosest < rtSize; nClosest += step {
 		dht.rtLk.RLock()
 		// Get the last `step` closest peers, because we already tried the `nClosest` closest peers
-		closestKeys := kademlia.ClosestN(kadKey, dht.rt, nClosest+step)[nClosest:]
+		closestKeys := kademlia.ClosestN(kadKey, dht.rt, nClosest+step)
 		dht.rtLk.RUnlock()
+		if len(closestKeys) <= nClosest {
+			break
+		}
+		closestKeys = closestKeys[nClosest:]
 
 	PeersLoop:
 		for _, k := range closestKeys {
 			dht.kMapLk.RLock()
 			// Recover the peer ID from the key
 			p, ok := dht.keyToPeerMap[string(k)]
+			dht.kMapLk.RUnlock()
 			if !ok {
-				logger.Errorf("key not found in map")
+				// the peer was removed after the keys were read
 				continue
 			}
-			dht.kMapLk.RUnlock()
 			dht.peerAddrsLk.RLock()
 			peerAddrs := dht.peerAddrs[p]
 			dht.peerAddrsLk.RUnlock()
@@ -1439,6 +1535,144 @@ func (dht *FullRT) findProvidersAsyncRoutine(ctx context.Context, key multihash.
 	dht.execOnMany(queryctx, fn, peers, false)
 }
 
+// FindProvidersMany looks for the providers of several keys at once, up to
+// the bucket size for each key like FindProviders. Keys are grouped by their
+// closest peers, and each of these peers is asked for the providers of all its
+// keys with GET_PROVIDERS_BATCH requests rather than one request per key. The
+// keys left without providers, e.g. because their closest peers don't support
+// GET_PROVIDERS_BATCH, are then looked up individually with FindProvidersAsync.
+func (dht *FullRT) FindProvidersMany(ctx context.Context, keys []cid.Cid) (map[cid.Cid][]peer.AddrInfo, error) {
+	ctx, span := internal.StartSpan(ctx, "FullRT.FindProvidersMany", trace.WithAttributes(attribute.Int("keys", len(keys))))
+	defer span.End()
+
+	if !dht.enableProviders {
+		return nil, routing.ErrNotSupported
+	}
+
+	var mu sync.Mutex
+	found := make(map[string]map[peer.ID]peer.AddrInfo, len(keys))
+	addProvider := func(key string, prov peer.AddrInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := found[key][prov.ID]; !ok && len(found[key]) < dht.bucketSize {
+			found[key][prov.ID] = prov
+		}
+	}
+
+	keysPerPeer := make(map[peer.ID][]multihash.Multihash)
+	for _, c := range keys {
+		if !c.Defined() {
+			return nil, errors.New("invalid cid: undefined")
+		}
+		k := c.Hash()
+		if _, ok := found[string(k)]; ok {
+			continue
+		}
+		found[string(k)] = make(map[peer.ID]peer.AddrInfo)
+
+		provs, err := dht.ProviderManager.GetProviders(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range provs {
+			addProvider(string(k), p)
+		}
+
+		peers, err := dht.GetClosestPeers(ctx, string(k))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range peers {
+			keysPerPeer[p] = append(keysPerPeer[p], k)
+		}
+	}
+
+	peersCh := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for range dht.bulkSendParallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range peersCh {
+				reqCtx, cancel := context.WithTimeout(ctx, dht.timeoutPerOp)
+				keyProvs, err := dht.protoMessenger.GetProvidersBatch(reqCtx, p, keysPerPeer[p])
+				cancel()
+				if err != nil {
+					logger.Debugw("failed to get providers batch", "peer", p, "error", err)
+					continue
+				}
+				for _, kp := range keyProvs {
+					// ignore the keys that weren't asked for
+					mu.Lock()
+					_, ok := found[string(kp.GetKey())]
+					mu.Unlock()
+					if !ok {
+						continue
+					}
+					for _, prov := range dht_pb.PBPeersToPeerInfos(kp.GetProviders()) {
+						dht.maybeAddAddrs(prov.ID, prov.Addrs, peerstore.TempAddrTTL)
+						addProvider(string(kp.GetKey()), *prov)
+					}
+				}
+			}
+		}()
+	}
+	for p := range keysPerPeer {
+		select {
+		case peersCh <- p:
+		case <-ctx.Done():
+		}
+	}
+	close(peersCh)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// look up the keys still missing providers one by one, e.g. when their
+	// closest peers don't support GET_PROVIDERS_BATCH
+	keysCh := make(chan cid.Cid)
+	for range dht.bulkSendParallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range keysCh {
+				for p := range dht.FindProvidersAsync(ctx, c, dht.bucketSize) {
+					addProvider(string(c.Hash()), p)
+				}
+			}
+		}()
+	}
+	looked := make(map[string]struct{})
+	for _, c := range keys {
+		k := string(c.Hash())
+		mu.Lock()
+		missing := len(found[k]) == 0
+		mu.Unlock()
+		if _, ok := looked[k]; ok || !missing {
+			continue
+		}
+		looked[k] = struct{}{}
+		select {
+		case keysCh <- c:
+		case <-ctx.Done():
+		}
+	}
+	close(keysCh)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	out := make(map[cid.Cid][]peer.AddrInfo, len(keys))
+	for _, c := range keys {
+		for _, prov := range found[string(c.Hash())] {
+			out[c] = append(out[c], prov)
+		}
+	}
+	return out, nil
+}
+
 // FindPeer searches for a peer with given ID.
 func (dht *FullRT) FindPeer(ctx context.Context, id peer.ID) (pi peer.AddrInfo, err error) {
 	ctx, end := tracer.FindPeer(dhtName, ctx, id)
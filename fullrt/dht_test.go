@@ -4,15 +4,21 @@ import (
 	"context"
 	"crypto/rand"
 	"strconv"
+	"sync/atomic"
 	"testing"
 
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	dht_pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	kadkey "github.com/libp2p/go-libp2p-xor/key"
 	"github.com/libp2p/go-libp2p-xor/trie"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -289,3 +295,43 @@ func TestIPDiversityFilter(t *testing.T) {
 		require.Equal(t, pids[:dht.bucketSize], cp)
 	})
 }
+
+func TestFindProvidersManyWithoutBatchSupport(t *testing.T) {
+	ctx := context.Background()
+
+	// a server that doesn't know GET_PROVIDERS_BATCH, and resets the stream
+	var batches atomic.Int32
+	noBatch := dht.OnRequestHook(func(ctx context.Context, s network.Stream, req *dht_pb.Message) {
+		if req.GetType() == dht_pb.Message_GET_PROVIDERS_BATCH {
+			batches.Add(1)
+			req.Type = dht_pb.Message_MessageType(-1)
+		}
+	})
+	sh, err := libp2p.New()
+	require.NoError(t, err)
+	defer sh.Close()
+	server, err := dht.New(ctx, sh, dht.Mode(dht.ModeServer), dht.BootstrapPeers(), noBatch)
+	require.NoError(t, err)
+	defer server.Close()
+
+	mh, err := multihash.Sum([]byte("data"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	provider := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	require.NoError(t, server.ProviderStore().AddProvider(ctx, mh, provider))
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	rt, err := NewFullRT(h, "/test", WithCrawler(idleCrawler{}), DHTOption(dht.BucketSize(20), dht.BootstrapPeers()))
+	require.NoError(t, err)
+	defer rt.Close()
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: sh.ID(), Addrs: sh.Addrs()}))
+	rt.addPeer(sh.ID())
+
+	c := cid.NewCidV1(cid.Raw, mh)
+	res, err := rt.FindProvidersMany(ctx, []cid.Cid{c})
+	require.NoError(t, err)
+	require.Len(t, res[c], 1)
+	require.Equal(t, provider.ID, res[c][0].ID)
+	require.NotZero(t, batches.Load())
+}
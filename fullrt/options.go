@@ -19,6 +19,11 @@ type config struct {
 	crawler                crawler.Crawler
 	pmOpts                 []providers.Option
 	ipDiversityFilterLimit int
+	crawlMaxAge            time.Duration
+	crawlTick              time.Duration
+	server                 bool
+	serverOpts             []kaddht.Option
+	rtPeerFilter           kaddht.RouteTableFilterFunc
 }
 
 func (cfg *config) apply(opts ...Option) error {
@@ -57,6 +62,67 @@ func WithCrawlInterval(i time.Duration) Option {
 	}
 }
 
+// WithCrawlPersistence saves the peers found by every successful crawl, along
+// with their addresses, to the DHT datastore (see kaddht.Datastore). On
+// construction, the results of the last saved crawl are loaded if they are not
+// older than maxAge, so that the routing table is usable right away while the
+// next crawl refreshes it in the background.
+//
+// Ready reports true after loading a crawl only if it is also more recent than
+// the crawl interval. Defaults to disabled.
+func WithCrawlPersistence(maxAge time.Duration) Option {
+	return func(opt *config) error {
+		if maxAge <= 0 {
+			return fmt.Errorf("crawl persistence max age must be positive; got: %s", maxAge)
+		}
+		opt.crawlMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithContinuousCrawl replaces the crawls of the whole network every crawl
+// interval (see WithCrawlInterval) with a continuous crawl. The known peers are
+// kept in a queue ordered by the last time they answered a query, and every
+// tick just enough of the least recently verified ones, never verified peers
+// first, are queried for every peer to be verified once per crawl interval.
+// The routing table is updated in place as
+// peers are verified, found or become unreachable, which spreads the dials of
+// a crawl over the crawl interval.
+//
+// Ready reports true once most of the known peers were verified within the
+// crawl interval. The crawler (see WithCrawler) must implement
+// crawler.PeerQuerier. Defaults to disabled.
+func WithContinuousCrawl(tick time.Duration) Option {
+	return func(opt *config) error {
+		if tick <= 0 {
+			return fmt.Errorf("continuous crawl tick must be positive; got: %s", tick)
+		}
+		opt.crawlTick = tick
+		return nil
+	}
+}
+
+// WithServerMode makes FullRT answer the DHT requests of other peers on the
+// protocol of the DHT, so that it doesn't need a companion IpfsDHT in server
+// mode. FIND_NODE, GET_VALUE and GET_PROVIDERS requests are answered with the
+// closest peers to the key in the full routing table rather than in a single
+// bucket, along with the values and provider records stored locally.
+//
+// Requests are handled by an IpfsDHT in server mode sharing the protocol,
+// validator, datastore and provider records of the FullRT. The options passed
+// with DHTOption don't apply to it, opts configure how requests are served
+// instead (e.g. MaxProvidersPerPeer or MaxConcurrentRequests). Serving prefix
+// lookups with ServePrefixLookups also needs the provider records indexed by
+// prefix, see WithProviderManagerOptions and providers.IndexByPrefix.
+// Defaults to disabled.
+func WithServerMode(opts ...kaddht.Option) Option {
+	return func(opt *config) error {
+		opt.server = true
+		opt.serverOpts = append(opt.serverOpts, opts...)
+		return nil
+	}
+}
+
 // WithSuccessWaitFraction sets the fraction of peers to wait for before
 // considering an operation a success defined as a number between (0, 1].
 // Defaults to 30% if unspecified.
@@ -0,0 +1,169 @@
+package fullrt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-xor/trie"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/proto"
+
+	dht_pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	kadkey "github.com/libp2p/go-libp2p-xor/key"
+)
+
+const (
+	// crawlPeersPrefix is the datastore namespace holding one entry per peer
+	// found by the last successful crawl.
+	crawlPeersPrefix = "/fullrt/crawl/peers/"
+	// crawlTimeKey stores the time at which the last saved crawl completed.
+	crawlTimeKey = "/fullrt/crawl/time"
+)
+
+func mkCrawlPeerKey(p peer.ID) ds.Key {
+	return ds.NewKey(crawlPeersPrefix + base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+// saveCrawl replaces the crawl results in the datastore with the given peers
+// and their addresses. The trie and keyToPeerMap are derived from the peer IDs
+// so only the peers and their addresses are stored.
+func (dht *FullRT) saveCrawl(ctx context.Context, peerAddrs map[peer.ID][]ma.Multiaddr, crawlTime time.Time) error {
+	batch, err := dht.crawlStore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[ds.Key]struct{}, len(peerAddrs))
+	for p, addrs := range peerAddrs {
+		pbPeer := &dht_pb.Message_Peer{Id: []byte(p), Addrs: make([][]byte, len(addrs))}
+		for i, a := range addrs {
+			pbPeer.Addrs[i] = a.Bytes()
+		}
+		data, err := proto.Marshal(pbPeer)
+		if err != nil {
+			return err
+		}
+
+		k := mkCrawlPeerKey(p)
+		current[k] = struct{}{}
+		if err := batch.Put(ctx, k, data); err != nil {
+			return err
+		}
+	}
+
+	// remove the peers that weren't found by this crawl
+	res, err := dht.crawlStore.Query(ctx, dsq.Query{Prefix: crawlPeersPrefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k := ds.NewKey(e.Key)
+		if _, ok := current[k]; ok {
+			continue
+		}
+		if err := batch.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, crawlTime.UnixNano())
+	if err := batch.Put(ctx, ds.NewKey(crawlTimeKey), buf[:n]); err != nil {
+		return err
+	}
+
+	return batch.Commit(ctx)
+}
+
+// loadCrawl restores the routing table from the last saved crawl, unless it is
+// older than the configured staleness bound. It returns whether the crawl was
+// loaded.
+func (dht *FullRT) loadCrawl(ctx context.Context) (bool, error) {
+	data, err := dht.crawlStore.Get(ctx, ds.NewKey(crawlTimeKey))
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	nsec, n := binary.Varint(data)
+	if n <= 0 {
+		return false, errors.New("failed to parse crawl time")
+	}
+	crawlTime := time.Unix(0, nsec)
+	if time.Since(crawlTime) > dht.crawlMaxAge {
+		logger.Infow("ignoring stale crawl results", "crawled", crawlTime)
+		return false, nil
+	}
+
+	res, err := dht.crawlStore.Query(ctx, dsq.Query{Prefix: crawlPeersPrefix})
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+
+	peerAddrs := make(map[peer.ID][]ma.Multiaddr)
+	kPeerMap := make(map[string]peer.ID)
+	newRt := trie.New()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return false, e.Error
+		}
+
+		var pbPeer dht_pb.Message_Peer
+		if err := proto.Unmarshal(e.Value, &pbPeer); err != nil {
+			logger.Debugw("invalid crawl entry", "key", e.Key, "error", err)
+			continue
+		}
+		peerID, err := peer.IDFromBytes(pbPeer.GetId())
+		if err != nil {
+			logger.Debugw("invalid peer in crawl entry", "key", e.Key, "error", err)
+			continue
+		}
+		addrs := make([]ma.Multiaddr, 0, len(pbPeer.GetAddrs()))
+		for _, b := range pbPeer.GetAddrs() {
+			a, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, a)
+		}
+
+		// the next crawl starts from these peers, and reads their addresses
+		// from the peerstore.
+		dht.h.Peerstore().AddAddrs(peerID, addrs, peerstore.TempAddrTTL)
+
+		kadKey := kadkey.KbucketIDToKey(kb.ConvertPeerID(peerID))
+		peerAddrs[peerID] = addrs
+		kPeerMap[string(kadKey)] = peerID
+		newRt.Add(kadKey)
+	}
+
+	dht.peerAddrsLk.Lock()
+	dht.peerAddrs = peerAddrs
+	dht.peerAddrsLk.Unlock()
+
+	dht.kMapLk.Lock()
+	dht.keyToPeerMap = kPeerMap
+	dht.kMapLk.Unlock()
+
+	dht.rtLk.Lock()
+	dht.rt = newRt
+	dht.lastCrawlTime = crawlTime
+	dht.rtLk.Unlock()
+
+	logger.Infow("loaded crawl results", "peers", len(peerAddrs), "crawled", crawlTime)
+	return true, nil
+}
@@ -0,0 +1,77 @@
+package fullrt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// idleCrawler never finds any peer, so that the loaded crawl results are kept.
+type idleCrawler struct{}
+
+func (idleCrawler) Run(ctx context.Context, _ []*peer.AddrInfo, _ crawler.HandleQueryResult, _ crawler.HandleQueryFail) {
+	<-ctx.Done()
+}
+
+func newPersistentFullRT(t *testing.T, dstore ds.Batching) *FullRT {
+	t.Helper()
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	rt, err := NewFullRT(h, "/test",
+		WithCrawler(idleCrawler{}),
+		WithCrawlPersistence(2*time.Hour),
+		DHTOption(dht.Datastore(dstore), dht.BootstrapPeers()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { rt.Close() })
+	return rt
+}
+
+func TestCrawlPersistence(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	rt := newPersistentFullRT(t, dstore)
+	require.False(t, rt.Ready())
+
+	peerAddrs := make(map[peer.ID][]ma.Multiaddr)
+	for range 10 {
+		peerAddrs[test.RandPeerIDFatal(t)] = []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	}
+	require.NoError(t, rt.saveCrawl(ctx, peerAddrs, time.Now()))
+
+	restarted := newPersistentFullRT(t, dstore)
+	require.True(t, restarted.Ready())
+	require.Len(t, restarted.Stat(), len(peerAddrs))
+	for p := range peerAddrs {
+		require.NotEmpty(t, restarted.h.Peerstore().Addrs(p))
+	}
+	require.Equal(t, len(peerAddrs), restarted.rt.Size())
+
+	// peers missing from the next crawl are removed
+	for p := range peerAddrs {
+		delete(peerAddrs, p)
+		break
+	}
+	require.NoError(t, rt.saveCrawl(ctx, peerAddrs, time.Now()))
+	restarted = newPersistentFullRT(t, dstore)
+	require.Len(t, restarted.Stat(), len(peerAddrs))
+
+	// crawls older than the staleness bound are ignored
+	require.NoError(t, rt.saveCrawl(ctx, peerAddrs, time.Now().Add(-3*time.Hour)))
+	restarted = newPersistentFullRT(t, dstore)
+	require.False(t, restarted.Ready())
+	require.Empty(t, restarted.Stat())
+}
@@ -0,0 +1,79 @@
+package fullrt
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	internalConfig "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-xor/kademlia"
+	kadkey "github.com/libp2p/go-libp2p-xor/key"
+)
+
+// newServer returns the IpfsDHT in server mode answering the requests of other
+// peers, see WithServerMode. It shares the protocol, validator, datastore and
+// provider records of the FullRT, and answers with the closest peers of the
+// FullRT routing table. Only serverOpts apply to it, so that the reprovider,
+// republisher or snapshots configured for the FullRT don't run twice.
+func (dht *FullRT) newServer(ctx context.Context, cfg *internalConfig.Config, serverOpts []kaddht.Option) (*kaddht.IpfsDHT, error) {
+	opts := []kaddht.Option{
+		kaddht.ProtocolPrefix(cfg.ProtocolPrefix),
+		kaddht.Validator(cfg.Validator),
+		kaddht.Datastore(cfg.Datastore),
+	}
+	if cfg.BucketSize > 0 {
+		opts = append(opts, kaddht.BucketSize(cfg.BucketSize))
+	}
+	if !cfg.EnableValues {
+		opts = append(opts, kaddht.DisableValues())
+	}
+	if !cfg.EnableProviders {
+		opts = append(opts, kaddht.DisableProviders())
+	}
+	opts = append(opts, serverOpts...)
+	opts = append(opts,
+		kaddht.Mode(kaddht.ModeServer),
+		kaddht.ProviderStore(dht.ProviderManager),
+		kaddht.CloserPeers(dht.closestPeers),
+		// the closer peers come from the FullRT routing table, so the one of
+		// the server is left empty, never refreshed nor refilled
+		kaddht.RoutingTableFilter(func(interface{}, peer.ID) bool { return false }),
+		kaddht.DisableAutoRefresh(),
+		func(c *internalConfig.Config) error {
+			c.DisableFixLowPeers = true
+			return nil
+		},
+	)
+	return kaddht.New(ctx, dht.h, opts...)
+}
+
+// closestPeers returns the count peers of the routing table closest to target,
+// along with their addresses. Unlike the IpfsDHT, which answers from the bucket
+// of the key, these are the closest peers of the whole network as of the last
+// crawl.
+func (dht *FullRT) closestPeers(target kb.ID, count int) []peer.AddrInfo {
+	kadKey := kadkey.KbucketIDToKey(target)
+	dht.rtLk.RLock()
+	closestKeys := kademlia.ClosestN(kadKey, dht.rt, count)
+	dht.rtLk.RUnlock()
+
+	infos := make([]peer.AddrInfo, 0, len(closestKeys))
+	for _, k := range closestKeys {
+		dht.kMapLk.RLock()
+		p, ok := dht.keyToPeerMap[string(k)]
+		dht.kMapLk.RUnlock()
+		if !ok {
+			continue
+		}
+		dht.peerAddrsLk.RLock()
+		addrs := dht.peerAddrs[p]
+		dht.peerAddrsLk.RUnlock()
+		if len(addrs) == 0 {
+			continue
+		}
+		infos = append(infos, peer.AddrInfo{ID: p, Addrs: addrs})
+	}
+	return infos
+}
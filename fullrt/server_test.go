@@ -0,0 +1,126 @@
+package fullrt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/internal/net"
+	dht_pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type blankValidator struct{}
+
+func (blankValidator) Validate(_ string, _ []byte) error        { return nil }
+func (blankValidator) Select(_ string, _ [][]byte) (int, error) { return 0, nil }
+
+func TestServerMode(t *testing.T) {
+	ctx := context.Background()
+	const bucketSize = 3
+
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	rt, err := NewFullRT(h, "/test",
+		WithCrawler(idleCrawler{}),
+		WithServerMode(dht.MaxProvidersPerPeer(1)),
+		DHTOption(dht.BucketSize(bucketSize), dht.BootstrapPeers(), dht.NamespacedValidator("v", blankValidator{})),
+	)
+	require.NoError(t, err)
+	defer rt.Close()
+
+	client, err := libp2p.New()
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}))
+
+	// fill the routing table, as a crawl would
+	var peers []peer.ID
+	for i := 0; i < 20; i++ {
+		p := test.RandPeerIDFatal(t)
+		h.Peerstore().AddAddrs(p, []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}, peerstore.PermanentAddrTTL)
+		rt.addPeer(p)
+		peers = append(peers, p)
+	}
+	rt.addPeer(client.ID())
+
+	pm, err := dht_pb.NewProtocolMessenger(net.NewMessageSenderImpl(client, []protocol.ID{"/test/kad/1.0.0"}))
+	require.NoError(t, err)
+	require.NoError(t, pm.Ping(ctx, h.ID()))
+
+	ids := func(infos []*peer.AddrInfo) []peer.ID {
+		res := make([]peer.ID, len(infos))
+		for i, ai := range infos {
+			require.NotEmpty(t, ai.Addrs)
+			res[i] = ai.ID
+		}
+		return res
+	}
+
+	// the closest peers of the whole routing table are returned, never the
+	// requester itself
+	target := test.RandPeerIDFatal(t)
+	closer, err := pm.GetClosestPeers(ctx, h.ID(), target)
+	require.NoError(t, err)
+	require.Equal(t, kb.SortClosestPeers(peers, kb.ConvertPeerID(target))[:bucketSize], ids(closer))
+
+	// a peer of the routing table is returned when looked up
+	closer, err = pm.GetClosestPeers(ctx, h.ID(), peers[7])
+	require.NoError(t, err)
+	require.Equal(t, peers[7], closer[0].ID)
+
+	rec := record.MakePutRecord("/v/hello", []byte("world"))
+	require.NoError(t, pm.PutValue(ctx, h.ID(), rec))
+	got, closer, err := pm.GetValue(ctx, h.ID(), "/v/hello")
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), got.GetValue())
+	require.Len(t, closer, bucketSize)
+
+	mh, err := multihash.Sum([]byte("data"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, pm.PutProvider(ctx, h.ID(), mh, client))
+	provs, closer, err := pm.GetProviders(ctx, h.ID(), mh)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{client.ID()}, ids(provs))
+	require.Equal(t, kb.SortClosestPeers(peers, kb.ConvertKey(string(mh)))[:bucketSize], ids(closer))
+	keyProvs, err := pm.GetProvidersBatch(ctx, h.ID(), []multihash.Multihash{mh})
+	require.NoError(t, err)
+	require.Len(t, keyProvs, 1)
+	require.Equal(t, []peer.ID{client.ID()}, ids(dht_pb.PBPeersToPeerInfos(keyProvs[0].GetProviders())))
+	found, err := rt.FindProviders(ctx, cid.NewCidV1(cid.Raw, mh))
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	// the server options apply
+	mh2, err := multihash.Sum([]byte("other data"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	_ = pm.PutProvider(ctx, h.ID(), mh2, client)
+	provs, _, err = pm.GetProviders(ctx, h.ID(), mh2)
+	require.NoError(t, err)
+	require.Empty(t, provs)
+
+	// the protocol isn't served once closed
+	require.NoError(t, rt.Close())
+	require.Error(t, pm.Ping(ctx, h.ID()))
+}
+
+func TestClientMode(t *testing.T) {
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	defer h.Close()
+	rt, err := NewFullRT(h, "/test", WithCrawler(idleCrawler{}), DHTOption(dht.BootstrapPeers()))
+	require.NoError(t, err)
+	defer rt.Close()
+	require.NotContains(t, h.Mux().Protocols(), protocol.ID("/test/kad/1.0.0"))
+}
@@ -7,11 +7,12 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
-	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
 
 	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-kad-dht/amino"
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
 	"github.com/multiformats/go-base32"
 	"google.golang.org/protobuf/proto"
@@ -43,6 +44,10 @@ func (dht *IpfsDHT) handlerForMsgType(t pb.Message_MessageType) dhtHandler {
 			return dht.handleAddProvider
 		case pb.Message_GET_PROVIDERS:
 			return dht.handleGetProviders
+		case pb.Message_GET_ENCRYPTED_PROVIDERS:
+			return dht.handleGetEncryptedProviders
+		case pb.Message_GET_PROVIDERS_BATCH:
+			return dht.handleGetProvidersBatch
 		}
 	}
 
@@ -66,10 +71,8 @@ func (dht *IpfsDHT) handleGetValue(ctx context.Context, p peer.ID, pmes *pb.Mess
 	resp.Record = rec
 
 	// Find closest peer on given cluster to desired key and reply with that info
-	closer := dht.betterPeersToQuery(pmes, p, dht.bucketSize)
-	if len(closer) > 0 {
-		// TODO: pstore.PeerInfos should move to core (=> peerstore.AddrInfos).
-		closerinfos := pstore.PeerInfos(dht.peerstore, closer)
+	closerinfos := dht.closerPeerInfos(pmes, p, dht.bucketSize)
+	if len(closerinfos) > 0 {
 		for _, pi := range closerinfos {
 			logger.Debugf("handleGetValue returning closer peer: '%s'", pi.ID)
 			if len(pi.Addrs) < 1 {
@@ -171,14 +174,7 @@ func (dht *IpfsDHT) handlePutValue(ctx context.Context, p peer.ID, pmes *pb.Mess
 
 	dskey := convertToDsKey(rec.GetKey())
 
-	// fetch the striped lock for this key
-	var indexForLock byte
-	if len(rec.GetKey()) == 0 {
-		indexForLock = 0
-	} else {
-		indexForLock = rec.GetKey()[len(rec.GetKey())-1]
-	}
-	lk := &dht.stripedPutLocks[indexForLock]
+	lk := dht.putLock(rec.GetKey())
 	lk.Lock()
 	defer lk.Unlock()
 
@@ -211,7 +207,19 @@ func (dht *IpfsDHT) handlePutValue(ctx context.Context, p peer.ID, pmes *pb.Mess
 		return nil, err
 	}
 
+	if dht.admission != nil {
+		if err := dht.admission.admitRecord(ctx, p, string(rec.GetKey())); err != nil {
+			logger.Debugw("rejecting dht record in PUT", "from", p, "key", internal.LoggableRecordKeyBytes(rec.GetKey()), "error", err)
+			return nil, err
+		}
+	}
+
 	err = dht.datastore.Put(ctx, dskey, data)
+	if err == nil && dht.republisher != nil && dht.closeToKey(string(rec.GetKey())) {
+		if err := dht.republisher.track(ctx, string(rec.GetKey()), false); err != nil {
+			logger.Warnw("failed to track record for republishing", "key", internal.LoggableRecordKeyBytes(rec.GetKey()), "error", err)
+		}
+	}
 	return pmes, err
 }
 
@@ -252,7 +260,6 @@ func (dht *IpfsDHT) handlePing(_ context.Context, p peer.ID, pmes *pb.Message) (
 
 func (dht *IpfsDHT) handleFindPeer(ctx context.Context, from peer.ID, pmes *pb.Message) (_ *pb.Message, _err error) {
 	resp := pb.NewMessage(pmes.GetType(), nil, pmes.GetClusterLevel())
-	var closest []peer.ID
 
 	if len(pmes.GetKey()) == 0 {
 		return nil, errors.New("handleFindPeer with empty key")
@@ -260,34 +267,31 @@ func (dht *IpfsDHT) handleFindPeer(ctx context.Context, from peer.ID, pmes *pb.M
 
 	// if looking for self... special case where we send it on CloserPeers.
 	targetPid := peer.ID(pmes.GetKey())
-	closest = dht.betterPeersToQuery(pmes, from, dht.bucketSize)
+	closestinfos := dht.closerPeerInfos(pmes, from, dht.bucketSize)
 
 	// Never tell a peer about itself.
 	if targetPid != from {
 		// Add the target peer to the set of closest peers if
 		// not already present in our routing table.
 		//
-		// Later, when we lookup known addresses for all peers
-		// in this set, we'll prune this peer if we don't
-		// _actually_ know where it is.
+		// Later, we'll prune this peer if we don't _actually_
+		// know where it is.
 		found := false
-		for _, p := range closest {
-			if targetPid == p {
+		for _, pi := range closestinfos {
+			if targetPid == pi.ID {
 				found = true
 				break
 			}
 		}
 		if !found {
-			closest = append(closest, targetPid)
+			closestinfos = append(closestinfos, dht.peerstore.PeerInfo(targetPid))
 		}
 	}
 
-	if closest == nil {
+	if len(closestinfos) == 0 {
 		return resp, nil
 	}
 
-	// TODO: pstore.PeerInfos should move to core (=> peerstore.AddrInfos).
-	closestinfos := pstore.PeerInfos(dht.peerstore, closest)
 	// possibly an over-allocation but this array is temporary anyways.
 	withAddresses := make([]peer.AddrInfo, 0, len(closestinfos))
 	for _, pi := range closestinfos {
@@ -307,11 +311,14 @@ func (dht *IpfsDHT) handleGetProviders(ctx context.Context, p peer.ID, pmes *pb.
 	} else if len(key) == 0 {
 		return nil, errors.New("handleGetProviders key is empty")
 	}
+	if pmes.GetKeyPrefixBits() > 0 && dht.servePrefixLookups {
+		return dht.handleGetProvidersByPrefix(ctx, p, pmes)
+	}
 
 	resp := pb.NewMessage(pmes.GetType(), pmes.GetKey(), pmes.GetClusterLevel())
 
 	// setup providers
-	providers, err := dht.providerStore.GetProviders(ctx, key)
+	providers, records, err := dht.getSignedProviders(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -325,12 +332,76 @@ func (dht *IpfsDHT) handleGetProviders(ctx context.Context, p peer.ID, pmes *pb.
 	}
 
 	resp.ProviderPeers = pb.PeerInfosToPBPeers(dht.host.Network(), filtered)
+	resp.SignedProviderRecords = records
 
 	// Also send closer peers.
-	closer := dht.betterPeersToQuery(pmes, p, dht.bucketSize)
-	if closer != nil {
-		// TODO: pstore.PeerInfos should move to core (=> peerstore.AddrInfos).
-		infos := pstore.PeerInfos(dht.peerstore, closer)
+	if infos := dht.closerPeerInfos(pmes, p, dht.bucketSize); len(infos) > 0 {
+		resp.CloserPeers = pb.PeerInfosToPBPeers(dht.host.Network(), infos)
+	}
+
+	return resp, nil
+}
+
+func (dht *IpfsDHT) handleGetProvidersBatch(ctx context.Context, p peer.ID, pmes *pb.Message) (_ *pb.Message, _err error) {
+	keys := pmes.GetKeys()
+	if len(keys) > amino.MaxProvidersBatchSize {
+		return nil, errors.New("handleGetProvidersBatch too many keys")
+	} else if len(keys) == 0 {
+		return nil, errors.New("handleGetProvidersBatch no keys")
+	}
+
+	resp := pb.NewMessage(pmes.GetType(), nil, pmes.GetClusterLevel())
+
+	for _, key := range keys {
+		if len(key) > 80 {
+			return nil, errors.New("handleGetProvidersBatch key size too large")
+		} else if len(key) == 0 {
+			return nil, errors.New("handleGetProvidersBatch key is empty")
+		}
+
+		providers, records, err := dht.getSignedProviders(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(providers) == 0 {
+			continue
+		}
+
+		filtered := make([]peer.AddrInfo, len(providers))
+		for i, provider := range providers {
+			filtered[i] = peer.AddrInfo{
+				ID:    provider.ID,
+				Addrs: dht.filterAddrs(provider.Addrs),
+			}
+		}
+		resp.KeyProviders = append(resp.KeyProviders, &pb.KeyProviders{
+			Key:                   key,
+			Providers:             pb.PeerInfosToPBPeers(dht.host.Network(), filtered),
+			SignedProviderRecords: records,
+		})
+	}
+
+	// closer peers depend on each key, callers already know them
+	return resp, nil
+}
+
+func (dht *IpfsDHT) handleGetEncryptedProviders(ctx context.Context, p peer.ID, pmes *pb.Message) (_ *pb.Message, _err error) {
+	key := pmes.GetKey()
+	if len(key) > 80 {
+		return nil, errors.New("handleGetEncryptedProviders key size too large")
+	} else if len(key) == 0 {
+		return nil, errors.New("handleGetEncryptedProviders key is empty")
+	}
+
+	resp := pb.NewMessage(pmes.GetType(), pmes.GetKey(), pmes.GetClusterLevel())
+
+	records, err := dht.getEncryptedProviders(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.EncryptedProviderRecords = records
+
+	if infos := dht.closerPeerInfos(pmes, p, dht.bucketSize); len(infos) > 0 {
 		resp.CloserPeers = pb.PeerInfosToPBPeers(dht.host.Network(), infos)
 	}
 
@@ -347,13 +418,64 @@ func (dht *IpfsDHT) handleAddProvider(ctx context.Context, p peer.ID, pmes *pb.M
 
 	logger.Debugw("adding provider", "from", p, "key", internal.LoggableProviderRecordBytes(key))
 
+	// the sender is only charged for the announcements that are stored
+	admit := func() error {
+		if dht.admission == nil {
+			return nil
+		}
+		err := dht.admission.admitProvider(ctx, p, string(key))
+		if err != nil {
+			logger.Debugw("rejecting provider", "from", p, "key", internal.LoggableProviderRecordBytes(key), "error", err)
+		}
+		return err
+	}
+
+	// encrypted provider records are stored as is, on behalf of the sender
+	if recs := pmes.GetEncryptedProviderRecords(); len(recs) > 0 {
+		if err := providers.ValidateEncryptedProviderRecord(key, recs[0]); err != nil {
+			return nil, err
+		}
+		if err := admit(); err != nil {
+			return nil, err
+		}
+		return nil, dht.addEncryptedProvider(ctx, key, p, recs[0])
+	}
+
+	// signed provider records take precedence over the unsigned addresses
+	success := false
+	for _, envelope := range pmes.GetSignedProviderRecords() {
+		rec, err := providers.OpenProviderRecord(envelope, key)
+		if err != nil {
+			logger.Debugw("invalid signed provider record", "from", p, "error", err)
+			continue
+		}
+		if rec.PeerID != p {
+			// we should ignore this provider record! not from originator.
+			logger.Debugw("received signed provider record from wrong peer", "from", p, "peer", rec.PeerID)
+			continue
+		}
+		if len(rec.Addrs) < 1 {
+			logger.Debugw("no valid addresses in signed provider record", "from", p)
+			continue
+		}
+
+		if err := admit(); err != nil {
+			return nil, err
+		}
+		addrs := dht.filterAddrs(rec.Addrs)
+		dht.addSignedProvider(ctx, key, peer.AddrInfo{ID: p, Addrs: addrs}, envelope)
+		success = true
+		break
+	}
+	if success {
+		return nil, nil
+	}
+
 	// add provider should use the address given in the message
 	pinfos := pb.PBPeersToPeerInfos(pmes.GetProviderPeers())
-	success := false
 	for _, pi := range pinfos {
 		if pi.ID != p {
 			// we should ignore this provider record! not from originator.
-			// (we should sign them and check signature later...)
 			logger.Debugw("received provider from wrong peer", "from", p, "peer", pi.ID)
 			continue
 		}
@@ -366,6 +488,9 @@ func (dht *IpfsDHT) handleAddProvider(ctx context.Context, p peer.ID, pmes *pb.M
 		// We run the addrs filter after checking for the length, this allows
 		// transient nodes with varying /p2p-circuit addresses to still have their
 		// announcement go through.
+		if err := admit(); err != nil {
+			return nil, err
+		}
 		addrs := dht.filterAddrs(pi.Addrs)
 		dht.providerStore.AddProvider(ctx, key, peer.AddrInfo{ID: pi.ID, Addrs: addrs})
 		success = true
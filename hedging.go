@@ -0,0 +1,159 @@
+package dht
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal/metrics"
+)
+
+const (
+	// queryLatencyWindow is the number of recent lookup requests the hedging
+	// threshold is computed from.
+	queryLatencyWindow = 256
+	// minHedgingSamples is the number of lookup requests to time before
+	// hedging any.
+	minHedgingSamples = 16
+)
+
+// Outcomes of the extra requests started by lookups to replace slow peers.
+const (
+	// hedgeWon means the extra request was answered while the slow peer
+	// hadn't answered, or after it failed.
+	hedgeWon = "won"
+	// hedgeLost means the slow peer was answered first, or the extra request
+	// failed.
+	hedgeLost = "lost"
+	// hedgeCancelled means the lookup terminated before either request was
+	// answered.
+	hedgeCancelled = "cancelled"
+)
+
+// queryLatencies keeps the duration of the latest requests sent by lookups. It
+// is safe for concurrent use.
+type queryLatencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newQueryLatencies() *queryLatencies {
+	return &queryLatencies{samples: make([]time.Duration, 0, queryLatencyWindow)}
+}
+
+// record adds the duration of a request, replacing the oldest one once the
+// window is full.
+func (l *queryLatencies) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < queryLatencyWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % queryLatencyWindow
+}
+
+// percentile returns the given percentile of the recorded durations, false if
+// too few requests were recorded yet.
+func (l *queryLatencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mu.Unlock()
+	if len(sorted) < minHedgingSamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)], true
+}
+
+// hedgeDelay returns how long a lookup waits for a response before sending an
+// extra request, false if hedging is disabled or the threshold isn't known yet.
+func (dht *IpfsDHT) hedgeDelay() (time.Duration, bool) {
+	if dht.hedgePercentile == 0 {
+		return 0, false
+	}
+	return dht.queryLatencies.percentile(dht.hedgePercentile)
+}
+
+// slowDelay returns how long the query waits for p to answer before giving its
+// concurrency slot to another peer, false if it waits until p answers.
+func (q *query) slowDelay(p peer.ID) (time.Duration, bool) {
+	slow, slowOk := q.dht.slowPeerDelay(p)
+	hedge, hedgeOk := q.dht.hedgeDelay()
+	switch {
+	case slowOk && hedgeOk:
+		return min(slow, hedge), true
+	case slowOk:
+		return slow, true
+	default:
+		return hedge, hedgeOk
+	}
+}
+
+// extraSlots returns the number of queries that can run on top of the
+// concurrency limit, as slow peers don't count towards it. They are capped by
+// the hedging limit, or by the concurrency limit without hedging.
+func (q *query) extraSlots() int {
+	if q.dht.maxHedgedRequests > 0 {
+		return min(len(q.slowPeers), q.dht.maxHedgedRequests)
+	}
+	return min(len(q.slowPeers), q.dht.alpha)
+}
+
+// startHedge pairs the query of p, which only runs because a peer is slow,
+// with a slow peer not being hedged yet.
+func (q *query) startHedge(p peer.ID) {
+	for slow := range q.slowPeers {
+		if _, ok := q.hedgedBy[slow]; !ok {
+			q.hedgedBy[slow] = p
+			q.hedgeOf[p] = slow
+			return
+		}
+	}
+}
+
+// resolveHedge records the outcome of the hedged request involving p, if any,
+// once p answered or failed. It returns the outcome recorded, if any.
+//
+// The outcome depends on which request produced a response: if the slow peer
+// fails, the pair is left open until the extra request resolves.
+func (q *query) resolveHedge(ctx context.Context, p peer.ID, answered bool) string {
+	if slow, ok := q.hedgeOf[p]; ok {
+		outcome := hedgeLost
+		if answered {
+			outcome = hedgeWon
+		}
+		metrics.RecordHedgedRequest(ctx, outcome)
+		delete(q.hedgeOf, p)
+		delete(q.hedgedBy, slow)
+		return outcome
+	}
+	if hedge, ok := q.hedgedBy[p]; ok {
+		delete(q.hedgedBy, p)
+		if !answered {
+			// the extra request may still save the lookup
+			return ""
+		}
+		metrics.RecordHedgedRequest(ctx, hedgeLost)
+		delete(q.hedgeOf, hedge)
+		return hedgeLost
+	}
+	return ""
+}
+
+// cancelHedges records the hedged requests still running when the query
+// terminates.
+func (q *query) cancelHedges(ctx context.Context) {
+	for range q.hedgeOf {
+		metrics.RecordHedgedRequest(ctx, hedgeCancelled)
+	}
+	clear(q.hedgeOf)
+	clear(q.hedgedBy)
+}
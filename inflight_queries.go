@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InFlightQuery describes a DHT lookup in progress.
+type InFlightQuery struct {
+	// IDs are the IDs of the lookup in the lookup events, one per disjoint
+	// path (see DisjointPaths).
+	IDs []uuid.UUID
+	// Key is the key looked up.
+	Key string
+	// Operation is the operation the lookup is run for.
+	Operation Operation
+	// StartedAt is the time the lookup started.
+	StartedAt time.Time
+}
+
+// inFlightQueries tracks the lookups in progress. The zero value is ready to
+// use and it is safe for concurrent use.
+type inFlightQueries struct {
+	mu      sync.Mutex
+	queries map[uuid.UUID]InFlightQuery
+}
+
+// add tracks q until the returned function is called.
+func (f *inFlightQueries) add(q InFlightQuery) (done func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.queries == nil {
+		f.queries = make(map[uuid.UUID]InFlightQuery)
+	}
+	id := q.IDs[0]
+	f.queries[id] = q
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.queries, id)
+	}
+}
+
+// list returns the lookups in progress, oldest first.
+func (f *inFlightQueries) list() []InFlightQuery {
+	f.mu.Lock()
+	out := make([]InFlightQuery, 0, len(f.queries))
+	for _, q := range f.queries {
+		out = append(out, q)
+	}
+	f.mu.Unlock()
+	slices.SortFunc(out, func(a, b InFlightQuery) int { return a.StartedAt.Compare(b.StartedAt) })
+	return out
+}
+
+// InFlightQueries returns the lookups this node is currently running, oldest
+// first.
+func (dht *IpfsDHT) InFlightQueries() []InFlightQuery {
+	return dht.inFlight.list()
+}
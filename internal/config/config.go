@@ -13,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p-kad-dht/internal/net"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	kb "github.com/libp2p/go-libp2p-kbucket"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
 	record "github.com/libp2p/go-libp2p-record"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -35,6 +36,17 @@ type QueryFilterFunc func(dht interface{}, ai peer.AddrInfo) bool
 // the local route table.
 type RouteTableFilterFunc func(dht interface{}, p peer.ID) bool
 
+// CloserPeersFunc returns the count peers closest to target known to the node,
+// along with their addresses.
+type CloserPeersFunc func(target kb.ID, count int) []peer.AddrInfo
+
+// RateLimit is a token bucket refilled with Rate tokens per second, holding at
+// most Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
 // Config is a structure containing all the options that can be used when constructing a DHT.
 type Config struct {
 	Datastore              ds.Batching
@@ -47,6 +59,7 @@ type Config struct {
 	Concurrency            int
 	Resiliency             int
 	MaxRecordAge           time.Duration
+	RecordGCInterval       time.Duration
 	EnableProviders        bool
 	EnableValues           bool
 	ProviderStore          providers.ProviderStore
@@ -62,18 +75,98 @@ type Config struct {
 		CheckInterval       time.Duration
 		PeerFilter          RouteTableFilterFunc
 		DiversityFilter     peerdiversity.PeerIPGroupFilter
+		Persist             bool
+		SnapshotInterval    time.Duration
+	}
+
+	Reprovider struct {
+		Enabled  bool
+		Interval time.Duration
+	}
+
+	Republisher struct {
+		Enabled  bool
+		Interval time.Duration
+	}
+
+	// Admission limits what remote peers can store on this node, zero values
+	// mean unlimited.
+	Admission struct {
+		MaxRecordsPerPeer   int
+		MaxProvidersPerPeer int
+		MaxStoredRecords    int
+		RateLimits          map[pb.Message_MessageType]RateLimit
+	}
+
+	// RequestScheduler bounds the number of inbound requests handled
+	// concurrently, zero MaxConcurrency means unbounded.
+	RequestScheduler struct {
+		MaxConcurrency int
+		QueueSize      int
+		QueueTimeout   time.Duration
+		Priorities     map[pb.Message_MessageType]int
 	}
 
 	BootstrapPeers func() []peer.AddrInfo
 	AddressFilter  func([]ma.Multiaddr) []ma.Multiaddr
 	OnRequestHook  func(ctx context.Context, s network.Stream, req *pb.Message)
+	CloserPeers    CloserPeersFunc
+
+	// DisableFixLowPeers is set for tests and for DHTs that don't maintain
+	// their routing table, like the server of fullrt.FullRT.
+	DisableFixLowPeers bool
 
 	// test specific Config options
-	DisableFixLowPeers          bool
 	TestAddressUpdateProcessing bool
 
 	EnableOptimisticProvide       bool
 	OptimisticProvideJobsPoolSize int
+
+	SignProviderRecords          bool
+	RequireSignedProviderRecords bool
+
+	EncryptProviderRecords bool
+	PrivateLookups         bool
+
+	// PrefixLookups configures the provider lookups by key prefix, Bits is
+	// the length of the prefix sent when looking for providers (0 disables
+	// them), FullKeyFallback asks the peers that don't support them for the
+	// full key, Serve answers them and MaxResults caps the provider records
+	// served per request.
+	PrefixLookups struct {
+		Bits            int
+		MaxResults      int
+		FullKeyFallback bool
+		Serve           bool
+	}
+
+	// SlowPeerMinDelay is the minimum time lookups wait for a peer slower
+	// than expected before querying another one, 0 disables it.
+	SlowPeerMinDelay time.Duration
+
+	// Hedging configures the extra requests sent by lookups once a request
+	// takes longer than Percentile of the recent ones (0 disables it), at
+	// most MaxExtra at a time per lookup.
+	Hedging struct {
+		Percentile float64
+		MaxExtra   int
+	}
+
+	// DisjointPaths is the number of disjoint paths lookups are split into.
+	DisjointPaths int
+
+	// LookupCache keeps the results of up to Size recent GetClosestPeers
+	// lookups for TTL to seed other lookups, zero Size disables it.
+	LookupCache struct {
+		Size int
+		TTL  time.Duration
+	}
+
+	// TrafficAccounting accounts the traffic exchanged with up to MaxPeers
+	// remote peers, zero MaxPeers disables it.
+	TrafficAccounting struct {
+		MaxPeers int
+	}
 }
 
 func EmptyQueryFilter(_ interface{}, ai peer.AddrInfo) bool { return true }
@@ -127,9 +220,29 @@ var Defaults = func(o *Config) error {
 	o.RoutingTable.RefreshInterval = 10 * time.Minute
 	o.RoutingTable.AutoRefresh = true
 	o.RoutingTable.PeerFilter = EmptyRTFilter
+	o.RoutingTable.SnapshotInterval = 10 * time.Minute
 
 	o.MaxRecordAge = providers.ProvideValidity
 
+	o.Reprovider.Interval = amino.DefaultReprovideInterval
+	o.Republisher.Interval = amino.DefaultRepublishInterval
+
+	o.PrefixLookups.MaxResults = 256
+	o.DisjointPaths = 1
+
+	o.RequestScheduler.QueueSize = 256
+	o.RequestScheduler.QueueTimeout = time.Second
+	o.RequestScheduler.Priorities = map[pb.Message_MessageType]int{
+		pb.Message_PING:                    0,
+		pb.Message_FIND_NODE:               0,
+		pb.Message_GET_VALUE:               1,
+		pb.Message_PUT_VALUE:               2,
+		pb.Message_ADD_PROVIDER:            2,
+		pb.Message_GET_PROVIDERS:           3,
+		pb.Message_GET_ENCRYPTED_PROVIDERS: 3,
+		pb.Message_GET_PROVIDERS_BATCH:     3,
+	}
+
 	o.BucketSize = amino.DefaultBucketSize
 	o.Concurrency = amino.DefaultConcurrency
 	o.Resiliency = amino.DefaultResiliency
@@ -28,6 +28,8 @@ const (
 const (
 	KeyMessageType = "message_type"
 	KeyPeerID      = "peer_id"
+	KeyReason      = "reason"
+	KeyOutcome     = "outcome"
 	// KeyInstanceID identifies a dht instance by the pointer address.
 	// Useful for differentiating between different dhts that have the same peer id.
 	KeyInstanceID = "instance_id"
@@ -59,6 +61,22 @@ var (
 		metric.WithUnit(unitBytes),
 		metric.WithExplicitBucketBoundaries(defaultBytesDistribution...),
 	)
+	rejectedRequests, _ = meter.Int64Counter(
+		"rpc.inbound.rejected_requests",
+		metric.WithDescription("Total number of requests rejected by admission control or load shedding per RPC"),
+		metric.WithUnit(unitCount),
+	)
+	queuedRequests, _ = meter.Int64UpDownCounter(
+		"rpc.inbound.queue_depth",
+		metric.WithDescription("Number of requests waiting for a handler per RPC"),
+		metric.WithUnit(unitCount),
+	)
+	requestQueueWait, _ = meter.Float64Histogram(
+		"rpc.inbound.queue_wait",
+		metric.WithDescription("Time spent by requests waiting for a handler per RPC"),
+		metric.WithUnit(unitMilliseconds),
+		metric.WithExplicitBucketBoundaries(defaultMillisecondsDistribution...),
+	)
 	inboundRequestLatency, _ = meter.Float64Histogram(
 		"rpc.inbound.request_latency",
 		metric.WithDescription("Latency per RPC"),
@@ -99,6 +117,23 @@ var (
 		metric.WithUnit(unitBytes),
 		metric.WithExplicitBucketBoundaries(defaultBytesDistribution...),
 	)
+	// lookup metrics
+	hedgedRequests, _ = meter.Int64Counter(
+		"lookup.hedged_requests",
+		metric.WithDescription("Total number of extra requests sent by lookups to replace slow peers per outcome"),
+		metric.WithUnit(unitCount),
+	)
+	// local storage metrics
+	collectedRecords, _ = meter.Int64Counter(
+		"records.gc.removed",
+		metric.WithDescription("Total number of value records removed by garbage collection"),
+		metric.WithUnit(unitCount),
+	)
+	collectedRecordBytes, _ = meter.Int64Counter(
+		"records.gc.reclaimed_bytes",
+		metric.WithDescription("Total size of the value records removed by garbage collection"),
+		metric.WithUnit(unitBytes),
+	)
 	networkSize, _ = meter.Int64Gauge(
 		"network.size",
 		metric.WithDescription("Network size estimation"),
@@ -131,6 +166,30 @@ func RecordMessageHandleErr(ctx context.Context) {
 	receivedMessageErrors.Add(ctx, 1, attrSetOpt)
 }
 
+// RecordRequestRejected records an inbound request rejected by admission
+// control, along with the reason it was rejected.
+func RecordRequestRejected(ctx context.Context, reason string) {
+	ctxAttrSet := AttributesFromContext(ctx)
+	attrSetOpt := metric.WithAttributeSet(ctxAttrSet)
+	attrOpt := metric.WithAttributes(attribute.Key(KeyReason).String(reason))
+
+	rejectedRequests.Add(ctx, 1, attrSetOpt, attrOpt)
+}
+
+// RecordRequestQueued records a change of the number of inbound requests
+// waiting for a handler.
+func RecordRequestQueued(ctx context.Context, delta int64) {
+	attrSetOpt := metric.WithAttributeSet(AttributesFromContext(ctx))
+	queuedRequests.Add(ctx, delta, attrSetOpt)
+}
+
+// RecordRequestQueueWait records the time an inbound request waited for a
+// handler.
+func RecordRequestQueueWait(ctx context.Context, waitMs float64) {
+	attrSetOpt := metric.WithAttributeSet(AttributesFromContext(ctx))
+	requestQueueWait.Record(ctx, waitMs, attrSetOpt)
+}
+
 func RecordRequestLatency(ctx context.Context, latencyMs float64) {
 	ctxAttrSet := AttributesFromContext(ctx)
 	attrSetOpt := metric.WithAttributeSet(ctxAttrSet)
@@ -172,6 +231,25 @@ func RecordMessageSendErr(ctx context.Context) {
 	sentMessageErrors.Add(ctx, 1, attrSetOpt)
 }
 
+// RecordRecordCollected records a value record of the given size removed by
+// garbage collection, along with the reason it was removed.
+func RecordRecordCollected(ctx context.Context, reason string, size int64) {
+	attrSetOpt := metric.WithAttributeSet(AttributesFromContext(ctx))
+	attrOpt := metric.WithAttributes(attribute.Key(KeyReason).String(reason))
+
+	collectedRecords.Add(ctx, 1, attrSetOpt, attrOpt)
+	collectedRecordBytes.Add(ctx, size, attrSetOpt, attrOpt)
+}
+
+// RecordHedgedRequest records the outcome of an extra request sent by a lookup
+// to replace a slow peer.
+func RecordHedgedRequest(ctx context.Context, outcome string) {
+	attrSetOpt := metric.WithAttributeSet(AttributesFromContext(ctx))
+	attrOpt := metric.WithAttributes(attribute.Key(KeyOutcome).String(outcome))
+
+	hedgedRequests.Add(ctx, 1, attrSetOpt, attrOpt)
+}
+
 func RecordNetworkSize(ns int64) {
 	networkSize.Record(context.Background(), int64(ns))
 }
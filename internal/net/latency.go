@@ -0,0 +1,69 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// minReadMessageTimeout is the shortest adaptive read timeout, so that peers
+// usually answering fast still get some slack for slower requests.
+var minReadMessageTimeout = 2 * time.Second
+
+// readTimeoutFactor is the number of expected latencies waited for a response
+// before timing out.
+const readTimeoutFactor = 4
+
+// rttEstimator keeps a smoothed estimate of the round trip time of the requests
+// sent to a peer, and of its variation, as TCP does for its retransmission
+// timeout (RFC 6298).
+type rttEstimator struct {
+	mu     sync.Mutex
+	srtt   time.Duration
+	rttvar time.Duration
+}
+
+// newRTTEstimator returns an estimator seeded with the latency previously
+// recorded for the peer, if any.
+func newRTTEstimator(seed time.Duration) *rttEstimator {
+	e := new(rttEstimator)
+	if seed > 0 {
+		e.srtt, e.rttvar = seed, seed/2
+	}
+	return e
+}
+
+// update records the round trip time of a request.
+func (e *rttEstimator) update(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.srtt == 0 {
+		e.srtt, e.rttvar = rtt, rtt/2
+		return
+	}
+	diff := e.srtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	e.rttvar = (3*e.rttvar + diff) / 4
+	e.srtt = (7*e.srtt + rtt) / 8
+}
+
+// expected returns the latency within which a response is expected, false if
+// no request was timed yet.
+func (e *rttEstimator) expected() (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.srtt == 0 {
+		return 0, false
+	}
+	return e.srtt + 4*e.rttvar, true
+}
+
+// readTimeout returns how long to wait for a response before giving up.
+func (e *rttEstimator) readTimeout() time.Duration {
+	expected, ok := e.expected()
+	if !ok {
+		return dhtReadMessageTimeout
+	}
+	return min(max(readTimeoutFactor*expected, minReadMessageTimeout), dhtReadMessageTimeout)
+}
@@ -0,0 +1,41 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	e := newRTTEstimator(0)
+	_, ok := e.expected()
+	require.False(t, ok)
+	require.Equal(t, dhtReadMessageTimeout, e.readTimeout())
+
+	e.update(100 * time.Millisecond)
+	expected, ok := e.expected()
+	require.True(t, ok)
+	require.Equal(t, 300*time.Millisecond, expected)
+	require.Equal(t, minReadMessageTimeout, e.readTimeout())
+
+	// a steady latency narrows the expected latency
+	for range 50 {
+		e.update(100 * time.Millisecond)
+	}
+	expected, _ = e.expected()
+	require.Less(t, expected, 110*time.Millisecond)
+
+	// slow responses raise it, up to the fixed timeout
+	for range 50 {
+		e.update(5 * time.Second)
+	}
+	expected, _ = e.expected()
+	require.Greater(t, expected, 4*time.Second)
+	require.Equal(t, dhtReadMessageTimeout, e.readTimeout())
+
+	seeded := newRTTEstimator(time.Second)
+	expected, ok = seeded.expected()
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, expected)
+}
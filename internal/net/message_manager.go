@@ -39,6 +39,9 @@ type messageSenderImpl struct {
 	protocols []protocol.ID
 }
 
+// NewMessageSenderImpl returns a message sender reusing streams to peers. The
+// returned sender also implements pb.MessageSenderWithLatency, and adapts how
+// long it waits for each peer to respond to the latency it observed.
 func NewMessageSenderImpl(h host.Host, protos []protocol.ID) pb.MessageSenderWithDisconnect {
 	return &messageSenderImpl{
 		host:      h,
@@ -93,12 +96,26 @@ func (m *messageSenderImpl) SendRequest(ctx context.Context, p peer.ID, pmes *pb
 		return nil, err
 	}
 
-	outboundLatency := float64(time.Since(start)) / float64(time.Millisecond)
+	rtt := time.Since(start)
+	outboundLatency := float64(rtt) / float64(time.Millisecond)
 	metrics.RecordRequestSendOK(ctx, int64(len(marshalled)), outboundLatency)
-	m.host.Peerstore().RecordLatency(p, time.Since(start))
+	m.host.Peerstore().RecordLatency(p, rtt)
+	ms.rtt.update(rtt)
 	return rpmes, nil
 }
 
+// ExpectedLatency returns the round trip time within which p is expected to
+// answer a request, estimated from the latency of its previous responses.
+func (m *messageSenderImpl) ExpectedLatency(p peer.ID) (time.Duration, bool) {
+	m.smlk.Lock()
+	ms, ok := m.strmap[p]
+	m.smlk.Unlock()
+	if ok {
+		return ms.rtt.expected()
+	}
+	return newRTTEstimator(m.host.Peerstore().LatencyEWMA(p)).expected()
+}
+
 // SendMessage sends out a message
 func (m *messageSenderImpl) SendMessage(ctx context.Context, p peer.ID, pmes *pb.Message) error {
 	ctx = metrics.ContextWithAttributes(ctx, metrics.UpsertMessageType(pmes))
@@ -134,7 +151,12 @@ func (m *messageSenderImpl) messageSenderForPeer(ctx context.Context, p peer.ID)
 		m.smlk.Unlock()
 		return ms, nil
 	}
-	ms = &peerMessageSender{p: p, m: m, lk: internal.NewCtxMutex()}
+	ms = &peerMessageSender{
+		p:   p,
+		m:   m,
+		lk:  internal.NewCtxMutex(),
+		rtt: newRTTEstimator(m.host.Peerstore().LatencyEWMA(p)),
+	}
 	m.strmap[p] = ms
 	m.smlk.Unlock()
 
@@ -167,6 +189,10 @@ type peerMessageSender struct {
 	p  peer.ID
 	m  *messageSenderImpl
 
+	// rtt estimates the latency of the requests sent to p, it bounds how long
+	// responses are waited for.
+	rtt *rttEstimator
+
 	invalid   bool
 	singleMes int
 }
@@ -330,7 +356,7 @@ func (ms *peerMessageSender) ctxReadMsg(ctx context.Context, mes *pb.Message) er
 		errc <- proto.Unmarshal(bytes, mes)
 	}(ms.r)
 
-	t := time.NewTimer(dhtReadMessageTimeout)
+	t := time.NewTimer(ms.rtt.readTimeout())
 	defer t.Stop()
 
 	select {
@@ -22,6 +22,7 @@ import (
 func (dht *IpfsDHT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.GetClosestPeers", trace.WithAttributes(internal.KeyAsAttribute("Key", key)))
 	defer span.End()
+	ctx = ContextWithOperation(ctx, OperationGetClosestPeers)
 
 	if key == "" {
 		return nil, errors.New("can't lookup empty key")
@@ -50,6 +51,10 @@ func (dht *IpfsDHT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID,
 	// successfully interacted with the closest peers to key
 	dht.routingTable.ResetCplRefreshedAtForID(kb.ConvertKey(key), time.Now())
 
+	if dht.lookupCache != nil {
+		dht.lookupCache.add(kb.ConvertKey(key), lookupRes.peers)
+	}
+
 	return lookupRes.peers, nil
 }
 
@@ -0,0 +1,154 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-xor/kademlia"
+	kadkey "github.com/libp2p/go-libp2p-xor/key"
+	"github.com/libp2p/go-libp2p-xor/trie"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// lookupCacheNeighbours is the number of cached lookups closest to a target
+// whose peers seed a new lookup.
+const lookupCacheNeighbours = 2
+
+// lookupCacheEntry is the result of a past GetClosestPeers lookup.
+type lookupCacheEntry struct {
+	target  kadkey.Key
+	peers   []peer.ID
+	expires time.Time
+}
+
+// lookupCache keeps the results of recent GetClosestPeers lookups, so that
+// lookups for nearby targets can start from the peers already found. It is
+// safe for concurrent use.
+type lookupCache struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	// targets holds the Kademlia keys of the cached lookups
+	targets *trie.Trie
+	// entries maps the cached Kademlia keys to their lookup results, and
+	// evicts the least recently used ones
+	entries *lru.LRU
+	// byPeer maps each peer to the Kademlia keys of the lookups it was found
+	// by, so that these are dropped if the peer goes away
+	byPeer map[peer.ID]map[string]struct{}
+}
+
+func newLookupCache(size int, ttl time.Duration) *lookupCache {
+	c := &lookupCache{
+		ttl:     ttl,
+		targets: trie.New(),
+		byPeer:  make(map[peer.ID]map[string]struct{}),
+	}
+	// the size is validated by the LookupCache option
+	c.entries, _ = lru.NewLRU(size, func(_, value interface{}) {
+		c.forget(value.(*lookupCacheEntry))
+	})
+	return c
+}
+
+// add caches the closest peers to target.
+func (c *lookupCache) add(target kb.ID, peers []peer.ID) {
+	if len(peers) == 0 {
+		return
+	}
+	k := kadkey.KbucketIDToKey(target)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// replace any previous result
+	c.entries.Remove(string(k))
+	c.entries.Add(string(k), &lookupCacheEntry{target: k, peers: peers, expires: time.Now().Add(c.ttl)})
+	c.targets.Add(k)
+	for _, p := range peers {
+		if c.byPeer[p] == nil {
+			c.byPeer[p] = make(map[string]struct{})
+		}
+		c.byPeer[p][string(k)] = struct{}{}
+	}
+}
+
+// peersNear returns the peers found by the cached lookups closest to target.
+func (c *lookupCache) peersNear(target kb.ID) []peer.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var peers []peer.ID
+	now := time.Now()
+	for _, k := range kademlia.ClosestN(kadkey.KbucketIDToKey(target), c.targets, lookupCacheNeighbours) {
+		v, ok := c.entries.Get(string(k))
+		if !ok {
+			continue
+		}
+		e := v.(*lookupCacheEntry)
+		if now.After(e.expires) {
+			c.entries.Remove(string(k))
+			continue
+		}
+		peers = append(peers, e.peers...)
+	}
+	return peers
+}
+
+// invalidatePeer drops the cached lookups that found p.
+func (c *lookupCache) invalidatePeer(p peer.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.byPeer[p] {
+		c.entries.Remove(k)
+	}
+}
+
+// forget removes the references to an entry evicted from the cache. It is
+// called with c.mu held.
+func (c *lookupCache) forget(e *lookupCacheEntry) {
+	c.targets.Remove(e.target)
+	for _, p := range e.peers {
+		delete(c.byPeer[p], string(e.target))
+		if len(c.byPeer[p]) == 0 {
+			delete(c.byPeer, p)
+		}
+	}
+}
+
+// invalidateCachedLookups drops the cached lookups that found p, if the cache
+// is enabled. The routing table only invalidates the peers it holds.
+func (dht *IpfsDHT) invalidateCachedLookups(p peer.ID) {
+	if dht.lookupCache != nil {
+		dht.lookupCache.invalidatePeer(p)
+	}
+}
+
+// seedPeers returns the count peers closest to target in the routing table
+// and in the lookup cache, if enabled.
+func (dht *IpfsDHT) seedPeers(target kb.ID, count int) []peer.ID {
+	peers := dht.routingTable.NearestPeers(target, count)
+	if dht.lookupCache == nil {
+		return peers
+	}
+	cached := dht.lookupCache.peersNear(target)
+	if len(cached) == 0 {
+		return peers
+	}
+
+	seen := make(map[peer.ID]struct{}, len(peers)+len(cached))
+	all := make([]peer.ID, 0, len(peers)+len(cached))
+	for _, p := range append(peers, cached...) {
+		if _, ok := seen[p]; ok || p == dht.self {
+			continue
+		}
+		seen[p] = struct{}{}
+		all = append(all, p)
+	}
+	sorted := kb.SortClosestPeers(all, target)
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
+}
@@ -0,0 +1,65 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	kadkey "github.com/libp2p/go-libp2p-xor/key"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCacheSeedsLookups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// d only keeps servers[0] in its routing table, which knows the others
+	servers := setupDHTS(t, ctx, 4)
+	for _, s := range servers[1:] {
+		connect(t, ctx, servers[0], s)
+	}
+	onlyFirst := RoutingTableFilter(func(_ interface{}, p peer.ID) bool { return p == servers[0].self })
+	d := setupDHT(ctx, t, false, LookupCache(16, time.Minute), onlyFirst)
+	connect(t, ctx, d, servers[0])
+
+	bar := kb.ConvertKey("bar")
+	require.Len(t, d.seedPeers(bar, d.bucketSize), 1)
+
+	peers, err := d.GetClosestPeers(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, peers, len(servers))
+	// other lookups now start from the peers found
+	require.ElementsMatch(t, peers, d.seedPeers(bar, d.bucketSize))
+
+	// evicting a peer from the routing table invalidates the lookups it was
+	// found by
+	d.routingTable.RemovePeer(servers[0].self)
+	require.Empty(t, d.lookupCache.peersNear(bar))
+}
+
+func TestLookupCache(t *testing.T) {
+	c := newLookupCache(2, time.Minute)
+	a, b := kb.ConvertKey("a"), kb.ConvertKey("b")
+	c.add(a, []peer.ID{"p1", "p2"})
+	c.add(b, []peer.ID{"p2", "p3"})
+	require.ElementsMatch(t, []peer.ID{"p1", "p2", "p2", "p3"}, c.peersNear(a))
+
+	// the least recently used lookup is evicted
+	c.entries.Get(string(kadkey.KbucketIDToKey(a)))
+	c.add(kb.ConvertKey("c"), []peer.ID{"p4"})
+	require.ElementsMatch(t, []peer.ID{"p1", "p2", "p4"}, c.peersNear(a))
+	require.NotContains(t, c.byPeer, peer.ID("p3"))
+
+	c.invalidatePeer("p2")
+	require.Equal(t, []peer.ID{"p4"}, c.peersNear(a))
+	require.Equal(t, 1, c.targets.Size())
+
+	// expired lookups are ignored
+	expired := newLookupCache(2, time.Nanosecond)
+	expired.add(a, []peer.ID{"p1"})
+	time.Sleep(time.Millisecond)
+	require.Empty(t, expired.peersNear(a))
+	require.Empty(t, expired.byPeer)
+}
@@ -79,6 +79,12 @@ type optimisticState struct {
 
 	// putProvDone counts the ADD_PROVIDER RPCs that have completed (successful and unsuccessful)
 	putProvDone atomic.Int32
+
+	// the provider information sent in ADD_PROVIDER RPCs
+	self peer.AddrInfo
+
+	// our signed provider record for the key, nil if disabled
+	envelope []byte
 }
 
 func (dht *IpfsDHT) newOptimisticState(ctx context.Context, key string) (*optimisticState, error) {
@@ -92,6 +98,11 @@ func (dht *IpfsDHT) newOptimisticState(ctx context.Context, key string) (*optimi
 	setThreshold := mathext.GammaIncRegInv(float64(dht.bucketSize)/2.0+1, 1-optProvSetThresholdStrictness) / float64(networkSize)
 	returnThreshold := int(math.Ceil(float64(dht.bucketSize) * optProvReturnRatio))
 
+	self := peer.AddrInfo{
+		ID:    dht.self,
+		Addrs: dht.filterAddrs(dht.host.Addrs()),
+	}
+
 	return &optimisticState{
 		putCtx:              ctx,
 		dht:                 dht,
@@ -104,6 +115,8 @@ func (dht *IpfsDHT) newOptimisticState(ctx context.Context, key string) (*optimi
 		setThreshold:        setThreshold,
 		returnThreshold:     returnThreshold,
 		putProvDone:         atomic.Int32{},
+		self:                self,
+		envelope:            dht.sealProviderRecord(multihash.Multihash(key), self),
 	}, nil
 }
 
@@ -236,10 +249,7 @@ func (os *optimisticState) stopFn(qps *qpeerset.QueryPeerset) bool {
 }
 
 func (os *optimisticState) putProviderRecord(pid peer.ID) {
-	err := os.dht.protoMessenger.PutProviderAddrs(os.putCtx, pid, []byte(os.key), peer.AddrInfo{
-		ID:    os.dht.self,
-		Addrs: os.dht.filterAddrs(os.dht.host.Addrs()),
-	})
+	err := os.dht.protoMessenger.PutSignedProviderAddrs(os.putCtx, pid, []byte(os.key), os.self, os.envelope)
 	os.peerStatesLk.Lock()
 	if err != nil {
 		os.peerStates[pid] = failure
@@ -0,0 +1,229 @@
+package dht
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// LookupTraceRecorder writes the lookup events of the lookups run with its
+// context to a writer, one JSON encoded LookupEvent per line. The resulting
+// trace can be replayed with ReplayLookupTrace.
+type LookupTraceRecorder struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// NewLookupTraceRecorder starts recording the lookup events of the lookups run
+// with the returned context, until Close is called or ctx is done. Closing the
+// recorder doesn't cancel the lookups. The events are still sent to the
+// channel registered on ctx with RegisterForLookupEvents, if any.
+//
+// Events are written as they come: once LookupEventBufferSize events are
+// pending, lookups wait for w, so a slow writer slows them down. Buffer w if
+// it may block.
+func NewLookupTraceRecorder(ctx context.Context, w io.Writer) (context.Context, *LookupTraceRecorder) {
+	recCtx, cancel := context.WithCancel(ctx)
+	ch := make(chan *LookupEvent, LookupEventBufferSize)
+	ech := &lookupEventChannel{ch: ch, ctx: recCtx}
+	if prev, ok := ctx.Value(routingLookupKey{}).(*lookupEventChannel); ok {
+		ech.next = prev
+	}
+	go ech.waitThenClose()
+
+	r := &LookupTraceRecorder{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		enc := json.NewEncoder(w)
+		for ev := range ch {
+			// keep draining the events, so that lookups aren't blocked
+			if r.err == nil {
+				r.err = enc.Encode(ev)
+			}
+		}
+	}()
+	return context.WithValue(ctx, routingLookupKey{}, ech), r
+}
+
+// Close stops recording and returns once every recorded event is written. It
+// returns the first error encountered while writing the trace.
+func (r *LookupTraceRecorder) Close() error {
+	r.cancel()
+	<-r.done
+	return r.err
+}
+
+// LookupReplay is the evolution of the peer set of a lookup, rebuilt from a
+// lookup trace.
+type LookupReplay struct {
+	// ID is the unique identifier of the lookup.
+	ID uuid.UUID
+	// Key is the target of the lookup.
+	Key *KeyKadID
+	// Steps lists the state of the lookup after each of its events, in order.
+	Steps []LookupReplayStep
+	// Terminate is the reason the lookup terminated, nil if the trace doesn't
+	// include its termination.
+	Terminate *LookupTerminationReason
+}
+
+// LookupReplayStep is the state of a lookup after one of its events.
+type LookupReplayStep struct {
+	Event *LookupEvent
+	// Peers are the peers known by the lookup after the event, sorted by
+	// distance to the target.
+	Peers []LookupReplayPeer
+}
+
+// LookupReplayPeer is the state of a peer within a lookup.
+type LookupReplayPeer struct {
+	ID    peer.ID
+	State qpeerset.PeerState
+	// ReferredBy is the peer the lookup heard of this peer from, the node
+	// performing the lookup for the peers it started with.
+	ReferredBy peer.ID
+}
+
+// ReplayLookupTrace reads a lookup trace written by a LookupTraceRecorder and
+// rebuilds the evolution of the peer set of each lookup, in the order the
+// lookups started.
+func ReplayLookupTrace(r io.Reader) ([]*LookupReplay, error) {
+	var replays []*LookupReplay
+	lookups := make(map[uuid.UUID]*lookupReplayer)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		ev := new(LookupEvent)
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if ev.Key == nil {
+			return nil, fmt.Errorf("line %d: lookup event without key", line)
+		}
+
+		l, ok := lookups[ev.ID]
+		if !ok {
+			l = &lookupReplayer{
+				replay: &LookupReplay{ID: ev.ID, Key: ev.Key},
+				peers:  qpeerset.NewQueryPeersetForKadID(ev.Key.Kad),
+			}
+			if ev.Node != nil {
+				l.self = ev.Node.Peer
+			}
+			lookups[ev.ID] = l
+			replays = append(replays, l.replay)
+		}
+		l.apply(ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return replays, nil
+}
+
+// lookupReplayer applies the events of a lookup to its peer set.
+type lookupReplayer struct {
+	self   peer.ID
+	replay *LookupReplay
+	peers  *qpeerset.QueryPeerset
+}
+
+func (l *lookupReplayer) apply(ev *LookupEvent) {
+	for _, up := range []*LookupUpdateEvent{ev.Request, ev.Response} {
+		if up == nil {
+			continue
+		}
+		source := l.self
+		if up.Source != nil {
+			source = up.Source.Peer
+		}
+		for _, p := range up.Heard {
+			if p.Peer != l.self {
+				l.peers.TryAdd(p.Peer, source)
+			}
+		}
+		l.setState(up.Waiting, source, qpeerset.PeerWaiting)
+		l.setState(up.Queried, source, qpeerset.PeerQueried)
+		l.setState(up.Unreachable, source, qpeerset.PeerUnreachable)
+	}
+	if ev.Terminate != nil {
+		reason := ev.Terminate.Reason
+		l.replay.Terminate = &reason
+	}
+
+	all := l.peers.GetClosestInStates(qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried, qpeerset.PeerUnreachable)
+	step := LookupReplayStep{Event: ev, Peers: make([]LookupReplayPeer, len(all))}
+	for i, p := range all {
+		step.Peers[i] = LookupReplayPeer{ID: p, State: l.peers.GetState(p), ReferredBy: l.peers.GetReferrer(p)}
+	}
+	l.replay.Steps = append(l.replay.Steps, step)
+}
+
+func (l *lookupReplayer) setState(peers []*PeerKadID, source peer.ID, state qpeerset.PeerState) {
+	for _, p := range peers {
+		if p.Peer == l.self {
+			continue
+		}
+		// events may have been dropped from the trace
+		l.peers.TryAdd(p.Peer, source)
+		l.peers.SetState(p.Peer, state)
+	}
+}
+
+// Render writes a human readable timeline of the lookup to w, with one line
+// per event and the number of peers in each state after it.
+func (r *LookupReplay) Render(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "lookup %s for %x\n", r.ID, []byte(r.Key.Key)); err != nil {
+		return err
+	}
+	var start time.Time
+	if len(r.Steps) > 0 {
+		start = r.Steps[0].Event.Time
+	}
+	for _, step := range r.Steps {
+		var counts [qpeerset.PeerUnreachable + 1]int
+		for _, p := range step.Peers {
+			counts[p.State]++
+		}
+		_, err := fmt.Fprintf(w, "%10s %-60s heard=%d waiting=%d queried=%d unreachable=%d\n",
+			step.Event.Time.Sub(start).Round(time.Microsecond), describeLookupEvent(step.Event),
+			counts[qpeerset.PeerHeard], counts[qpeerset.PeerWaiting], counts[qpeerset.PeerQueried], counts[qpeerset.PeerUnreachable])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describeLookupEvent(ev *LookupEvent) string {
+	switch {
+	case ev.Terminate != nil:
+		return "terminated: " + ev.Terminate.Reason.String()
+	case ev.Request != nil && len(ev.Request.Waiting) > 0:
+		return "query " + ev.Request.Waiting[0].Peer.String()
+	case ev.Response != nil && (ev.Response.Cause == nil || ev.Node != nil && ev.Response.Cause.Peer == ev.Node.Peer):
+		return fmt.Sprintf("seeded with %d peers", len(ev.Response.Heard))
+	case ev.Response != nil && len(ev.Response.Unreachable) > 0:
+		if ev.Response.Error != "" {
+			return fmt.Sprintf("unreachable %s: %s", ev.Response.Cause.Peer, ev.Response.Error)
+		}
+		return "unreachable " + ev.Response.Cause.Peer.String()
+	case ev.Response != nil:
+		return fmt.Sprintf("response from %s, %d peers", ev.Response.Cause.Peer, len(ev.Response.Heard))
+	default:
+		return "unknown event"
+	}
+}
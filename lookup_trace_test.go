@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+func TestLookupTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 4)
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[1], dhts[2])
+	connect(t, ctx, dhts[2], dhts[3])
+
+	// the subscriber registered before the recorder still gets the events
+	subCtx, cancelSub := context.WithCancel(ctx)
+	defer cancelSub()
+	subCtx, events := RegisterForLookupEvents(subCtx)
+	forwarded := make(chan int)
+	go func() {
+		n := 0
+		for range events {
+			n++
+		}
+		forwarded <- n
+	}()
+
+	var trace bytes.Buffer
+	traceCtx, rec := NewLookupTraceRecorder(subCtx, &trace)
+	ctxT, cancelT := context.WithTimeout(traceCtx, 10*time.Second)
+	defer cancelT()
+	_, err := dhts[0].GetClosestPeers(ctxT, "foo")
+	require.NoError(t, err)
+	require.NoError(t, rec.Close())
+	cancelSub()
+
+	replays, err := ReplayLookupTrace(&trace)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	replay := replays[0]
+	require.Equal(t, len(replay.Steps), <-forwarded)
+	require.Equal(t, "foo", replay.Key.Key)
+	require.NotNil(t, replay.Terminate)
+	require.Equal(t, LookupStarvation, *replay.Terminate)
+
+	// every other peer was heard of through the chain, and queried
+	last := replay.Steps[len(replay.Steps)-1]
+	require.Len(t, last.Peers, 3)
+	referrers := make(map[string]string)
+	for _, p := range last.Peers {
+		require.Equal(t, qpeerset.PeerQueried, p.State)
+		referrers[string(p.ID)] = string(p.ReferredBy)
+	}
+	require.Equal(t, string(dhts[0].self), referrers[string(dhts[1].self)])
+	require.Equal(t, string(dhts[1].self), referrers[string(dhts[2].self)])
+	require.Equal(t, string(dhts[2].self), referrers[string(dhts[3].self)])
+
+	var out strings.Builder
+	require.NoError(t, replay.Render(&out))
+	require.Contains(t, out.String(), "seeded with 1 peers")
+	require.Contains(t, out.String(), "terminated: starvation")
+}
@@ -25,12 +25,14 @@ const (
 type Message_MessageType int32
 
 const (
-	Message_PUT_VALUE     Message_MessageType = 0
-	Message_GET_VALUE     Message_MessageType = 1
-	Message_ADD_PROVIDER  Message_MessageType = 2
-	Message_GET_PROVIDERS Message_MessageType = 3
-	Message_FIND_NODE     Message_MessageType = 4
-	Message_PING          Message_MessageType = 5
+	Message_PUT_VALUE               Message_MessageType = 0
+	Message_GET_VALUE               Message_MessageType = 1
+	Message_ADD_PROVIDER            Message_MessageType = 2
+	Message_GET_PROVIDERS           Message_MessageType = 3
+	Message_FIND_NODE               Message_MessageType = 4
+	Message_PING                    Message_MessageType = 5
+	Message_GET_ENCRYPTED_PROVIDERS Message_MessageType = 6
+	Message_GET_PROVIDERS_BATCH     Message_MessageType = 7
 )
 
 // Enum value maps for Message_MessageType.
@@ -42,14 +44,18 @@ var (
 		3: "GET_PROVIDERS",
 		4: "FIND_NODE",
 		5: "PING",
+		6: "GET_ENCRYPTED_PROVIDERS",
+		7: "GET_PROVIDERS_BATCH",
 	}
 	Message_MessageType_value = map[string]int32{
-		"PUT_VALUE":     0,
-		"GET_VALUE":     1,
-		"ADD_PROVIDER":  2,
-		"GET_PROVIDERS": 3,
-		"FIND_NODE":     4,
-		"PING":          5,
+		"PUT_VALUE":               0,
+		"GET_VALUE":               1,
+		"ADD_PROVIDER":            2,
+		"GET_PROVIDERS":           3,
+		"FIND_NODE":               4,
+		"PING":                    5,
+		"GET_ENCRYPTED_PROVIDERS": 6,
+		"GET_PROVIDERS_BATCH":     7,
 	}
 )
 
@@ -147,17 +153,36 @@ type Message struct {
 	// in case we want to implement coral's cluster rings in the future.
 	ClusterLevelRaw int32 `protobuf:"varint,10,opt,name=clusterLevelRaw,proto3" json:"clusterLevelRaw,omitempty"`
 	// Used to specify the key associated with this message.
-	// PUT_VALUE, GET_VALUE, ADD_PROVIDER, GET_PROVIDERS
+	// PUT_VALUE, GET_VALUE, ADD_PROVIDER, GET_PROVIDERS, GET_ENCRYPTED_PROVIDERS
 	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
 	// Used to return a value
 	// PUT_VALUE, GET_VALUE
 	Record *pb.Record `protobuf:"bytes,3,opt,name=record,proto3" json:"record,omitempty"`
 	// Used to return peers closer to a key in a query
-	// GET_VALUE, GET_PROVIDERS, FIND_NODE
+	// GET_VALUE, GET_PROVIDERS, FIND_NODE, GET_ENCRYPTED_PROVIDERS
 	CloserPeers []*Message_Peer `protobuf:"bytes,8,rep,name=closerPeers,proto3" json:"closerPeers,omitempty"`
 	// Used to return Providers
 	// GET_VALUE, ADD_PROVIDER, GET_PROVIDERS
 	ProviderPeers []*Message_Peer `protobuf:"bytes,9,rep,name=providerPeers,proto3" json:"providerPeers,omitempty"`
+	// Used to carry signed provider record envelopes, each sealing a
+	// ProviderRecord with the key of the provider.
+	// ADD_PROVIDER, GET_PROVIDERS
+	SignedProviderRecords [][]byte `protobuf:"bytes,11,rep,name=signedProviderRecords,proto3" json:"signedProviderRecords,omitempty"`
+	// Used to carry provider records encrypted with a key derived from the
+	// provided multihash, stored under the double-hashed multihash.
+	// ADD_PROVIDER, GET_ENCRYPTED_PROVIDERS
+	EncryptedProviderRecords []*EncryptedProviderRecord `protobuf:"bytes,12,rep,name=encryptedProviderRecords,proto3" json:"encryptedProviderRecords,omitempty"`
+	// Used to look up the providers of every key whose Kademlia ID starts with
+	// the first keyPrefixBits bits of key, rather than the providers of key.
+	// GET_PROVIDERS
+	KeyPrefixBits uint32 `protobuf:"varint,13,opt,name=keyPrefixBits,proto3" json:"keyPrefixBits,omitempty"`
+	// Used to return the providers of the keys matching a key prefix, or of
+	// the keys of a batch.
+	// GET_PROVIDERS, GET_PROVIDERS_BATCH
+	KeyProviders []*KeyProviders `protobuf:"bytes,14,rep,name=keyProviders,proto3" json:"keyProviders,omitempty"`
+	// Used to specify the keys to look up providers for in a single request.
+	// GET_PROVIDERS_BATCH
+	Keys          [][]byte `protobuf:"bytes,15,rep,name=keys,proto3" json:"keys,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -234,6 +259,245 @@ func (x *Message) GetProviderPeers() []*Message_Peer {
 	return nil
 }
 
+func (x *Message) GetSignedProviderRecords() [][]byte {
+	if x != nil {
+		return x.SignedProviderRecords
+	}
+	return nil
+}
+
+func (x *Message) GetEncryptedProviderRecords() []*EncryptedProviderRecord {
+	if x != nil {
+		return x.EncryptedProviderRecords
+	}
+	return nil
+}
+
+func (x *Message) GetKeyPrefixBits() uint32 {
+	if x != nil {
+		return x.KeyPrefixBits
+	}
+	return 0
+}
+
+func (x *Message) GetKeyProviders() []*KeyProviders {
+	if x != nil {
+		return x.KeyProviders
+	}
+	return nil
+}
+
+func (x *Message) GetKeys() [][]byte {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+// KeyProviders lists the providers of a key.
+type KeyProviders struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// multihash of the provided key.
+	Key       []byte          `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Providers []*Message_Peer `protobuf:"bytes,2,rep,name=providers,proto3" json:"providers,omitempty"`
+	// signed provider record envelopes of the providers, see
+	// Message.signedProviderRecords.
+	SignedProviderRecords [][]byte `protobuf:"bytes,3,rep,name=signedProviderRecords,proto3" json:"signedProviderRecords,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *KeyProviders) Reset() {
+	*x = KeyProviders{}
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyProviders) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyProviders) ProtoMessage() {}
+
+func (x *KeyProviders) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyProviders.ProtoReflect.Descriptor instead.
+func (*KeyProviders) Descriptor() ([]byte, []int) {
+	return file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *KeyProviders) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *KeyProviders) GetProviders() []*Message_Peer {
+	if x != nil {
+		return x.Providers
+	}
+	return nil
+}
+
+func (x *KeyProviders) GetSignedProviderRecords() [][]byte {
+	if x != nil {
+		return x.SignedProviderRecords
+	}
+	return nil
+}
+
+// ProviderRecord is the payload of a signed provider record envelope. It
+// binds the addresses of a provider to a key it provides.
+type ProviderRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ID of the provider.
+	PeerId []byte `protobuf:"bytes,1,opt,name=peerId,proto3" json:"peerId,omitempty"`
+	// multihash of the provided key.
+	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// multiaddrs of the provider.
+	Addrs [][]byte `protobuf:"bytes,3,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	// time at which the record was signed, in nanoseconds since the unix epoch.
+	Timestamp     int64 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProviderRecord) Reset() {
+	*x = ProviderRecord{}
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProviderRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProviderRecord) ProtoMessage() {}
+
+func (x *ProviderRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProviderRecord.ProtoReflect.Descriptor instead.
+func (*ProviderRecord) Descriptor() ([]byte, []int) {
+	return file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProviderRecord) GetPeerId() []byte {
+	if x != nil {
+		return x.PeerId
+	}
+	return nil
+}
+
+func (x *ProviderRecord) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *ProviderRecord) GetAddrs() [][]byte {
+	if x != nil {
+		return x.Addrs
+	}
+	return nil
+}
+
+func (x *ProviderRecord) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// EncryptedProviderRecord is a provider record that can only be read by peers
+// knowing the provided multihash.
+type EncryptedProviderRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ID of the peer that published the record, it is authenticated as
+	// additional data of the ciphertext.
+	Publisher []byte `protobuf:"bytes,1,opt,name=publisher,proto3" json:"publisher,omitempty"`
+	// AES-GCM nonce.
+	Nonce []byte `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// AES-GCM encrypted Message.Peer of the provider.
+	Ciphertext    []byte `protobuf:"bytes,3,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EncryptedProviderRecord) Reset() {
+	*x = EncryptedProviderRecord{}
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncryptedProviderRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptedProviderRecord) ProtoMessage() {}
+
+func (x *EncryptedProviderRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptedProviderRecord.ProtoReflect.Descriptor instead.
+func (*EncryptedProviderRecord) Descriptor() ([]byte, []int) {
+	return file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EncryptedProviderRecord) GetPublisher() []byte {
+	if x != nil {
+		return x.Publisher
+	}
+	return nil
+}
+
+func (x *EncryptedProviderRecord) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EncryptedProviderRecord) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
 type Message_Peer struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// ID of a given peer.
@@ -248,7 +512,7 @@ type Message_Peer struct {
 
 func (x *Message_Peer) Reset() {
 	*x = Message_Peer{}
-	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[1]
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -260,7 +524,7 @@ func (x *Message_Peer) String() string {
 func (*Message_Peer) ProtoMessage() {}
 
 func (x *Message_Peer) ProtoReflect() protoreflect.Message {
-	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[1]
+	mi := &file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -306,8 +570,8 @@ var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDesc = []byte{
 	0x74, 0x6f, 0x12, 0x06, 0x64, 0x68, 0x74, 0x2e, 0x70, 0x62, 0x1a, 0x32, 0x67, 0x69, 0x74, 0x68,
 	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2f, 0x67, 0x6f,
 	0x2d, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2d, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2f, 0x70,
-	0x62, 0x2f, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xc7,
-	0x04, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x74, 0x79,
+	0x62, 0x2f, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x85,
+	0x07, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x74, 0x79,
 	0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70,
 	0x62, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
 	0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x63,
@@ -324,30 +588,72 @@ var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDesc = []byte{
 	0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x50, 0x65, 0x65, 0x72, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28,
 	0x0b, 0x32, 0x14, 0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61,
 	0x67, 0x65, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x52, 0x0d, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65,
-	0x72, 0x50, 0x65, 0x65, 0x72, 0x73, 0x1a, 0x6c, 0x0a, 0x04, 0x50, 0x65, 0x65, 0x72, 0x12, 0x0e,
-	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14,
-	0x0a, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x05, 0x61,
-	0x64, 0x64, 0x72, 0x73, 0x12, 0x3e, 0x0a, 0x0a, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69,
-	0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1e, 0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70,
-	0x62, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
-	0x74, 0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x0a, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
-	0x74, 0x69, 0x6f, 0x6e, 0x22, 0x69, 0x0a, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54,
-	0x79, 0x70, 0x65, 0x12, 0x0d, 0x0a, 0x09, 0x50, 0x55, 0x54, 0x5f, 0x56, 0x41, 0x4c, 0x55, 0x45,
-	0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x47, 0x45, 0x54, 0x5f, 0x56, 0x41, 0x4c, 0x55, 0x45, 0x10,
-	0x01, 0x12, 0x10, 0x0a, 0x0c, 0x41, 0x44, 0x44, 0x5f, 0x50, 0x52, 0x4f, 0x56, 0x49, 0x44, 0x45,
-	0x52, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x47, 0x45, 0x54, 0x5f, 0x50, 0x52, 0x4f, 0x56, 0x49,
-	0x44, 0x45, 0x52, 0x53, 0x10, 0x03, 0x12, 0x0d, 0x0a, 0x09, 0x46, 0x49, 0x4e, 0x44, 0x5f, 0x4e,
-	0x4f, 0x44, 0x45, 0x10, 0x04, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x49, 0x4e, 0x47, 0x10, 0x05, 0x22,
-	0x57, 0x0a, 0x0e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70,
-	0x65, 0x12, 0x11, 0x0a, 0x0d, 0x4e, 0x4f, 0x54, 0x5f, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54,
-	0x45, 0x44, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x45,
-	0x44, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x43, 0x41, 0x4e, 0x5f, 0x43, 0x4f, 0x4e, 0x4e, 0x45,
-	0x43, 0x54, 0x10, 0x02, 0x12, 0x12, 0x0a, 0x0e, 0x43, 0x41, 0x4e, 0x4e, 0x4f, 0x54, 0x5f, 0x43,
-	0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x10, 0x03, 0x42, 0x2f, 0x5a, 0x2d, 0x67, 0x69, 0x74, 0x68,
-	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2f, 0x67, 0x6f,
-	0x2d, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2d, 0x6b, 0x61, 0x64, 0x2d, 0x64, 0x68, 0x74, 0x2f,
-	0x70, 0x62, 0x3b, 0x64, 0x68, 0x74, 0x5f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x33,
+	0x72, 0x50, 0x65, 0x65, 0x72, 0x73, 0x12, 0x34, 0x0a, 0x15, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64,
+	0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x18,
+	0x0b, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x15, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x50, 0x72, 0x6f,
+	0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x12, 0x5b, 0x0a, 0x18,
+	0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65,
+	0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f,
+	0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70, 0x62, 0x2e, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x65,
+	0x64, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52,
+	0x18, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64,
+	0x65, 0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x12, 0x24, 0x0a, 0x0d, 0x6b, 0x65, 0x79,
+	0x50, 0x72, 0x65, 0x66, 0x69, 0x78, 0x42, 0x69, 0x74, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0d, 0x6b, 0x65, 0x79, 0x50, 0x72, 0x65, 0x66, 0x69, 0x78, 0x42, 0x69, 0x74, 0x73, 0x12,
+	0x38, 0x0a, 0x0c, 0x6b, 0x65, 0x79, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x18,
+	0x0e, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70, 0x62, 0x2e, 0x4b,
+	0x65, 0x79, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x52, 0x0c, 0x6b, 0x65, 0x79,
+	0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x65, 0x79,
+	0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x1a, 0x6c, 0x0a,
+	0x04, 0x50, 0x65, 0x65, 0x72, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x0c, 0x52, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x12, 0x3e, 0x0a, 0x0a, 0x63,
+	0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x1e, 0x2e, 0x64, 0x68, 0x74, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70, 0x65, 0x52,
+	0x0a, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x9f, 0x01, 0x0a, 0x0b,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0d, 0x0a, 0x09, 0x50,
+	0x55, 0x54, 0x5f, 0x56, 0x41, 0x4c, 0x55, 0x45, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x47, 0x45,
+	0x54, 0x5f, 0x56, 0x41, 0x4c, 0x55, 0x45, 0x10, 0x01, 0x12, 0x10, 0x0a, 0x0c, 0x41, 0x44, 0x44,
+	0x5f, 0x50, 0x52, 0x4f, 0x56, 0x49, 0x44, 0x45, 0x52, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x47,
+	0x45, 0x54, 0x5f, 0x50, 0x52, 0x4f, 0x56, 0x49, 0x44, 0x45, 0x52, 0x53, 0x10, 0x03, 0x12, 0x0d,
+	0x0a, 0x09, 0x46, 0x49, 0x4e, 0x44, 0x5f, 0x4e, 0x4f, 0x44, 0x45, 0x10, 0x04, 0x12, 0x08, 0x0a,
+	0x04, 0x50, 0x49, 0x4e, 0x47, 0x10, 0x05, 0x12, 0x1b, 0x0a, 0x17, 0x47, 0x45, 0x54, 0x5f, 0x45,
+	0x4e, 0x43, 0x52, 0x59, 0x50, 0x54, 0x45, 0x44, 0x5f, 0x50, 0x52, 0x4f, 0x56, 0x49, 0x44, 0x45,
+	0x52, 0x53, 0x10, 0x06, 0x12, 0x17, 0x0a, 0x13, 0x47, 0x45, 0x54, 0x5f, 0x50, 0x52, 0x4f, 0x56,
+	0x49, 0x44, 0x45, 0x52, 0x53, 0x5f, 0x42, 0x41, 0x54, 0x43, 0x48, 0x10, 0x07, 0x22, 0x57, 0x0a,
+	0x0e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70, 0x65, 0x12,
+	0x11, 0x0a, 0x0d, 0x4e, 0x4f, 0x54, 0x5f, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x45, 0x44,
+	0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x45, 0x44, 0x10,
+	0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x43, 0x41, 0x4e, 0x5f, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54,
+	0x10, 0x02, 0x12, 0x12, 0x0a, 0x0e, 0x43, 0x41, 0x4e, 0x4e, 0x4f, 0x54, 0x5f, 0x43, 0x4f, 0x4e,
+	0x4e, 0x45, 0x43, 0x54, 0x10, 0x03, 0x22, 0x8a, 0x01, 0x0a, 0x0c, 0x4b, 0x65, 0x79, 0x50, 0x72,
+	0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x32, 0x0a, 0x09, 0x70, 0x72, 0x6f,
+	0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x64,
+	0x68, 0x74, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x50, 0x65,
+	0x65, 0x72, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x12, 0x34, 0x0a,
+	0x15, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52,
+	0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x15, 0x73, 0x69,
+	0x67, 0x6e, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x73, 0x22, 0x6e, 0x0a, 0x0e, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52,
+	0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x05,
+	0x61, 0x64, 0x64, 0x72, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x22, 0x6d, 0x0a, 0x17, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x65, 0x64,
+	0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x1c,
+	0x0a, 0x09, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x09, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x65, 0x72, 0x12, 0x14, 0x0a, 0x05,
+	0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e,
+	0x63, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x69, 0x70, 0x68, 0x65, 0x72, 0x74, 0x65, 0x78, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x63, 0x69, 0x70, 0x68, 0x65, 0x72, 0x74, 0x65,
+	0x78, 0x74, 0x42, 0x2f, 0x5a, 0x2d, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2f, 0x67, 0x6f, 0x2d, 0x6c, 0x69, 0x62, 0x70, 0x32,
+	0x70, 0x2d, 0x6b, 0x61, 0x64, 0x2d, 0x64, 0x68, 0x74, 0x2f, 0x70, 0x62, 0x3b, 0x64, 0x68, 0x74,
+	0x5f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -363,25 +669,31 @@ func file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDescGZIP() []byte
 }
 
 var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_goTypes = []any{
-	(Message_MessageType)(0),    // 0: dht.pb.Message.MessageType
-	(Message_ConnectionType)(0), // 1: dht.pb.Message.ConnectionType
-	(*Message)(nil),             // 2: dht.pb.Message
-	(*Message_Peer)(nil),        // 3: dht.pb.Message.Peer
-	(*pb.Record)(nil),           // 4: record.pb.Record
+	(Message_MessageType)(0),        // 0: dht.pb.Message.MessageType
+	(Message_ConnectionType)(0),     // 1: dht.pb.Message.ConnectionType
+	(*Message)(nil),                 // 2: dht.pb.Message
+	(*KeyProviders)(nil),            // 3: dht.pb.KeyProviders
+	(*ProviderRecord)(nil),          // 4: dht.pb.ProviderRecord
+	(*EncryptedProviderRecord)(nil), // 5: dht.pb.EncryptedProviderRecord
+	(*Message_Peer)(nil),            // 6: dht.pb.Message.Peer
+	(*pb.Record)(nil),               // 7: record.pb.Record
 }
 var file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_depIdxs = []int32{
 	0, // 0: dht.pb.Message.type:type_name -> dht.pb.Message.MessageType
-	4, // 1: dht.pb.Message.record:type_name -> record.pb.Record
-	3, // 2: dht.pb.Message.closerPeers:type_name -> dht.pb.Message.Peer
-	3, // 3: dht.pb.Message.providerPeers:type_name -> dht.pb.Message.Peer
-	1, // 4: dht.pb.Message.Peer.connection:type_name -> dht.pb.Message.ConnectionType
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	7, // 1: dht.pb.Message.record:type_name -> record.pb.Record
+	6, // 2: dht.pb.Message.closerPeers:type_name -> dht.pb.Message.Peer
+	6, // 3: dht.pb.Message.providerPeers:type_name -> dht.pb.Message.Peer
+	5, // 4: dht.pb.Message.encryptedProviderRecords:type_name -> dht.pb.EncryptedProviderRecord
+	3, // 5: dht.pb.Message.keyProviders:type_name -> dht.pb.KeyProviders
+	6, // 6: dht.pb.KeyProviders.providers:type_name -> dht.pb.Message.Peer
+	1, // 7: dht.pb.Message.Peer.connection:type_name -> dht.pb.Message.ConnectionType
+	8, // [8:8] is the sub-list for method output_type
+	8, // [8:8] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_init() }
@@ -395,7 +707,7 @@ func file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_github_com_libp2p_go_libp2p_kad_dht_pb_dht_proto_rawDesc,
 			NumEnums:      2,
-			NumMessages:   2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
@@ -14,6 +15,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
+	"github.com/libp2p/go-libp2p-kad-dht/amino"
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
 )
 
@@ -53,6 +55,16 @@ type MessageSenderWithDisconnect interface {
 	OnDisconnect(context.Context, peer.ID)
 }
 
+// MessageSenderWithLatency is a MessageSender estimating how fast each peer
+// answers its requests.
+type MessageSenderWithLatency interface {
+	MessageSender
+
+	// ExpectedLatency returns the round trip time within which p is expected
+	// to answer a request, false if its latency is unknown.
+	ExpectedLatency(p peer.ID) (time.Duration, bool)
+}
+
 // MessageSender handles sending wire protocol messages to a given peer
 type MessageSender interface {
 	// SendRequest sends a peer a message and waits for its response
@@ -179,6 +191,12 @@ func (pm *ProtocolMessenger) PutProvider(ctx context.Context, p peer.ID, key mul
 
 // PutProviderAddrs asks a peer to store that we are a provider for the given key.
 func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, key multihash.Multihash, self peer.AddrInfo) (err error) {
+	return pm.PutSignedProviderAddrs(ctx, p, key, self, nil)
+}
+
+// PutSignedProviderAddrs asks a peer to store that we are a provider for the given key, attaching the serialized
+// envelope of our signed provider record for the key. If the envelope is nil, this is equivalent to PutProviderAddrs.
+func (pm *ProtocolMessenger) PutSignedProviderAddrs(ctx context.Context, p peer.ID, key multihash.Multihash, self peer.AddrInfo, envelope []byte) (err error) {
 	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.PutProvider")
 	defer span.End()
 	if span.IsRecording() {
@@ -198,6 +216,9 @@ func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, ke
 
 	pmes := NewMessage(Message_ADD_PROVIDER, key, 0)
 	pmes.ProviderPeers = RawPeerInfosToPBPeers([]peer.AddrInfo{self})
+	if envelope != nil {
+		pmes.SignedProviderRecords = [][]byte{envelope}
+	}
 
 	return pm.m.SendMessage(ctx, p, pmes)
 }
@@ -205,6 +226,13 @@ func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, ke
 // GetProviders asks a peer for the providers it knows of for a given key. Also returns the K closest peers to the key
 // as described in GetClosestPeers.
 func (pm *ProtocolMessenger) GetProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (provs []*peer.AddrInfo, closerPeers []*peer.AddrInfo, err error) {
+	provs, closerPeers, _, err = pm.GetSignedProviders(ctx, p, key)
+	return provs, closerPeers, err
+}
+
+// GetSignedProviders is like GetProviders, but also returns the serialized signed provider record envelopes sent by
+// the peer. The envelopes are returned as is and must be verified by the caller.
+func (pm *ProtocolMessenger) GetSignedProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (provs []*peer.AddrInfo, closerPeers []*peer.AddrInfo, envelopes [][]byte, err error) {
 	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetProviders")
 	defer span.End()
 	if span.IsRecording() {
@@ -229,11 +257,124 @@ func (pm *ProtocolMessenger) GetProviders(ctx context.Context, p peer.ID, key mu
 	pmes := NewMessage(Message_GET_PROVIDERS, key, 0)
 	respMsg, err := pm.m.SendRequest(ctx, p, pmes)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	provs = PBPeersToPeerInfos(respMsg.GetProviderPeers())
 	closerPeers = PBPeersToPeerInfos(respMsg.GetCloserPeers())
-	return provs, closerPeers, nil
+	return provs, closerPeers, respMsg.GetSignedProviderRecords(), nil
+}
+
+// PutEncryptedProvider asks a peer to store an encrypted provider record under the double-hashed key.
+func (pm *ProtocolMessenger) PutEncryptedProvider(ctx context.Context, p peer.ID, key multihash.Multihash, rec *EncryptedProviderRecord) (err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.PutEncryptedProvider")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Stringer("key", key))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}()
+	}
+
+	pmes := NewMessage(Message_ADD_PROVIDER, key, 0)
+	pmes.EncryptedProviderRecords = []*EncryptedProviderRecord{rec}
+	return pm.m.SendMessage(ctx, p, pmes)
+}
+
+// GetEncryptedProviders asks a peer for the encrypted provider records it knows of for a double-hashed key. Also
+// returns the K closest peers to the key as described in GetClosestPeers. The records are returned as is and must be
+// decrypted by the caller.
+func (pm *ProtocolMessenger) GetEncryptedProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (records []*EncryptedProviderRecord, closerPeers []*peer.AddrInfo, err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetEncryptedProviders")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Stringer("key", key))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			} else {
+				span.SetAttributes(attribute.Int("records", len(records)), attribute.Int("closestPeers", len(closerPeers)))
+			}
+		}()
+	}
+
+	pmes := NewMessage(Message_GET_ENCRYPTED_PROVIDERS, key, 0)
+	respMsg, err := pm.m.SendRequest(ctx, p, pmes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return respMsg.GetEncryptedProviderRecords(), PBPeersToPeerInfos(respMsg.GetCloserPeers()), nil
+}
+
+// ErrPrefixLookupsNotSupported is returned by GetProvidersByPrefix when the peer answered the request as a regular
+// GET_PROVIDERS for the prefix itself. The closer peers it returned are still valid peers, though closest to the prefix
+// as a key.
+var ErrPrefixLookupsNotSupported = errors.New("peer doesn't support provider lookups by prefix")
+
+// GetProvidersByPrefix asks a peer for the providers of every key whose Kademlia ID starts with the first bits bits of
+// prefix. Also returns the K closest peers to the prefix as described in GetClosestPeers. The peer may only return a
+// subset of the matching provider records. Peers supporting prefix lookups echo the prefix length in their response,
+// ErrPrefixLookupsNotSupported is returned along with the closer peers if the peer didn't.
+func (pm *ProtocolMessenger) GetProvidersByPrefix(ctx context.Context, p peer.ID, prefix []byte, bits int) (keyProviders []*KeyProviders, closerPeers []*peer.AddrInfo, err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetProvidersByPrefix")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Int("bits", bits))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			} else {
+				span.SetAttributes(attribute.Int("keys", len(keyProviders)), attribute.Int("closestPeers", len(closerPeers)))
+			}
+		}()
+	}
+
+	pmes := NewMessage(Message_GET_PROVIDERS, prefix, 0)
+	pmes.KeyPrefixBits = uint32(bits)
+	respMsg, err := pm.m.SendRequest(ctx, p, pmes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if respMsg.GetKeyPrefixBits() != pmes.KeyPrefixBits {
+		return nil, PBPeersToPeerInfos(respMsg.GetCloserPeers()), ErrPrefixLookupsNotSupported
+	}
+	return respMsg.GetKeyProviders(), PBPeersToPeerInfos(respMsg.GetCloserPeers()), nil
+}
+
+// GetProvidersBatch asks a peer for the providers it knows of for each of the given keys, in as few requests as
+// possible. Only the keys the peer knows providers of are returned, along with their signed provider record envelopes,
+// and no closer peers are returned.
+func (pm *ProtocolMessenger) GetProvidersBatch(ctx context.Context, p peer.ID, keys []multihash.Multihash) (keyProviders []*KeyProviders, err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetProvidersBatch")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Int("keys", len(keys)))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			} else {
+				span.SetAttributes(attribute.Int("providedKeys", len(keyProviders)))
+			}
+		}()
+	}
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), amino.MaxProvidersBatchSize)]
+		keys = keys[len(batch):]
+
+		pmes := NewMessage(Message_GET_PROVIDERS_BATCH, nil, 0)
+		pmes.Keys = make([][]byte, len(batch))
+		for i, k := range batch {
+			pmes.Keys[i] = k
+		}
+		respMsg, err := pm.m.SendRequest(ctx, p, pmes)
+		if err != nil {
+			return nil, err
+		}
+		keyProviders = append(keyProviders, respMsg.GetKeyProviders()...)
+	}
+	return keyProviders, nil
 }
 
 // Ping sends a ping message to the passed peer and waits for a response.
@@ -0,0 +1,95 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// prefixKadID returns the Kademlia ID starting with prefix, padded with zeros.
+// Lookups by prefix walk towards it.
+func prefixKadID(prefix []byte) kb.ID {
+	id := make([]byte, sha256.Size)
+	copy(id, prefix)
+	return id
+}
+
+// providersLookupTarget returns the Kademlia ID provider lookups for key walk
+// towards, which only depends on the key prefix sent for prefix lookups.
+func (dht *IpfsDHT) providersLookupTarget(key multihash.Multihash) kb.ID {
+	if dht.prefixLookupBits > 0 {
+		return prefixKadID(providers.KadIDPrefix(key, dht.prefixLookupBits))
+	}
+	return kb.ConvertKey(string(key))
+}
+
+// getProvidersByPrefix returns the provider records stored locally whose key
+// matches the first bits bits of prefix, up to maxPrefixLookupResults, if the
+// provider store supports it.
+func (dht *IpfsDHT) getProvidersByPrefix(ctx context.Context, prefix []byte, bits int) ([]providers.ProviderEntry, error) {
+	if ps, ok := dht.providerStore.(providers.PrefixProviderStore); ok {
+		return ps.GetProvidersByPrefix(ctx, prefix, bits, dht.maxPrefixLookupResults)
+	}
+	return nil, nil
+}
+
+func (dht *IpfsDHT) handleGetProvidersByPrefix(ctx context.Context, p peer.ID, pmes *pb.Message) (_ *pb.Message, _err error) {
+	prefix, bits := pmes.GetKey(), int(pmes.GetKeyPrefixBits())
+	if bits > 8*len(prefix) || bits > 8*sha256.Size {
+		return nil, errors.New("handleGetProvidersByPrefix prefix length out of range")
+	}
+
+	resp := pb.NewMessage(pmes.GetType(), prefix, pmes.GetClusterLevel())
+	resp.KeyPrefixBits = pmes.GetKeyPrefixBits()
+
+	entries, err := dht.getProvidersByPrefix(ctx, prefix, bits)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*pb.KeyProviders)
+	for _, e := range entries {
+		kp, ok := byKey[string(e.Key)]
+		if !ok {
+			kp = &pb.KeyProviders{Key: e.Key}
+			byKey[string(e.Key)] = kp
+			resp.KeyProviders = append(resp.KeyProviders, kp)
+		}
+		info := peer.AddrInfo{
+			ID:    e.Provider,
+			Addrs: dht.filterAddrs(dht.peerstore.Addrs(e.Provider)),
+		}
+		kp.Providers = append(kp.Providers, pb.PeerInfosToPBPeers(dht.host.Network(), []peer.AddrInfo{info})...)
+	}
+
+	if infos := dht.closerPeerInfos(pmes, p, dht.bucketSize); len(infos) > 0 {
+		resp.CloserPeers = pb.PeerInfosToPBPeers(dht.host.Network(), infos)
+	}
+
+	return resp, nil
+}
+
+// getRemoteProvidersByPrefix asks p for the providers of key by prefix, and
+// only returns the providers of key along with the closer peers. The closer
+// peers are also returned with pb.ErrPrefixLookupsNotSupported.
+func (dht *IpfsDHT) getRemoteProvidersByPrefix(ctx context.Context, p peer.ID, key multihash.Multihash) ([]*peer.AddrInfo, []*peer.AddrInfo, error) {
+	prefix := providers.KadIDPrefix(key, dht.prefixLookupBits)
+	keyProvs, closest, err := dht.protoMessenger.GetProvidersByPrefix(ctx, p, prefix, dht.prefixLookupBits)
+	if err != nil {
+		return nil, closest, err
+	}
+	var provs []*peer.AddrInfo
+	for _, kp := range keyProvs {
+		if bytes.Equal(kp.GetKey(), key) {
+			provs = append(provs, pb.PBPeersToPeerInfos(kp.GetProviders())...)
+		}
+	}
+	return provs, closest, nil
+}
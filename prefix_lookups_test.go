@@ -0,0 +1,135 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+func TestPrefixLookups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := testCaseCids[0]
+	var leaked atomic.Bool
+	detectLeak := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() == pb.Message_GET_PROVIDERS && bytes.Equal(req.GetKey(), key.Hash()) {
+			leaked.Store(true)
+		}
+	})
+
+	servers := setupDHTS(t, ctx, 3, detectLeak, ServePrefixLookups())
+	provider := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false, PrefixLookups(4))
+
+	// servers[0] knows every peer, so that lookups always reach all of them
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[0], servers[2])
+	connect(t, ctx, servers[1], servers[2])
+	for _, d := range []*IpfsDHT{provider, client} {
+		connect(t, ctx, d, servers[0])
+	}
+
+	// other keys sharing the prefix, provided by the servers themselves
+	var others []cid.Cid
+	for _, c := range testCaseCids[1:] {
+		if bytes.Equal(providers.KadIDPrefix(c.Hash(), 4), providers.KadIDPrefix(key.Hash(), 4)) {
+			others = append(others, c)
+		}
+	}
+	require.GreaterOrEqual(t, len(others), 3)
+	for _, s := range servers {
+		require.NoError(t, s.Provide(ctx, others[0], false))
+	}
+
+	require.NoError(t, provider.Provide(ctx, key, true))
+	waitForProviders(t, servers, key.Hash(), storesProviders(1))
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	provs, err := client.FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+	require.NotEmpty(t, provs[0].Addrs)
+	require.False(t, leaked.Load())
+
+	// servers return the records of every matching key, up to their cap
+	capped := setupDHT(ctx, t, false, ServePrefixLookups(), MaxPrefixLookupResults(2))
+	for _, c := range others[:3] {
+		require.NoError(t, capped.Provide(ctx, c, false))
+	}
+	connect(t, ctx, client, capped)
+	keyProvs, _, err := client.protoMessenger.GetProvidersByPrefix(ctxT, capped.self, providers.KadIDPrefix(key.Hash(), 4), 4)
+	require.NoError(t, err)
+	n := 0
+	for _, kp := range keyProvs {
+		n += len(kp.GetProviders())
+	}
+	require.Equal(t, 2, n)
+}
+
+func TestPrefixLookupsLegacyPeers(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fallback=%t", fallback), func(t *testing.T) {
+			testPrefixLookupsLegacyPeers(t, fallback)
+		})
+	}
+}
+
+func testPrefixLookupsLegacyPeers(t *testing.T, fallback bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := testCaseCids[0]
+	var leaked atomic.Bool
+	// servers that don't serve prefix lookups answer a regular GET_PROVIDERS
+	legacy := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() == pb.Message_GET_PROVIDERS && bytes.Equal(req.GetKey(), key.Hash()) {
+			leaked.Store(true)
+		}
+	})
+	servers := setupDHTS(t, ctx, 2, legacy)
+	provider := setupDHT(ctx, t, true)
+	opts := []Option{PrefixLookups(4)}
+	if fallback {
+		opts = append(opts, PrefixLookupsFullKeyFallback())
+	}
+	client := setupDHT(ctx, t, true, opts...)
+	connect(t, ctx, servers[0], servers[1])
+	for _, d := range []*IpfsDHT{provider, client} {
+		connectNoSync(t, ctx, d, servers[0])
+		wait(t, ctx, d, servers[0])
+	}
+
+	require.NoError(t, provider.Provide(ctx, key, true))
+	waitForProviders(t, servers, key.Hash(), storesProviders(1))
+	leaked.Store(false)
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	_, closer, err := client.protoMessenger.GetProvidersByPrefix(ctxT, servers[0].self, providers.KadIDPrefix(key.Hash(), 4), 4)
+	require.ErrorIs(t, err, pb.ErrPrefixLookupsNotSupported)
+	require.NotEmpty(t, closer)
+
+	provs, err := client.FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Equal(t, fallback, leaked.Load())
+	if !fallback {
+		// only the closer peers of the legacy servers are used
+		require.Empty(t, provs)
+		return
+	}
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+}
@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// addEncryptedProvider stores an encrypted provider record received from
+// publisher for the double-hashed key, if the provider store supports it.
+func (dht *IpfsDHT) addEncryptedProvider(ctx context.Context, key []byte, publisher peer.ID, rec *pb.EncryptedProviderRecord) error {
+	ps, ok := dht.providerStore.(providers.EncryptedProviderStore)
+	if !ok {
+		return nil
+	}
+	// the record is stored as published by the sender, so that it can only
+	// be decrypted if it names the sender as the provider.
+	return ps.AddEncryptedProvider(ctx, key, &pb.EncryptedProviderRecord{
+		Publisher:  []byte(publisher),
+		Nonce:      rec.GetNonce(),
+		Ciphertext: rec.GetCiphertext(),
+	})
+}
+
+// getEncryptedProviders returns the encrypted provider records of the
+// double-hashed key stored locally, if the provider store supports it.
+func (dht *IpfsDHT) getEncryptedProviders(ctx context.Context, key []byte) ([]*pb.EncryptedProviderRecord, error) {
+	if ps, ok := dht.providerStore.(providers.EncryptedProviderStore); ok {
+		return ps.GetEncryptedProviders(ctx, key)
+	}
+	return nil, nil
+}
+
+// provideEncrypted announces an encrypted provider record for keyMH to the
+// closest peers to its double-hashed key.
+func (dht *IpfsDHT) provideEncrypted(ctx context.Context, keyMH multihash.Multihash) error {
+	self := peer.AddrInfo{
+		ID:    dht.self,
+		Addrs: dht.filterAddrs(dht.host.Addrs()),
+	}
+	rec, err := providers.EncryptProviderRecord(keyMH, dht.self, self)
+	if err != nil {
+		return err
+	}
+	dhKey := providers.DoubleHash(keyMH)
+	if err := dht.addEncryptedProvider(ctx, dhKey, dht.self, rec); err != nil {
+		return err
+	}
+
+	peers, err := dht.GetClosestPeers(ctx, string(dhKey))
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := dht.protoMessenger.PutEncryptedProvider(ctx, p, dhKey, rec); err != nil {
+				logger.Debugw("failed to put encrypted provider record", "peer", p, "error", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// findEncryptedProvidersAsyncRoutine looks for the providers of key by
+// double-hashed key, the peers queried only learn the double-hashed key.
+func (dht *IpfsDHT) findEncryptedProvidersAsyncRoutine(ctx context.Context, key multihash.Multihash, count int, peerOut chan ProviderResult) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.FindEncryptedProvidersAsyncRoutine")
+	defer span.End()
+
+	defer close(peerOut)
+
+	findAll := count == 0
+	dhKey := providers.DoubleHash(key)
+
+	var psLock sync.Mutex
+	ps := make(map[peer.ID]struct{})
+	// sendProviders decrypts the records returned by from and sends the new
+	// providers, it returns false once enough providers were found or ctx is
+	// done.
+	sendProviders := func(ctx context.Context, from peer.ID, rtt time.Duration, records []*pb.EncryptedProviderRecord) bool {
+		for _, rec := range records {
+			prov, err := providers.DecryptProviderRecord(key, rec)
+			if err != nil {
+				logger.Debugw("invalid encrypted provider record", "error", err)
+				continue
+			}
+
+			psLock.Lock()
+			_, seen := ps[prov.ID]
+			full := !findAll && len(ps) >= count
+			if !seen && !full {
+				ps[prov.ID] = struct{}{}
+			}
+			psLock.Unlock()
+			if full {
+				return false
+			}
+			if seen {
+				continue
+			}
+
+			dht.maybeAddAddrs(prov.ID, prov.Addrs, peerstore.TempAddrTTL)
+			res := dht.newProviderResult(ctx, prov, from, nil, rtt)
+			if len(prov.Addrs) > 0 {
+				// the addresses come from the record, even when stored locally
+				res.AddrSource = ProviderAddrsFromResponse
+			}
+			select {
+			case peerOut <- res:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		psLock.Lock()
+		defer psLock.Unlock()
+		return findAll || len(ps) < count
+	}
+
+	records, err := dht.getEncryptedProviders(ctx, dhKey)
+	if err != nil || !sendProviders(ctx, dht.self, 0, records) {
+		return
+	}
+
+	lookupRes, err := dht.runLookupWithFollowup(ctx, string(dhKey),
+		func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+				Type: routing.SendingQuery,
+				ID:   p,
+			})
+
+			start := time.Now()
+			records, closest, err := dht.protoMessenger.GetEncryptedProviders(ctx, p, dhKey)
+			if err != nil {
+				return nil, err
+			}
+			if !sendProviders(ctx, p, time.Since(start), records) {
+				return nil, ctx.Err()
+			}
+
+			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+				Type:      routing.PeerResponse,
+				ID:        p,
+				Responses: closest,
+			})
+			return closest, nil
+		},
+		func(*qpeerset.QueryPeerset) bool {
+			psLock.Lock()
+			defer psLock.Unlock()
+			return !findAll && len(ps) >= count
+		},
+	)
+
+	if err == nil && ctx.Err() == nil {
+		dht.refreshRTIfNoShortcut(kb.ConvertKey(string(dhKey)), lookupRes)
+	}
+}
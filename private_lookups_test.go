@@ -0,0 +1,92 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// storesEncryptedProviders checks that a server stores n encrypted provider
+// records of the double-hashed key.
+func storesEncryptedProviders(n int) providerCheck {
+	return func(s *IpfsDHT, key multihash.Multihash) bool {
+		recs, err := s.providerStore.(providers.EncryptedProviderStore).GetEncryptedProviders(context.Background(), key)
+		return err == nil && len(recs) == n
+	}
+}
+
+func TestPrivateLookups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := testCaseCids[0]
+	var leaked atomic.Bool
+	detectLeak := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() == pb.Message_GET_PROVIDERS && bytes.Equal(req.GetKey(), key.Hash()) {
+			leaked.Store(true)
+		}
+	})
+
+	servers := setupDHTS(t, ctx, 3, detectLeak)
+	encrypted := setupDHT(ctx, t, false, EnableEncryptedProviderRecords())
+	plain := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false, EnablePrivateLookups())
+
+	// servers[0] knows every peer, so that lookups always reach all of them
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[0], servers[2])
+	connect(t, ctx, servers[1], servers[2])
+	for _, d := range []*IpfsDHT{encrypted, plain, client} {
+		connect(t, ctx, d, servers[0])
+	}
+
+	require.NoError(t, encrypted.Provide(ctx, key, true))
+	require.NoError(t, plain.Provide(ctx, key, true))
+
+	waitForProviders(t, servers, providers.DoubleHash(key.Hash()), storesEncryptedProviders(1))
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	provs, err := client.FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, encrypted.self, provs[0].ID)
+	require.NotEmpty(t, provs[0].Addrs)
+	require.False(t, leaked.Load())
+
+	// regular lookups still find both providers
+	provs, err = servers[2].FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Len(t, provs, 2)
+}
+
+func TestEncryptedProvideMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 3)
+	provider := setupDHT(ctx, t, false, EnableEncryptedProviderRecords())
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[0], servers[2])
+	connect(t, ctx, servers[1], servers[2])
+	connect(t, ctx, provider, servers[0])
+
+	keys := make([]multihash.Multihash, 0, 5)
+	for _, c := range testCaseCids[:5] {
+		keys = append(keys, c.Hash())
+	}
+	require.NoError(t, provider.ProvideMany(ctx, keys))
+
+	for _, k := range keys {
+		waitForProviders(t, servers, providers.DoubleHash(k), storesEncryptedProviders(1))
+	}
+}
@@ -0,0 +1,315 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+const (
+	// provideManyLookupParallelism bounds the number of keyspace regions
+	// being looked up concurrently by ProvideMany.
+	provideManyLookupParallelism = 8
+	// provideManySendParallelism bounds the number of peers receiving
+	// ADD_PROVIDER batches concurrently during ProvideMany.
+	provideManySendParallelism = 32
+)
+
+// sweepKey is a key to provide along with its location in the keyspace.
+type sweepKey struct {
+	mh  multihash.Multihash
+	kid kb.ID
+	// if non nil, mh is a double-hashed key to announce this encrypted
+	// provider record for, see EnableEncryptedProviderRecords.
+	encrypted *pb.EncryptedProviderRecord
+}
+
+// sweepRegion is a set of keys, sorted by kademlia ID, sharing a common prefix
+// of cpl bits.
+type sweepRegion struct {
+	keys []sweepKey
+	cpl  int
+	// peers already known to be in the region
+	known []peer.ID
+}
+
+// sweeper walks the keyspace region by region, and announces the keys of a
+// region to the closest peers found by a single lookup.
+type sweeper struct {
+	dht  *IpfsDHT
+	self peer.AddrInfo
+
+	lookupSem chan struct{}
+	sendSem   chan struct{}
+	wg        sync.WaitGroup
+
+	lk     sync.Mutex
+	failed int
+}
+
+// ProvideMany announces to the network that we are providing all the given
+// keys.
+//
+// Instead of performing one lookup per key, keys are sorted by their location
+// in the keyspace and processed region by region. A single lookup is
+// performed per region, where regions are shrunk until they hold fewer than
+// bucket size peers, so the lookup result contains the closest peers of every
+// key in the region. ADD_PROVIDER messages are then batched per peer.
+//
+// If encrypted provider records are enabled, they are announced along with
+// the plaintext ones. Failing to announce them doesn't fail the provide.
+func (dht *IpfsDHT) ProvideMany(ctx context.Context, keys []multihash.Multihash) (err error) {
+	ctx, end := tracer.ProvideMany(dhtName, ctx, keys)
+	defer func() { end(err) }()
+	ctx = ContextWithOperation(ctx, OperationProvide)
+
+	if !dht.enableProviders {
+		return routing.ErrNotSupported
+	}
+
+	self := peer.AddrInfo{ID: dht.self, Addrs: dht.filterAddrs(dht.host.Addrs())}
+	if len(self.Addrs) < 1 {
+		return errors.New("no known addresses for self, cannot put provider")
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	sorted := make([]sweepKey, 0, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[string(k)]; ok {
+			continue
+		}
+		seen[string(k)] = struct{}{}
+
+		// add self locally
+		dht.providerStore.AddProvider(ctx, k, peer.AddrInfo{ID: dht.self})
+		if dht.reprovider != nil {
+			if err := dht.reprovider.track(ctx, k); err != nil {
+				logger.Warnw("failed to track key for reproviding", "mh", internal.LoggableProviderRecordBytes(k), "error", err)
+			}
+		}
+		sorted = append(sorted, sweepKey{mh: k, kid: kb.ConvertKey(string(k))})
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	provided := len(sorted)
+	if dht.encryptProviderRecords {
+		for _, k := range sorted[:provided] {
+			rec, err := providers.EncryptProviderRecord(k.mh, dht.self, self)
+			if err != nil {
+				logger.Warnw("failed to encrypt provider record", "mh", internal.LoggableProviderRecordBytes(k.mh), "error", err)
+				continue
+			}
+			dhKey := providers.DoubleHash(k.mh)
+			if err := dht.addEncryptedProvider(ctx, dhKey, dht.self, rec); err != nil {
+				logger.Warnw("failed to store encrypted provider record", "mh", internal.LoggableProviderRecordBytes(k.mh), "error", err)
+			}
+			sorted = append(sorted, sweepKey{mh: dhKey, kid: kb.ConvertKey(string(dhKey)), encrypted: rec})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].kid, sorted[j].kid) < 0 })
+
+	logger.Debugw("providing many", "keys", provided)
+
+	s := &sweeper{
+		dht:       dht,
+		self:      self,
+		lookupSem: make(chan struct{}, provideManyLookupParallelism),
+		sendSem:   make(chan struct{}, provideManySendParallelism),
+	}
+	s.spawn(ctx, sweepRegion{keys: sorted})
+	s.wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.failed > 0 {
+		return fmt.Errorf("failed to provide %d out of %d keys", s.failed, provided)
+	}
+	return nil
+}
+
+// fail records that the given keys couldn't be announced. Encrypted provider
+// records are only logged.
+func (s *sweeper) fail(keys []sweepKey) {
+	n := 0
+	for _, k := range keys {
+		if k.encrypted != nil {
+			logger.Debugw("failed to announce encrypted provider record", "key", internal.LoggableProviderRecordBytes(k.mh))
+			continue
+		}
+		n++
+	}
+	s.lk.Lock()
+	s.failed += n
+	s.lk.Unlock()
+}
+
+func (s *sweeper) spawn(ctx context.Context, r sweepRegion) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case s.lookupSem <- struct{}{}:
+		case <-ctx.Done():
+			s.fail(r.keys)
+			return
+		}
+		s.sweep(ctx, r)
+		<-s.lookupSem
+	}()
+}
+
+// sweep looks up the closest peers to the middle of the region. If some of
+// the returned peers are outside the region, every peer of the region is known
+// and the keys can be announced. Otherwise the region is split into
+// subregions small enough to hold fewer than bucket size peers, which are
+// swept in turn.
+func (s *sweeper) sweep(ctx context.Context, r sweepRegion) {
+	target := r.keys[len(r.keys)/2]
+	peers, err := s.dht.GetClosestPeers(ctx, string(target.mh))
+	if err != nil && len(peers) == 0 {
+		logger.Debugw("failed to look up keyspace region", "keys", len(r.keys), "cpl", r.cpl, "error", err)
+		s.fail(r.keys)
+		return
+	}
+
+	pool := make([]peer.ID, 0, len(r.known)+len(peers))
+	pool = append(pool, r.known...)
+	pool = append(pool, peers...)
+
+	covered := len(peers) < s.dht.bucketSize || len(r.keys) == 1
+	minCpl := 8 * len(target.kid)
+	for _, p := range peers {
+		cpl := kb.CommonPrefixLen(target.kid, kb.ConvertPeerID(p))
+		if cpl < r.cpl {
+			covered = true
+		}
+		minCpl = min(minCpl, cpl)
+	}
+	if covered {
+		s.send(ctx, r.keys, pool)
+		return
+	}
+
+	// All the peers found are in the region, so it holds more than bucket size
+	// peers. The subregion around the target that excludes the farthest of
+	// them holds fewer than bucket size peers, and all of them were found.
+	depth := minCpl + 1
+	for len(r.keys) > 0 {
+		n := sort.Search(len(r.keys), func(i int) bool {
+			return kb.CommonPrefixLen(r.keys[0].kid, r.keys[i].kid) < depth
+		})
+		group := r.keys[:n]
+		r.keys = r.keys[n:]
+
+		if kb.CommonPrefixLen(group[0].kid, target.kid) >= depth {
+			s.send(ctx, group, pool)
+			continue
+		}
+
+		var known []peer.ID
+		for _, p := range pool {
+			if kb.CommonPrefixLen(group[0].kid, kb.ConvertPeerID(p)) >= depth {
+				known = append(known, p)
+			}
+		}
+		s.spawn(ctx, sweepRegion{keys: group, cpl: depth, known: known})
+	}
+}
+
+// send announces every key to its closest peers among the given ones,
+// batching all the keys destined to the same peer.
+func (s *sweeper) send(ctx context.Context, keys []sweepKey, peers []peer.ID) {
+	peers = dedupPeers(peers)
+	keysPerPeer := make(map[peer.ID][]int)
+	for i, k := range keys {
+		closest := kb.SortClosestPeers(peers, k.kid)
+		if len(closest) > s.dht.bucketSize {
+			closest = closest[:s.dht.bucketSize]
+		}
+		for _, p := range closest {
+			keysPerPeer[p] = append(keysPerPeer[p], i)
+		}
+	}
+
+	envelopes := make([][]byte, len(keys))
+	for i, k := range keys {
+		if k.encrypted == nil {
+			envelopes[i] = s.dht.sealProviderRecord(k.mh, s.self)
+		}
+	}
+
+	var lk sync.Mutex
+	successes := make([]int, len(keys))
+
+	var wg sync.WaitGroup
+	for p, idxs := range keysPerPeer {
+		select {
+		case s.sendSem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			s.fail(keys)
+			return
+		}
+		wg.Add(1)
+		go func(p peer.ID, idxs []int) {
+			defer wg.Done()
+			defer func() { <-s.sendSem }()
+
+			for _, i := range idxs {
+				var err error
+				if keys[i].encrypted != nil {
+					err = s.dht.protoMessenger.PutEncryptedProvider(ctx, p, keys[i].mh, keys[i].encrypted)
+				} else {
+					err = s.dht.protoMessenger.PutSignedProviderAddrs(ctx, p, keys[i].mh, s.self, envelopes[i])
+				}
+				if err != nil {
+					// the peer is most likely unreachable, don't bother
+					// sending it the rest of the batch.
+					logger.Debugw("failed to put provider", "peer", p, "error", err)
+					return
+				}
+				lk.Lock()
+				successes[i]++
+				lk.Unlock()
+			}
+		}(p, idxs)
+	}
+	wg.Wait()
+
+	var failed []sweepKey
+	for i, n := range successes {
+		if n == 0 {
+			failed = append(failed, keys[i])
+		}
+	}
+	if len(failed) > 0 {
+		s.fail(failed)
+	}
+}
+
+func dedupPeers(peers []peer.ID) []peer.ID {
+	seen := make(map[peer.ID]struct{}, len(peers))
+	out := peers[:0:0]
+	for _, p := range peers {
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvideManySweep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const bucketSize = 4
+	dhts := setupDHTS(t, ctx, 20, BucketSize(bucketSize))
+	for i := range dhts {
+		connect(t, ctx, dhts[i], dhts[(i+1)%len(dhts)])
+	}
+	bootstrap(t, ctx, dhts)
+
+	keys := make([]multihash.Multihash, 0, 128)
+	for i := 0; i < cap(keys); i++ {
+		mh, err := multihash.Sum([]byte{byte(i)}, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		keys = append(keys, mh)
+	}
+
+	provider := dhts[0]
+	require.NoError(t, provider.ProvideMany(ctx, keys))
+
+	byID := make(map[peer.ID]*IpfsDHT, len(dhts))
+	ids := make([]peer.ID, 0, len(dhts)-1)
+	for _, d := range dhts[1:] {
+		byID[d.self] = d
+		ids = append(ids, d.self)
+	}
+
+	announced := func(k multihash.Multihash) bool {
+		for _, p := range kb.SortClosestPeers(ids, kb.ConvertKey(string(k)))[:bucketSize] {
+			provs, err := byID[p].providerStore.GetProviders(ctx, k)
+			require.NoError(t, err)
+			if len(provs) > 0 && provs[0].ID == provider.self {
+				return true
+			}
+		}
+		return false
+	}
+	for _, k := range keys {
+		// the key must have been announced to at least one of its actual
+		// closest peers, ADD_PROVIDER messages are sent without waiting for a
+		// response.
+		require.Eventually(t, func() bool { return announced(k) }, 5*time.Second, 10*time.Millisecond,
+			"key %s was not announced to its closest peers", k)
+	}
+
+	for i, k := range keys {
+		d := dhts[1+i%(len(dhts)-1)]
+		ctxT, cancel := context.WithTimeout(ctx, 5*time.Second)
+		prov, ok := <-d.FindProvidersAsync(ctxT, cid.NewCidV1(cid.Raw, k), 1)
+		cancel()
+		require.True(t, ok, "did not find provider for key %s", k)
+		require.Equal(t, provider.self, prov.ID)
+	}
+}
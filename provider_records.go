@@ -0,0 +1,104 @@
+package dht
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// sealProviderRecord returns the serialized envelope of our signed provider
+// record for key, or nil if signed provider records are disabled.
+func (dht *IpfsDHT) sealProviderRecord(key multihash.Multihash, self peer.AddrInfo) []byte {
+	if !dht.signProviderRecords {
+		return nil
+	}
+	sk := dht.peerstore.PrivKey(dht.self)
+	if sk == nil {
+		logger.Warn("no private key for self, cannot sign provider record")
+		return nil
+	}
+	envelope, err := providers.SealProviderRecord(key, self, sk)
+	if err != nil {
+		logger.Warnw("failed to sign provider record", "mh", internal.LoggableProviderRecordBytes(key), "error", err)
+		return nil
+	}
+	return envelope
+}
+
+// addSignedProvider stores a provider along with the envelope of its verified
+// signed provider record, if the provider store supports it.
+func (dht *IpfsDHT) addSignedProvider(ctx context.Context, key []byte, prov peer.AddrInfo, envelope []byte) error {
+	if ps, ok := dht.providerStore.(providers.SignedProviderStore); ok {
+		return ps.AddSignedProvider(ctx, key, prov, envelope)
+	}
+	return dht.providerStore.AddProvider(ctx, key, prov)
+}
+
+// getSignedProviders returns the providers of key stored locally, along with
+// the signed provider record envelopes known for them if the provider store
+// supports it.
+func (dht *IpfsDHT) getSignedProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, [][]byte, error) {
+	if ps, ok := dht.providerStore.(providers.SignedProviderStore); ok {
+		return ps.GetSignedProviders(ctx, key)
+	}
+	provs, err := dht.providerStore.GetProviders(ctx, key)
+	return provs, nil, err
+}
+
+// verifyProviders checks the signed provider record envelopes received along
+// with the providers of key. Providers with a valid signed record are returned
+// with the signed addresses, including the ones that were only known from
+// their record. Invalid envelopes are ignored, and unsigned providers are
+// dropped if signed provider records are required.
+func (dht *IpfsDHT) verifyProviders(key multihash.Multihash, provs []peer.AddrInfo, envelopes [][]byte) []peer.AddrInfo {
+	out, _ := dht.verifySignedProviders(key, provs, envelopes)
+	return out
+}
+
+// verifySignedProviders is like verifyProviders, but also returns the
+// providers whose addresses come from a valid signed record.
+func (dht *IpfsDHT) verifySignedProviders(key multihash.Multihash, provs []peer.AddrInfo, envelopes [][]byte) ([]peer.AddrInfo, map[peer.ID]struct{}) {
+	if len(envelopes) == 0 && !dht.requireSignedProviderRecords {
+		return provs, nil
+	}
+
+	var records []*providers.ProviderRecord
+	signed := make(map[peer.ID]*providers.ProviderRecord, len(envelopes))
+	for _, envelope := range envelopes {
+		rec, err := providers.OpenProviderRecord(envelope, key)
+		if err != nil {
+			logger.Debugw("invalid signed provider record", "mh", internal.LoggableProviderRecordBytes(key), "error", err)
+			continue
+		}
+		if _, ok := signed[rec.PeerID]; ok {
+			continue
+		}
+		signed[rec.PeerID] = rec
+		records = append(records, rec)
+	}
+
+	out := make([]peer.AddrInfo, 0, len(provs)+len(records))
+	for _, p := range provs {
+		if rec, ok := signed[p.ID]; ok {
+			out = append(out, peer.AddrInfo{ID: p.ID, Addrs: rec.Addrs})
+			delete(signed, p.ID)
+		} else if !dht.requireSignedProviderRecords || p.ID == dht.self {
+			out = append(out, p)
+		}
+	}
+	for _, rec := range records {
+		if _, ok := signed[rec.PeerID]; ok {
+			out = append(out, peer.AddrInfo{ID: rec.PeerID, Addrs: rec.Addrs})
+		}
+	}
+
+	verified := make(map[peer.ID]struct{}, len(records))
+	for _, rec := range records {
+		verified[rec.PeerID] = struct{}{}
+	}
+	return out, verified
+}
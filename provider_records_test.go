@@ -0,0 +1,73 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+func TestSignedProviderRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 3)
+	signed := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	unsigned := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false, RequireSignedProviderRecords())
+
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[1], servers[2])
+	for _, d := range []*IpfsDHT{signed, unsigned, client} {
+		connect(t, ctx, d, servers[0])
+	}
+
+	key := testCaseCids[0]
+	require.NoError(t, signed.Provide(ctx, key, true))
+	require.NoError(t, unsigned.Provide(ctx, key, true))
+
+	waitForProviders(t, servers, key.Hash(), func(s *IpfsDHT, key multihash.Multihash) bool {
+		provs, records, err := s.providerStore.(providers.SignedProviderStore).GetSignedProviders(ctx, key)
+		return err == nil && len(provs) == 2 && len(records) == 1
+	})
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	provs, err := client.FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, signed.self, provs[0].ID)
+	require.NotEmpty(t, provs[0].Addrs)
+
+	// without requiring signed records, both providers are returned
+	provs, err = servers[2].FindProviders(ctxT, key)
+	require.NoError(t, err)
+	require.Len(t, provs, 2)
+	require.ElementsMatch(t, []peer.ID{signed.self, unsigned.self}, []peer.ID{provs[0].ID, provs[1].ID})
+}
+
+func TestVerifyProvidersRejectsForgedRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, RequireSignedProviderRecords())
+	victim := setupDHT(ctx, t, false)
+	key := testCaseCids[0].Hash()
+
+	// a record for the victim signed with another key must not be accepted
+	forged, err := providers.SealProviderRecord(key, peer.AddrInfo{ID: victim.self, Addrs: d.host.Addrs()}, d.peerstore.PrivKey(d.self))
+	require.NoError(t, err)
+	provs := d.verifyProviders(key, []peer.AddrInfo{{ID: victim.self, Addrs: victim.host.Addrs()}}, [][]byte{forged})
+	require.Empty(t, provs)
+
+	genuine, err := providers.SealProviderRecord(key, peer.AddrInfo{ID: victim.self, Addrs: victim.host.Addrs()}, victim.peerstore.PrivKey(victim.self))
+	require.NoError(t, err)
+	provs = d.verifyProviders(key, []peer.AddrInfo{{ID: victim.self, Addrs: d.host.Addrs()}}, [][]byte{genuine})
+	require.Len(t, provs, 1)
+	require.Equal(t, victim.host.Addrs(), provs[0].Addrs)
+}
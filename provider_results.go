@@ -0,0 +1,86 @@
+package dht
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderAddrSource tells where the addresses of a provider come from.
+type ProviderAddrSource int
+
+const (
+	// ProviderAddrsNone means no address of the provider is known.
+	ProviderAddrsNone ProviderAddrSource = iota
+	// ProviderAddrsFromResponse means the addresses were returned along with
+	// the provider by the peer that knew of it.
+	ProviderAddrsFromResponse
+	// ProviderAddrsFromSignedRecord means the addresses come from a provider
+	// record signed by the provider itself.
+	ProviderAddrsFromSignedRecord
+	// ProviderAddrsFromPeerstore means the addresses come from the local
+	// peerstore, they may have been learnt before the lookup.
+	ProviderAddrsFromPeerstore
+)
+
+func (s ProviderAddrSource) String() string {
+	switch s {
+	case ProviderAddrsNone:
+		return "none"
+	case ProviderAddrsFromResponse:
+		return "response"
+	case ProviderAddrsFromSignedRecord:
+		return "signed-record"
+	case ProviderAddrsFromPeerstore:
+		return "peerstore"
+	default:
+		return "unknown"
+	}
+}
+
+// ProviderResult is a provider found by FindProvidersAsyncWithInfo, along with
+// how it was found.
+type ProviderResult struct {
+	peer.AddrInfo
+
+	// From is the peer that returned the provider, this node if it was found
+	// in the local provider store.
+	From peer.ID
+	// Depth is the number of peers on the referral path from this node to
+	// From, 1 for the peers the lookup started with and 0 for local results.
+	Depth int
+	// Latency is the round trip time of the request answered by From.
+	Latency time.Duration
+	// Elapsed is the time since the lookup started.
+	Elapsed time.Duration
+	// AddrSource tells where Addrs come from.
+	AddrSource ProviderAddrSource
+}
+
+// newProviderResult describes prov, as returned by from within a lookup. The
+// addresses of providers returned without any are taken from the peerstore.
+func (dht *IpfsDHT) newProviderResult(ctx context.Context, prov peer.AddrInfo, from peer.ID, signed map[peer.ID]struct{}, rtt time.Duration) ProviderResult {
+	res := ProviderResult{AddrInfo: prov, From: from, Latency: rtt}
+	if hop, ok := lookupHopFromContext(ctx); ok {
+		res.Depth = hop.depth
+		res.Elapsed = time.Since(hop.lookupStart)
+	}
+
+	if _, ok := signed[prov.ID]; ok {
+		res.AddrSource = ProviderAddrsFromSignedRecord
+		return res
+	}
+	// the local provider store returns the addresses of the peerstore
+	if len(prov.Addrs) > 0 && from != dht.self {
+		res.AddrSource = ProviderAddrsFromResponse
+		return res
+	}
+	if len(prov.Addrs) == 0 {
+		res.Addrs = dht.peerstore.Addrs(prov.ID)
+	}
+	if len(res.Addrs) > 0 {
+		res.AddrSource = ProviderAddrsFromPeerstore
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProvidersAsyncWithInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 2)
+	signer := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	client := setupDHT(ctx, t, false)
+
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, signer, servers[0])
+
+	key := testCaseCids[0]
+	require.NoError(t, signer.Provide(ctx, key, true))
+	waitForProviders(t, servers, key.Hash(), storesProviders(1))
+	// connected afterwards, so that it doesn't store the provider record
+	connect(t, ctx, client, servers[0])
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	var results []ProviderResult
+	for res := range client.FindProvidersAsyncWithInfo(ctxT, key, 0) {
+		results = append(results, res)
+	}
+	require.Len(t, results, 1)
+	res := results[0]
+	require.Equal(t, signer.self, res.ID)
+	require.NotEmpty(t, res.Addrs)
+	// the signer also stores its own provider record
+	require.Contains(t, []peer.ID{servers[0].self, servers[1].self, signer.self}, res.From)
+	// the other peers are only known from servers[0]
+	if res.From == servers[0].self {
+		require.Equal(t, 1, res.Depth)
+	} else {
+		require.Equal(t, 2, res.Depth)
+	}
+	require.Positive(t, res.Latency)
+	require.GreaterOrEqual(t, res.Elapsed, res.Latency)
+	require.Equal(t, ProviderAddrsFromSignedRecord, res.AddrSource)
+
+	// providers found locally
+	results = nil
+	for res := range servers[0].FindProvidersAsyncWithInfo(ctxT, key, 1) {
+		results = append(results, res)
+	}
+	require.Len(t, results, 1)
+	require.Equal(t, servers[0].self, results[0].From)
+	require.Zero(t, results[0].Depth)
+	require.Equal(t, ProviderAddrsFromSignedRecord, results[0].AddrSource)
+}
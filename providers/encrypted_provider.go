@@ -0,0 +1,379 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+const (
+	// EncryptedProvidersKeyPrefix is the prefix/namespace for the encrypted
+	// provider records, stored by double-hashed key and publisher.
+	EncryptedProvidersKeyPrefix = "/providers-encrypted/"
+
+	// EncryptedProvidersByPeerKeyPrefix is the prefix/namespace of the index
+	// of encrypted provider records by publisher. Entries have no value.
+	EncryptedProvidersByPeerKeyPrefix = "/providers-encrypted-by-peer/"
+)
+
+const (
+	// maxEncryptedPlaintextSize is the maximum size of the provider peer
+	// encrypted in a record. Addresses that don't fit are left out.
+	maxEncryptedPlaintextSize = 1024
+	// MaxEncryptedCiphertextSize is the maximum size of the ciphertext of an
+	// encrypted provider record, the AES-GCM tag included.
+	MaxEncryptedCiphertextSize = maxEncryptedPlaintextSize + 16
+)
+
+// encryptionKeySalt is hashed along with a multihash to derive the key
+// encrypting its provider records.
+var encryptionKeySalt = []byte("libp2p-kad-dht-encrypted-provider-record")
+
+// EncryptedProviderStore is a ProviderStore that can also keep provider
+// records encrypted with a key derived from the provided multihash, indexed
+// by double-hashed multihash (see DoubleHash). Peers storing them don't learn
+// which multihash is provided.
+type EncryptedProviderStore interface {
+	ProviderStore
+	// AddEncryptedProvider stores an encrypted provider record for the
+	// double-hashed key, replacing the previous record of the same publisher.
+	AddEncryptedProvider(ctx context.Context, key []byte, rec *pb.EncryptedProviderRecord) error
+	// GetEncryptedProviders returns the unexpired encrypted provider records
+	// of the double-hashed key.
+	GetEncryptedProviders(ctx context.Context, key []byte) ([]*pb.EncryptedProviderRecord, error)
+}
+
+var _ EncryptedProviderStore = (*ProviderManager)(nil)
+
+// DoubleHash returns the SHA2-256 multihash of a multihash. Encrypted provider
+// records are stored and looked up under the double-hashed multihash, so that
+// the peers involved don't learn the provided multihash.
+func DoubleHash(key multihash.Multihash) multihash.Multihash {
+	// Sum only fails for unknown hash functions
+	mh, _ := multihash.Sum(key, multihash.SHA2_256, -1)
+	return mh
+}
+
+func providerRecordCipher(key multihash.Multihash) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(encryptionKeySalt)
+	h.Write(key)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptProviderRecord encrypts the provider record of prov for key, as
+// published by publisher. Only peers knowing key can decrypt it.
+func EncryptProviderRecord(key multihash.Multihash, publisher peer.ID, prov peer.AddrInfo) (*pb.EncryptedProviderRecord, error) {
+	aead, err := providerRecordCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([][]byte, len(prov.Addrs))
+	for i, a := range prov.Addrs {
+		addrs[i] = a.Bytes()
+	}
+	msg := &pb.Message_Peer{Id: []byte(prov.ID), Addrs: addrs}
+	for proto.Size(msg) > maxEncryptedPlaintextSize && len(msg.Addrs) > 0 {
+		msg.Addrs = msg.Addrs[:len(msg.Addrs)-1]
+	}
+	plaintext, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) > maxEncryptedPlaintextSize {
+		return nil, errors.New("provider record too large")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &pb.EncryptedProviderRecord{
+		Publisher:  []byte(publisher),
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(publisher)),
+	}, nil
+}
+
+// DecryptProviderRecord decrypts an encrypted provider record of key. It fails
+// if the provider isn't the publisher the record was encrypted for.
+//
+// The record isn't signed: anyone knowing key can produce a valid record for
+// any publisher. Peers storing records only accept them from their publisher,
+// but the records returned by a peer are only as trustworthy as the peer.
+func DecryptProviderRecord(key multihash.Multihash, rec *pb.EncryptedProviderRecord) (peer.AddrInfo, error) {
+	publisher, err := peer.IDFromBytes(rec.GetPublisher())
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("invalid publisher: %w", err)
+	}
+	aead, err := providerRecordCipher(key)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if len(rec.GetNonce()) != aead.NonceSize() {
+		return peer.AddrInfo{}, errors.New("invalid nonce size")
+	}
+	plaintext, err := aead.Open(nil, rec.GetNonce(), rec.GetCiphertext(), rec.GetPublisher())
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	var msg pb.Message_Peer
+	if err := proto.Unmarshal(plaintext, &msg); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	id, err := peer.IDFromBytes(msg.GetId())
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if id != publisher {
+		return peer.AddrInfo{}, errors.New("provider record not published by the provider")
+	}
+	addrs := make([]ma.Multiaddr, 0, len(msg.GetAddrs()))
+	for _, b := range msg.GetAddrs() {
+		if a, err := ma.NewMultiaddrBytes(b); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	return peer.AddrInfo{ID: id, Addrs: addrs}, nil
+}
+
+func mkEncryptedProvKey(k []byte) string {
+	return EncryptedProvidersKeyPrefix + base32.RawStdEncoding.EncodeToString(k)
+}
+
+func mkEncryptedProvKeyFor(k []byte, p peer.ID) string {
+	return mkEncryptedProvKey(k) + "/" + base32.RawStdEncoding.EncodeToString([]byte(p))
+}
+
+func mkEncryptedPeerIndexKeyFor(p peer.ID, k []byte) string {
+	return EncryptedProvidersByPeerKeyPrefix + base32.RawStdEncoding.EncodeToString([]byte(p)) +
+		"/" + base32.RawStdEncoding.EncodeToString(k)
+}
+
+// ValidateEncryptedProviderRecord checks that an encrypted provider record
+// received for key can be stored: key must be a double-hashed multihash (see
+// DoubleHash) and the record must not exceed the size of a provider peer
+// encrypted by EncryptProviderRecord. The record can't be decrypted without
+// the provided multihash, so its content isn't checked.
+func ValidateEncryptedProviderRecord(key []byte, rec *pb.EncryptedProviderRecord) error {
+	dmh, err := multihash.Decode(key)
+	if err != nil || dmh.Code != multihash.SHA2_256 || dmh.Length != sha256.Size {
+		return errors.New("encrypted provider record key isn't a double-hashed multihash")
+	}
+	if _, err := peer.IDFromBytes(rec.GetPublisher()); err != nil {
+		return fmt.Errorf("invalid publisher: %w", err)
+	}
+	// AES-GCM standard nonce and tag sizes
+	if len(rec.GetNonce()) != 12 {
+		return errors.New("invalid nonce size")
+	}
+	if n := len(rec.GetCiphertext()); n < 16 || n > MaxEncryptedCiphertextSize {
+		return fmt.Errorf("invalid ciphertext size %d", n)
+	}
+	return nil
+}
+
+// parseEncryptedProvKey returns the publisher of an encrypted provider record
+// datastore key, along with its double-hashed key.
+func parseEncryptedProvKey(dsk string) ([]byte, peer.ID, error) {
+	rest, ok := strings.CutPrefix(dsk, EncryptedProvidersKeyPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("not an encrypted provider record key: %s", dsk)
+	}
+	enck, encp, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, "", fmt.Errorf("not an encrypted provider record key: %s", dsk)
+	}
+	k, err := base32.RawStdEncoding.DecodeString(enck)
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := base32.RawStdEncoding.DecodeString(encp)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, peer.ID(p), nil
+}
+
+// AddEncryptedProvider stores an encrypted provider record for the
+// double-hashed key, replacing the previous record of the same publisher.
+//
+// Encrypted provider records are indexed by publisher and counted along with
+// the other provider records, but they aren't cached.
+func (pm *ProviderManager) AddEncryptedProvider(ctx context.Context, k []byte, rec *pb.EncryptedProviderRecord) error {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.AddEncryptedProvider")
+	defer span.End()
+
+	if err := ValidateEncryptedProviderRecord(k, rec); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	prov := &addProv{
+		ctx:       ctx,
+		key:       k,
+		val:       peer.ID(rec.GetPublisher()),
+		encrypted: data,
+	}
+	select {
+	case pm.newprovs <- prov:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addEncryptedProv writes an encrypted provider record and its index entry.
+func (pm *ProviderManager) addEncryptedProv(ctx context.Context, k []byte, p peer.ID, data []byte) error {
+	dsk := ds.RawKey(mkEncryptedProvKeyFor(k, p))
+	exists, err := pm.dstore.Has(ctx, dsk)
+	if err != nil {
+		return err
+	}
+	if err := pm.dstore.Put(ctx, dsk, providerValue(time.Now(), data)); err != nil {
+		return err
+	}
+	if err := pm.dstore.Put(ctx, ds.RawKey(mkEncryptedPeerIndexKeyFor(p, k)), nil); err != nil {
+		return err
+	}
+	if !exists {
+		pm.addCount(1)
+	}
+	return nil
+}
+
+// GetEncryptedProviders returns the unexpired encrypted provider records of
+// the double-hashed key.
+func (pm *ProviderManager) GetEncryptedProviders(ctx context.Context, k []byte) ([]*pb.EncryptedProviderRecord, error) {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.GetEncryptedProviders")
+	defer span.End()
+
+	if err := pm.flush(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := pm.backing.Query(ctx, dsq.Query{Prefix: mkEncryptedProvKey(k)})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var out []*pb.EncryptedProviderRecord
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		t, data, err := readProviderValue(e.Value)
+		if err != nil || now.Sub(t) > ProvideValidity {
+			continue
+		}
+		rec := new(pb.EncryptedProviderRecord)
+		if err := proto.Unmarshal(data, rec); err != nil {
+			log.Debugw("failed to parse encrypted provider record", "key", e.Key, "error", err)
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+type rmEncryptedProv struct {
+	ctx  context.Context
+	dsk  string
+	now  time.Time
+	resp chan error
+}
+
+// gcEncryptedProviders removes the encrypted provider records expired as of
+// now. The records are listed concurrently with the run method, which removes
+// them.
+func (pm *ProviderManager) gcEncryptedProviders(ctx context.Context, now time.Time) {
+	if err := pm.flush(ctx); err != nil {
+		log.Error("encrypted provider record GC failed: ", err)
+		return
+	}
+	res, err := pm.backing.Query(ctx, dsq.Query{Prefix: EncryptedProvidersKeyPrefix})
+	if err != nil {
+		log.Error("encrypted provider record GC query failed: ", err)
+		return
+	}
+	defer res.Close()
+
+	for e := range res.Next() {
+		if e.Error != nil {
+			log.Error("got error from encrypted provider record GC query: ", e.Error)
+			return
+		}
+		if t, err := readTimeValue(e.Value); err == nil && now.Sub(t) <= ProvideValidity {
+			continue
+		}
+		rp := &rmEncryptedProv{
+			ctx:  ctx,
+			dsk:  e.Key,
+			now:  now,
+			resp: make(chan error, 1), // buffered to prevent sender from blocking
+		}
+		select {
+		case pm.rmencrypted <- rp:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case err := <-rp.resp:
+			if err != nil {
+				log.Error("failed to remove encrypted provider record from disk: ", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rmExpiredEncryptedProv removes an encrypted provider record along with its
+// index entry, if it is still expired as of now: it may have been replaced
+// since it was listed.
+func (pm *ProviderManager) rmExpiredEncryptedProv(ctx context.Context, dsk string, now time.Time) error {
+	v, err := pm.dstore.Get(ctx, ds.RawKey(dsk))
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if t, err := readTimeValue(v); err == nil && now.Sub(t) <= ProvideValidity {
+		return nil
+	}
+	if err := pm.dstore.Delete(ctx, ds.RawKey(dsk)); err != nil {
+		return err
+	}
+	if k, p, err := parseEncryptedProvKey(dsk); err == nil {
+		if err := pm.dstore.Delete(ctx, ds.RawKey(mkEncryptedPeerIndexKeyFor(p, k))); err != nil {
+			return err
+		}
+	}
+	pm.addCount(-1)
+	return nil
+}
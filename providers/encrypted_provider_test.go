@@ -0,0 +1,145 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestEncryptedProviderRecord(t *testing.T) {
+	id, _ := newSigner(t)
+	key := internal.Hash([]byte("test"))
+	prov := peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+
+	rec, err := EncryptProviderRecord(key, id, prov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptProviderRecord(key, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || len(got.Addrs) != 1 || !got.Addrs[0].Equal(prov.Addrs[0]) {
+		t.Fatalf("expected %v, got %v", prov, got)
+	}
+
+	if _, err := DecryptProviderRecord(internal.Hash([]byte("other")), rec); err == nil {
+		t.Fatal("expected record to only be decrypted with the provided key")
+	}
+
+	// a record stored on behalf of another publisher can't be decrypted
+	other, _ := newSigner(t)
+	rec.Publisher = []byte(other)
+	if _, err := DecryptProviderRecord(key, rec); err == nil {
+		t.Fatal("expected record with a different publisher to be rejected")
+	}
+
+	// publishers can only publish their own records
+	rec, err = EncryptProviderRecord(key, other, prov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptProviderRecord(key, rec); err == nil {
+		t.Fatal("expected record published by another peer to be rejected")
+	}
+}
+
+func TestEncryptedProviderStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	key := DoubleHash(internal.Hash([]byte("test")))
+	alice, _ := newSigner(t)
+	bob, _ := newSigner(t)
+	for _, id := range []peer.ID{alice, alice, bob} {
+		rec, err := EncryptProviderRecord(key, id, peer.AddrInfo{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.AddEncryptedProvider(ctx, key, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs, err := p.GetEncryptedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected one record per publisher, got %d", len(recs))
+	}
+	if n, _ := p.CountProviders(ctx); n != 2 {
+		t.Fatalf("expected 2 provider records, got %d", n)
+	}
+
+	// plain provider records of the double-hashed key are unaffected
+	if provs, _ := p.GetProviders(ctx, key); len(provs) != 0 {
+		t.Fatalf("expected no plain provider, got %v", provs)
+	}
+
+	p.gcEncryptedProviders(ctx, time.Now().Add(2*ProvideValidity))
+	if recs, _ := p.GetEncryptedProviders(ctx, key); len(recs) != 0 {
+		t.Fatalf("expected expired records to be collected, got %d", len(recs))
+	}
+	if n, _ := p.CountProviders(ctx); n != 0 {
+		t.Fatalf("expected no provider record, got %d", n)
+	}
+}
+
+func TestValidateEncryptedProviderRecord(t *testing.T) {
+	id, _ := newSigner(t)
+	key := internal.Hash([]byte("test"))
+	rec, err := EncryptProviderRecord(key, id, peer.AddrInfo{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateEncryptedProviderRecord(DoubleHash(key), rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateEncryptedProviderRecord([]byte("not a double-hashed key"), rec); err == nil {
+		t.Fatal("expected key that isn't a double hash to be rejected")
+	}
+
+	rec.Ciphertext = make([]byte, MaxEncryptedCiphertextSize+1)
+	if err := ValidateEncryptedProviderRecord(DoubleHash(key), rec); err == nil {
+		t.Fatal("expected oversized record to be rejected")
+	}
+
+	// addresses that don't fit are left out
+	addrs := make([]ma.Multiaddr, 100)
+	for i := range addrs {
+		addrs[i] = ma.StringCast("/dns4/a-rather-long-host-name.example.com/tcp/4001")
+	}
+	rec, err = EncryptProviderRecord(key, id, peer.AddrInfo{ID: id, Addrs: addrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateEncryptedProviderRecord(DoubleHash(key), rec); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptProviderRecord(key, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Addrs) == 0 || len(got.Addrs) == len(addrs) {
+		t.Fatalf("expected some addresses to be left out, got %d", len(got.Addrs))
+	}
+}
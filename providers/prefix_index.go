@@ -0,0 +1,249 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-base32"
+)
+
+const (
+	// ProvidersByPrefixKeyPrefix is the prefix/namespace of the secondary
+	// index of provider records by Kademlia ID of the provided key. Entries
+	// have no value, the provider record itself is stored under
+	// ProvidersKeyPrefix.
+	ProvidersByPrefixKeyPrefix = "/providers-by-prefix/"
+
+	// providersPrefixIndexedKey marks a datastore whose provider records have
+	// all been indexed by prefix.
+	providersPrefixIndexedKey = "/providers-by-prefix-indexed"
+
+	// prefixIndexDepth is the number of leading bytes of the Kademlia ID
+	// stored as their own path component in the prefix index, so that
+	// datastore queries can be narrowed down to the matching entries.
+	prefixIndexDepth = 2
+)
+
+// PrefixProviderStore is a ProviderStore that can also look up the provider
+// records of every key whose Kademlia ID (the SHA-256 of the key) starts with
+// a given bit prefix, so that peers can look up providers without revealing
+// the exact key they are interested in.
+type PrefixProviderStore interface {
+	ProviderStore
+	// GetProvidersByPrefix returns at most limit unexpired provider records
+	// whose key's Kademlia ID starts with the first bits bits of prefix.
+	GetProvidersByPrefix(ctx context.Context, prefix []byte, bits int, limit int) ([]ProviderEntry, error)
+}
+
+var _ PrefixProviderStore = (*ProviderManager)(nil)
+
+// KadIDPrefix returns the first bits bits of the Kademlia ID of key, the
+// trailing bits of the last byte set to zero.
+func KadIDPrefix(key []byte, bits int) []byte {
+	id := sha256.Sum256(key)
+	prefix := id[:(bits+7)/8]
+	if r := bits % 8; r != 0 {
+		prefix[len(prefix)-1] &= byte(0xff << (8 - r))
+	}
+	return prefix
+}
+
+// HasKadIDPrefix reports whether the Kademlia ID id starts with the first bits
+// bits of prefix.
+func HasKadIDPrefix(id []byte, prefix []byte, bits int) bool {
+	if bits < 0 || len(id)*8 < bits || len(prefix)*8 < bits {
+		return false
+	}
+	full := bits / 8
+	if string(id[:full]) != string(prefix[:full]) {
+		return false
+	}
+	if r := bits % 8; r != 0 {
+		mask := byte(0xff << (8 - r))
+		return id[full]&mask == prefix[full]&mask
+	}
+	return true
+}
+
+// mkPrefixIndexPath returns the prefix index path of the Kademlia ID id, made
+// of its prefixIndexDepth first bytes followed by the rest of it.
+func mkPrefixIndexPath(id []byte) string {
+	var b strings.Builder
+	b.WriteString(ProvidersByPrefixKeyPrefix)
+	for i := 0; i < prefixIndexDepth; i++ {
+		b.WriteString(hex.EncodeToString(id[i : i+1]))
+		b.WriteByte('/')
+	}
+	b.WriteString(hex.EncodeToString(id[prefixIndexDepth:]))
+	return b.String()
+}
+
+func mkPrefixIndexKeyFor(k []byte, p peer.ID) string {
+	id := sha256.Sum256(k)
+	return mkPrefixIndexPath(id[:]) + "/" +
+		base32.RawStdEncoding.EncodeToString(k) + "/" +
+		base32.RawStdEncoding.EncodeToString([]byte(p))
+}
+
+// mkPrefixIndexQueryPrefix returns the narrowest datastore query prefix
+// holding the index entries matching the first bits bits of prefix.
+func mkPrefixIndexQueryPrefix(prefix []byte, bits int) string {
+	q := ProvidersByPrefixKeyPrefix
+	for i := 0; i < prefixIndexDepth && (i+1)*8 <= bits; i++ {
+		q += hex.EncodeToString(prefix[i:i+1]) + "/"
+	}
+	return q
+}
+
+// parsePrefixIndexKey returns the key and the provider of a prefix index
+// datastore key.
+func parsePrefixIndexKey(dsk string) ([]byte, peer.ID, error) {
+	rest, ok := strings.CutPrefix(dsk, ProvidersByPrefixKeyPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("not a prefix index key: %s", dsk)
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != prefixIndexDepth+3 {
+		return nil, "", fmt.Errorf("not a prefix index key: %s", dsk)
+	}
+	k, err := base32.RawStdEncoding.DecodeString(parts[prefixIndexDepth+1])
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := base32.RawStdEncoding.DecodeString(parts[prefixIndexDepth+2])
+	if err != nil {
+		return nil, "", err
+	}
+	return k, peer.ID(p), nil
+}
+
+// ErrNotIndexedByPrefix is returned by GetProvidersByPrefix when the provider
+// records aren't indexed by prefix, see IndexByPrefix.
+var ErrNotIndexedByPrefix = errors.New("provider records aren't indexed by prefix")
+
+// IndexByPrefix indexes the provider records by the prefix of the Kademlia ID
+// of their key, so that GetProvidersByPrefix can serve lookups by prefix. The
+// records written without the index are indexed in the background, they may
+// be missing from the results meanwhile.
+// Defaults to disabled.
+func IndexByPrefix() Option {
+	return func(pm *ProviderManager) error {
+		pm.indexByPrefix = true
+		return nil
+	}
+}
+
+// indexProvidersByPrefix adds the provider records written without the prefix
+// index to the index. It only runs once per datastore, until the index is
+// disabled.
+func indexProvidersByPrefix(ctx context.Context, dstore ds.Batching) error {
+	_, err := dstore.Get(ctx, ds.NewKey(providersPrefixIndexedKey))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ds.ErrNotFound) {
+		return err
+	}
+
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: ProvidersKeyPrefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	batch, err := dstore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	indexed := 0
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k, p, err := parseProvKey(e.Key)
+		if err != nil {
+			continue
+		}
+		if err := batch.Put(ctx, ds.RawKey(mkPrefixIndexKeyFor(k, p)), nil); err != nil {
+			return err
+		}
+		indexed++
+	}
+	if err := batch.Put(ctx, ds.NewKey(providersPrefixIndexedKey), []byte{1}); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	if indexed > 0 {
+		log.Infof("indexed %d provider records by prefix", indexed)
+	}
+	return nil
+}
+
+// GetProvidersByPrefix returns at most limit unexpired provider records whose
+// key's Kademlia ID starts with the first bits bits of prefix. Returns
+// ErrNotIndexedByPrefix unless IndexByPrefix is set.
+func (pm *ProviderManager) GetProvidersByPrefix(ctx context.Context, prefix []byte, bits int, limit int) ([]ProviderEntry, error) {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.GetProvidersByPrefix")
+	defer span.End()
+
+	if !pm.indexByPrefix {
+		return nil, ErrNotIndexedByPrefix
+	}
+	if bits < 0 || len(prefix)*8 < bits {
+		return nil, fmt.Errorf("invalid prefix length: %d bits of %d bytes", bits, len(prefix))
+	}
+	if err := pm.flush(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := pm.backing.Query(ctx, dsq.Query{Prefix: mkPrefixIndexQueryPrefix(prefix, bits), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var out []ProviderEntry
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		if len(out) >= limit {
+			break
+		}
+		k, p, err := parsePrefixIndexKey(e.Key)
+		if err != nil {
+			continue
+		}
+		if id := sha256.Sum256(k); !HasKadIDPrefix(id[:], prefix, bits) {
+			continue
+		}
+
+		// the index isn't updated when records expire, only when they are
+		// garbage collected.
+		v, err := pm.backing.Get(ctx, ds.RawKey(mkProvKeyFor(k, p)))
+		if errors.Is(err, ds.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := readTimeValue(v)
+		if err != nil || now.Sub(t) > ProvideValidity {
+			continue
+		}
+		out = append(out, ProviderEntry{Key: k, Provider: p, AddedAt: t})
+	}
+	return out, nil
+}
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestKadIDPrefix(t *testing.T) {
+	key := internal.Hash([]byte("a"))
+	id := sha256.Sum256(key)
+	for _, bits := range []int{0, 1, 7, 8, 13, 256} {
+		prefix := KadIDPrefix(key, bits)
+		if len(prefix) != (bits+7)/8 {
+			t.Fatalf("expected %d bits prefix to be %d bytes, got %d", bits, (bits+7)/8, len(prefix))
+		}
+		if !HasKadIDPrefix(id[:], prefix, bits) {
+			t.Fatalf("expected kademlia ID to have its %d bits prefix", bits)
+		}
+	}
+
+	prefix := KadIDPrefix(key, 13)
+	if prefix[1]&0x07 != 0 {
+		t.Fatalf("expected trailing bits to be zero, got %08b", prefix[1])
+	}
+	other := id
+	other[1] ^= 0x08 // 13th bit
+	if HasKadIDPrefix(other[:], prefix, 13) {
+		t.Fatal("expected kademlia ID differing in the 13th bit not to match")
+	}
+	if !HasKadIDPrefix(other[:], prefix, 12) {
+		t.Fatal("expected kademlia ID to match the 12 bits prefix")
+	}
+}
+
+func TestProvidersByPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()), IndexByPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var keys [][]byte
+	for i := 0; i < 256; i++ {
+		k := internal.Hash([]byte(fmt.Sprint(i)))
+		keys = append(keys, k)
+		p.AddProvider(ctx, k, peer.AddrInfo{ID: peer.ID("alice")})
+	}
+
+	target := keys[0]
+	for _, bits := range []int{0, 4, 12, 20} {
+		prefix := KadIDPrefix(target, bits)
+		expected := 0
+		for _, k := range keys {
+			if id := sha256.Sum256(k); HasKadIDPrefix(id[:], prefix, bits) {
+				expected++
+			}
+		}
+
+		entries, err := p.GetProvidersByPrefix(ctx, prefix, bits, len(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != expected {
+			t.Fatalf("expected %d records matching %d bits, got %d", expected, bits, len(entries))
+		}
+		found := false
+		for _, e := range entries {
+			if id := sha256.Sum256(e.Key); !HasKadIDPrefix(id[:], prefix, bits) {
+				t.Fatalf("record of key %x doesn't match the %d bits prefix", e.Key, bits)
+			}
+			found = found || string(e.Key) == string(target)
+		}
+		if !found {
+			t.Fatalf("expected the target key to match its %d bits prefix", bits)
+		}
+	}
+
+	if entries, _ := p.GetProvidersByPrefix(ctx, nil, 0, 10); len(entries) != 10 {
+		t.Fatalf("expected results to be capped to 10, got %d", len(entries))
+	}
+
+	if err := p.RemoveProvider(ctx, target, peer.ID("alice")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := p.GetProvidersByPrefix(ctx, KadIDPrefix(target, 256), 256, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected removed record to be dropped from the index, got %v", entries)
+	}
+}
+
+func TestProvidersIndexedByPrefixOnStartup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// provider records written without the index
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	a := internal.Hash([]byte("a"))
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("alice")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetProvidersByPrefix(ctx, KadIDPrefix(a, 16), 16, 10); !errors.Is(err, ErrNotIndexedByPrefix) {
+		t.Fatalf("expected ErrNotIndexedByPrefix, got %v", err)
+	}
+	p.Close()
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: ProvidersByPrefixKeyPrefix, KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed, _ := res.Rest(); len(indexed) != 0 {
+		t.Fatalf("expected no prefix index entries, got %v", indexed)
+	}
+
+	p, err = NewProviderManager(peer.ID("testing"), ps, dstore, IndexByPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the records are indexed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := p.GetProvidersByPrefix(ctx, KadIDPrefix(a, 16), 16, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 1 && string(entries[0].Key) == string(a) && entries[0].Provider == peer.ID("alice") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected alice to provide a, got %v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,380 @@
+package providers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-base32"
+)
+
+const (
+	// ProvidersByPeerKeyPrefix is the prefix/namespace of the secondary index
+	// of provider records by provider peer ID. Entries have no value, the
+	// provider record itself is stored under ProvidersKeyPrefix.
+	ProvidersByPeerKeyPrefix = "/providers-by-peer/"
+
+	// providersIndexedKey marks a datastore whose provider records have all
+	// been indexed by peer.
+	providersIndexedKey = "/providers-by-peer-indexed"
+
+	// providersCountKey holds the number of provider records in the
+	// datastore as a varint, as of the last clean shutdown.
+	providersCountKey = "/providers-count"
+)
+
+// ProviderEntry describes a provider record held by a ProviderStore.
+type ProviderEntry struct {
+	// Key is the provided key.
+	Key []byte
+	// Provider is the peer providing the key.
+	Provider peer.ID
+	// AddedAt is the time the provider record was last added.
+	AddedAt time.Time
+}
+
+// IndexedProviderStore is a ProviderStore that also indexes provider records
+// by provider peer ID, so that the records of a peer can be looked up and
+// removed without scanning the whole store.
+type IndexedProviderStore interface {
+	ProviderStore
+	// RemoveProvider removes the provider record of prov for key, if any.
+	RemoveProvider(ctx context.Context, key []byte, prov peer.ID) error
+	// ProvidersByPeer returns the keys prov is currently a provider for.
+	ProvidersByPeer(ctx context.Context, prov peer.ID) ([][]byte, error)
+	// CountProviders returns the number of provider records held, encrypted
+	// ones included. Expired records are counted until they are garbage
+	// collected.
+	CountProviders(ctx context.Context) (int, error)
+	// ForEachProvider calls fn for every unexpired provider record until fn
+	// returns false.
+	ForEachProvider(ctx context.Context, fn func(ProviderEntry) bool) error
+}
+
+var _ IndexedProviderStore = (*ProviderManager)(nil)
+
+type rmProv struct {
+	ctx  context.Context
+	key  []byte
+	val  peer.ID
+	resp chan error
+}
+
+func mkPeerIndexKey(p peer.ID) string {
+	return ProvidersByPeerKeyPrefix + base32.RawStdEncoding.EncodeToString([]byte(p))
+}
+
+func mkPeerIndexKeyFor(p peer.ID, k []byte) string {
+	return mkPeerIndexKey(p) + "/" + base32.RawStdEncoding.EncodeToString(k)
+}
+
+// parseProvKey returns the key and the provider of a provider record
+// datastore key.
+func parseProvKey(dsk string) ([]byte, peer.ID, error) {
+	rest, ok := strings.CutPrefix(dsk, ProvidersKeyPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("not a provider record key: %s", dsk)
+	}
+	enck, encp, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, "", fmt.Errorf("not a provider record key: %s", dsk)
+	}
+	k, err := base32.RawStdEncoding.DecodeString(enck)
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := base32.RawStdEncoding.DecodeString(encp)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, peer.ID(p), nil
+}
+
+// deleteProviderEntry removes a provider record from the datastore along with
+// its peer index entry, and its prefix index entry if byPrefix is set.
+func deleteProviderEntry(ctx context.Context, dstore ds.Datastore, dsk string, byPrefix bool) error {
+	err := dstore.Delete(ctx, ds.RawKey(dsk))
+	if k, p, perr := parseProvKey(dsk); perr == nil {
+		if ierr := dstore.Delete(ctx, ds.RawKey(mkPeerIndexKeyFor(p, k))); err == nil {
+			err = ierr
+		}
+		if byPrefix {
+			if ierr := dstore.Delete(ctx, ds.RawKey(mkPrefixIndexKeyFor(k, p))); err == nil {
+				err = ierr
+			}
+		}
+	}
+	return err
+}
+
+// removeProviderEntry removes a provider record along with its index entries
+// if it exists, and updates the provider record count.
+func (pm *ProviderManager) removeProviderEntry(ctx context.Context, dsk string) error {
+	has, err := pm.dstore.Has(ctx, ds.RawKey(dsk))
+	if err != nil || !has {
+		return err
+	}
+	if err := deleteProviderEntry(ctx, pm.dstore, dsk, pm.indexByPrefix); err != nil {
+		return err
+	}
+	pm.addCount(-1)
+	return nil
+}
+
+// addCount adds delta to the provider record count.
+func (pm *ProviderManager) addCount(delta int64) {
+	if n := pm.count.Add(delta); n < 0 {
+		// can't happen unless the datastore was modified behind our back
+		pm.count.Store(0)
+	}
+}
+
+// storeProviderCount persists the provider record count, once the provider
+// records are all written.
+func storeProviderCount(ctx context.Context, dstore ds.Datastore, n int64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return dstore.Put(ctx, ds.NewKey(providersCountKey), buf[:binary.PutVarint(buf, n)])
+}
+
+// countProviderRecords returns the number of provider records in the
+// datastore, encrypted ones included. The count is only persisted on Close and
+// removed here, so that the records are counted again, without reading their
+// values, if the provider manager wasn't closed cleanly.
+func countProviderRecords(ctx context.Context, dstore ds.Batching) (int64, error) {
+	v, err := dstore.Get(ctx, ds.NewKey(providersCountKey))
+	if err == nil {
+		if err := dstore.Delete(ctx, ds.NewKey(providersCountKey)); err != nil {
+			return 0, err
+		}
+		n, read := binary.Varint(v)
+		if read > 0 && n >= 0 {
+			return n, nil
+		}
+		log.Error("failed to parse provider record count, counting the records")
+	} else if !errors.Is(err, ds.ErrNotFound) {
+		return 0, err
+	}
+
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: ProvidersKeyPrefix, KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	var n int64
+	for e := range res.Next() {
+		if e.Error != nil {
+			return 0, e.Error
+		}
+		if _, _, err := parseProvKey(e.Key); err == nil {
+			n++
+		}
+	}
+
+	res, err = dstore.Query(ctx, dsq.Query{Prefix: EncryptedProvidersKeyPrefix, KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return 0, e.Error
+		}
+		if _, _, err := parseEncryptedProvKey(e.Key); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// indexProviders adds the provider records written before the peer index
+// existed to the index. It only runs once per datastore.
+func indexProviders(ctx context.Context, dstore ds.Batching) error {
+	_, err := dstore.Get(ctx, ds.NewKey(providersIndexedKey))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ds.ErrNotFound) {
+		return err
+	}
+
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: ProvidersKeyPrefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	batch, err := dstore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	indexed := 0
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k, p, err := parseProvKey(e.Key)
+		if err != nil {
+			continue
+		}
+		if err := batch.Put(ctx, ds.RawKey(mkPeerIndexKeyFor(p, k)), nil); err != nil {
+			return err
+		}
+		indexed++
+	}
+	if err := batch.Put(ctx, ds.NewKey(providersIndexedKey), []byte{1}); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	if indexed > 0 {
+		log.Infof("indexed %d provider records by peer", indexed)
+	}
+	return nil
+}
+
+// RemoveProvider removes the provider record of prov for key, if any.
+func (pm *ProviderManager) RemoveProvider(ctx context.Context, k []byte, prov peer.ID) error {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.RemoveProvider")
+	defer span.End()
+
+	rp := &rmProv{
+		ctx:  ctx,
+		key:  k,
+		val:  prov,
+		resp: make(chan error, 1), // buffered to prevent sender from blocking
+	}
+	select {
+	case pm.rmprovs <- rp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-rp.resp:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rmProv removes a provider record from the cache and the datastore.
+func (pm *ProviderManager) rmProv(ctx context.Context, k []byte, p peer.ID) error {
+	if provs, ok := pm.cache.Get(string(k)); ok {
+		provs.(*providerSet).remove(p)
+	}
+	return pm.removeProviderEntry(ctx, mkProvKeyFor(k, p))
+}
+
+// flush writes the pending provider records to the underlying datastore, so
+// that it can be read directly.
+func (pm *ProviderManager) flush(ctx context.Context) error {
+	resp := make(chan error, 1) // buffered to prevent sender from blocking
+	select {
+	case pm.flushes <- resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-resp:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ProvidersByPeer returns the keys prov is currently a provider for.
+func (pm *ProviderManager) ProvidersByPeer(ctx context.Context, prov peer.ID) ([][]byte, error) {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.ProvidersByPeer")
+	defer span.End()
+
+	if err := pm.flush(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := pm.backing.Query(ctx, dsq.Query{Prefix: mkPeerIndexKey(prov), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var keys [][]byte
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		k, err := base32.RawStdEncoding.DecodeString(ds.RawKey(e.Key).Name())
+		if err != nil {
+			continue
+		}
+
+		// the index isn't updated when records expire, only when they are
+		// garbage collected.
+		v, err := pm.backing.Get(ctx, ds.RawKey(mkProvKeyFor(k, prov)))
+		if errors.Is(err, ds.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t, err := readTimeValue(v); err != nil || now.Sub(t) > ProvideValidity {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ForEachProvider calls fn for every unexpired provider record until fn
+// returns false. Records added while iterating may or may not be visited.
+func (pm *ProviderManager) ForEachProvider(ctx context.Context, fn func(ProviderEntry) bool) error {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.ForEachProvider")
+	defer span.End()
+
+	if err := pm.flush(ctx); err != nil {
+		return err
+	}
+
+	res, err := pm.backing.Query(ctx, dsq.Query{Prefix: ProvidersKeyPrefix})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k, p, err := parseProvKey(e.Key)
+		if err != nil {
+			continue
+		}
+		t, err := readTimeValue(e.Value)
+		if err != nil || now.Sub(t) > ProvideValidity {
+			continue
+		}
+		if !fn(ProviderEntry{Key: k, Provider: p, AddedAt: t}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// CountProviders returns the number of provider records held, encrypted ones
+// included. Expired records are counted until they are garbage collected.
+func (pm *ProviderManager) CountProviders(ctx context.Context) (int, error) {
+	// the pending provider records are counted once processed
+	if err := pm.flush(ctx); err != nil {
+		return 0, err
+	}
+	return int(pm.count.Load()), nil
+}
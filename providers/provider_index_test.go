@@ -0,0 +1,272 @@
+package providers
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func sortedKeys(keys [][]byte) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = string(k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestProvidersByPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	a := internal.Hash([]byte("a"))
+	b := internal.Hash([]byte("b"))
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: alice})
+	p.AddProvider(ctx, b, peer.AddrInfo{ID: alice})
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: bob})
+
+	keys, err := p.ProvidersByPeer(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := sortedKeys(keys), sortedKeys([][]byte{a, b}); len(got) != 2 || got[0] != expected[0] || got[1] != expected[1] {
+		t.Fatalf("expected alice to provide %v, got %v", expected, got)
+	}
+
+	n, err := p.CountProviders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 provider records, got %d", n)
+	}
+
+	// make sure the cache is populated before removing
+	if provs, _ := p.GetProviders(ctx, a); len(provs) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(provs))
+	}
+	if err := p.RemoveProvider(ctx, a, alice); err != nil {
+		t.Fatal(err)
+	}
+	provs, _ := p.GetProviders(ctx, a)
+	if len(provs) != 1 || provs[0].ID != bob {
+		t.Fatalf("expected only bob to provide a, got %v", provs)
+	}
+	keys, err = p.ProvidersByPeer(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || string(keys[0]) != string(b) {
+		t.Fatalf("expected alice to only provide b, got %v", keys)
+	}
+
+	var entries []ProviderEntry
+	err = p.ForEachProvider(ctx, func(e ProviderEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 provider records, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.AddedAt.IsZero() {
+			t.Fatal("expected provider record time to be set")
+		}
+	}
+}
+
+func TestProvidersIndexedOnStartup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// provider records written before the index existed
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	a := internal.Hash([]byte("a"))
+	if err := dstore.Put(ctx, ds.NewKey(mkProvKeyFor(a, peer.ID("alice"))), varintTime(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	keys, err := p.ProvidersByPeer(ctx, peer.ID("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || string(keys[0]) != string(a) {
+		t.Fatalf("expected alice to provide a, got %v", keys)
+	}
+	if n, _ := p.CountProviders(ctx); n != 1 {
+		t.Fatalf("expected 1 provider record, got %d", n)
+	}
+}
+
+func TestProvidersByPeerSkipsExpired(t *testing.T) {
+	pval := ProvideValidity
+	ProvideValidity = time.Second / 2
+	defer func() { ProvideValidity = pval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.AddProvider(ctx, internal.Hash([]byte("a")), peer.AddrInfo{ID: peer.ID("alice")})
+	time.Sleep(time.Second)
+
+	keys, err := p.ProvidersByPeer(ctx, peer.ID("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected expired record to be skipped, got %v", keys)
+	}
+	// expired records are counted until they are garbage collected
+	if n, _ := p.CountProviders(ctx); n != 1 {
+		t.Fatalf("expected 1 provider record, got %d", n)
+	}
+}
+
+func TestCountProviders(t *testing.T) {
+	pval := ProvideValidity
+	ProvideValidity = time.Second / 2
+	defer func() { ProvideValidity = pval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := internal.Hash([]byte("a"))
+	b := internal.Hash([]byte("b"))
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("alice")})
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("alice")})
+	// once cached, the provider set tells whether the record exists
+	if _, err := p.GetProviders(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("alice")})
+	p.AddProvider(ctx, b, peer.AddrInfo{ID: peer.ID("alice")})
+	p.AddProvider(ctx, b, peer.AddrInfo{ID: peer.ID("bob")})
+	if err := p.RemoveProvider(ctx, b, peer.ID("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveProvider(ctx, b, peer.ID("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := p.CountProviders(ctx); n != 2 {
+		t.Fatalf("expected 2 provider records, got %d", n)
+	}
+	p.Close()
+
+	// the count is kept in the datastore
+	p, err = NewProviderManager(peer.ID("testing"), ps, dstore, CleanupInterval(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if n, _ := p.CountProviders(ctx); n != 2 {
+		t.Fatalf("expected 2 provider records after restart, got %d", n)
+	}
+
+	// and updated when the records are garbage collected
+	time.Sleep(2 * time.Second)
+	if n, _ := p.CountProviders(ctx); n != 0 {
+		t.Fatalf("expected no provider record after GC, got %d", n)
+	}
+}
+
+func TestCountProvidersAfterUncleanShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddProvider(ctx, internal.Hash([]byte("a")), peer.AddrInfo{ID: peer.ID("alice")})
+	p.Close()
+
+	p, err = NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.AddProvider(ctx, internal.Hash([]byte("b")), peer.AddrInfo{ID: peer.ID("alice")})
+	if n, _ := p.CountProviders(ctx); n != 2 {
+		t.Fatalf("expected 2 provider records, got %d", n)
+	}
+
+	// a record written right before a crash
+	if err := dstore.Put(ctx, ds.NewKey(mkProvKeyFor(internal.Hash([]byte("c")), peer.ID("bob"))), varintTime(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records are counted again if the previous provider manager wasn't
+	// closed
+	p2, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	if n, _ := p2.CountProviders(ctx); n != 3 {
+		t.Fatalf("expected 3 provider records after an unclean shutdown, got %d", n)
+	}
+}
+
+func varintTime(t time.Time) []byte {
+	buf := make([]byte, 16)
+	n := binary.PutVarint(buf, t.UnixNano())
+	return buf[:n]
+}
@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ record.Record = (*ProviderRecord)(nil)
+
+func init() {
+	record.RegisterType(&ProviderRecord{})
+}
+
+// ProviderRecordEnvelopeDomain is the domain string used for provider records
+// contained in an Envelope.
+const ProviderRecordEnvelopeDomain = "libp2p-kad-dht-provider-record"
+
+// ProviderRecordEnvelopePayloadType is the type hint used to identify provider
+// records in an Envelope.
+var ProviderRecordEnvelopePayloadType = []byte("/libp2p/kad-dht/provider-record")
+
+// ProviderRecordMaxClockSkew is how far in the future the timestamp of a
+// provider record is allowed to be, to account for clock differences between
+// peers.
+var ProviderRecordMaxClockSkew = 10 * time.Minute
+
+// ProviderRecord is a statement from a provider that it provides a key and can
+// be reached at the given addresses. It is meant to be shared inside an
+// Envelope signed by the provider, so that anyone can verify the addresses of
+// a provider, not only the peer that received the announcement.
+type ProviderRecord struct {
+	// PeerID is the ID of the provider.
+	PeerID peer.ID
+	// Key is the multihash of the provided key.
+	Key multihash.Multihash
+	// Addrs contains the addresses of the provider.
+	Addrs []ma.Multiaddr
+	// Timestamp is the time at which the record was signed.
+	Timestamp time.Time
+}
+
+// Domain is used when signing and validating ProviderRecords contained in
+// Envelopes.
+func (r *ProviderRecord) Domain() string {
+	return ProviderRecordEnvelopeDomain
+}
+
+// Codec is a binary identifier for the ProviderRecord type.
+func (r *ProviderRecord) Codec() []byte {
+	return ProviderRecordEnvelopePayloadType
+}
+
+// MarshalRecord serializes a ProviderRecord to a byte slice.
+func (r *ProviderRecord) MarshalRecord() ([]byte, error) {
+	addrs := make([][]byte, len(r.Addrs))
+	for i, a := range r.Addrs {
+		addrs[i] = a.Bytes()
+	}
+	return proto.Marshal(&pb.ProviderRecord{
+		PeerId:    []byte(r.PeerID),
+		Key:       r.Key,
+		Addrs:     addrs,
+		Timestamp: r.Timestamp.UnixNano(),
+	})
+}
+
+// UnmarshalRecord parses a ProviderRecord from a byte slice.
+func (r *ProviderRecord) UnmarshalRecord(data []byte) error {
+	var msg pb.ProviderRecord
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	id, err := peer.IDFromBytes(msg.GetPeerId())
+	if err != nil {
+		return err
+	}
+	_, key, err := multihash.MHFromBytes(msg.GetKey())
+	if err != nil {
+		return err
+	}
+	addrs := make([]ma.Multiaddr, 0, len(msg.GetAddrs()))
+	for _, b := range msg.GetAddrs() {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, a)
+	}
+
+	r.PeerID = id
+	r.Key = key
+	r.Addrs = addrs
+	r.Timestamp = time.Unix(0, msg.GetTimestamp())
+	return nil
+}
+
+// SealProviderRecord creates a ProviderRecord stating that prov provides key,
+// signs it with the private key of the provider and returns the serialized
+// Envelope.
+func SealProviderRecord(key multihash.Multihash, prov peer.AddrInfo, sk crypto.PrivKey) ([]byte, error) {
+	rec := &ProviderRecord{
+		PeerID:    prov.ID,
+		Key:       key,
+		Addrs:     prov.Addrs,
+		Timestamp: time.Now(),
+	}
+	env, err := record.Seal(rec, sk)
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+// OpenProviderRecord verifies the signature of a serialized Envelope
+// containing a ProviderRecord for the given key, and returns the record.
+//
+// The record must be signed by the provider itself, and must neither be older
+// than ProvideValidity nor too far in the future.
+func OpenProviderRecord(envelope []byte, key multihash.Multihash) (*ProviderRecord, error) {
+	var rec ProviderRecord
+	env, err := record.ConsumeTypedEnvelope(envelope, &rec)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if signer != rec.PeerID {
+		return nil, fmt.Errorf("provider record for %s signed by %s", rec.PeerID, signer)
+	}
+	if !bytes.Equal(rec.Key, key) {
+		return nil, errors.New("provider record is for a different key")
+	}
+
+	now := time.Now()
+	if now.Sub(rec.Timestamp) > ProvideValidity {
+		return nil, errors.New("provider record expired")
+	}
+	if rec.Timestamp.Sub(now) > ProviderRecordMaxClockSkew {
+		return nil, errors.New("provider record timestamp is in the future")
+	}
+	return &rec, nil
+}
@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func newSigner(t *testing.T) (peer.ID, crypto.PrivKey) {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, sk
+}
+
+func TestProviderRecordSealOpen(t *testing.T) {
+	id, sk := newSigner(t)
+	key := internal.Hash([]byte("test"))
+	prov := peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+
+	envelope, err := SealProviderRecord(key, prov, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := OpenProviderRecord(envelope, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.PeerID != id {
+		t.Fatalf("expected provider %s, got %s", id, rec.PeerID)
+	}
+	if len(rec.Addrs) != 1 || !rec.Addrs[0].Equal(prov.Addrs[0]) {
+		t.Fatalf("unexpected addresses %v", rec.Addrs)
+	}
+
+	if _, err := OpenProviderRecord(envelope, internal.Hash([]byte("other"))); err == nil {
+		t.Fatal("expected record for a different key to be rejected")
+	}
+
+	// a record claiming to be from another peer must be rejected
+	other, _ := newSigner(t)
+	envelope, err = SealProviderRecord(key, peer.AddrInfo{ID: other, Addrs: prov.Addrs}, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenProviderRecord(envelope, key); err == nil {
+		t.Fatal("expected record signed by another peer to be rejected")
+	}
+}
+
+func TestSignedProvidersPersisted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, sk := newSigner(t)
+	key := internal.Hash([]byte("test"))
+	prov := peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	envelope, err := SealProviderRecord(key, prov, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddSignedProvider(ctx, key, prov, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddProvider(ctx, key, peer.AddrInfo{ID: peer.ID("unsigned")}); err != nil {
+		t.Fatal(err)
+	}
+
+	provs, records, err := p.GetSignedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 2 || len(records) != 1 {
+		t.Fatalf("expected 2 providers and 1 record, got %d and %d", len(provs), len(records))
+	}
+	p.Close()
+
+	// a fresh manager must read the records back from the datastore
+	p, err = NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	provs, records, err = p.GetSignedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 2 || len(records) != 1 {
+		t.Fatalf("expected 2 providers and 1 record, got %d and %d", len(provs), len(records))
+	}
+	if _, err := OpenProviderRecord(records[0], key); err != nil {
+		t.Fatal(err)
+	}
+
+	// announcing again without a record drops the previous one
+	if err := p.AddProvider(ctx, key, prov); err != nil {
+		t.Fatal(err)
+	}
+	_, records, err = p.GetSignedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no record, got %d", len(records))
+	}
+}
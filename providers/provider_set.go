@@ -12,6 +12,8 @@ import (
 type providerSet struct {
 	providers []peer.ID
 	set       map[peer.ID]time.Time
+	// signed provider record envelopes, only for the providers that have one
+	records map[peer.ID][]byte
 }
 
 func newProviderSet() *providerSet {
@@ -32,3 +34,46 @@ func (ps *providerSet) setVal(p peer.ID, t time.Time) {
 
 	ps.set[p] = t
 }
+
+// setRecord sets the provider along with the envelope of its signed provider
+// record. A nil envelope removes any previous one.
+func (ps *providerSet) setRecord(p peer.ID, t time.Time, envelope []byte) {
+	ps.setVal(p, t)
+	if envelope == nil {
+		delete(ps.records, p)
+		return
+	}
+	if ps.records == nil {
+		ps.records = make(map[peer.ID][]byte)
+	}
+	ps.records[p] = envelope
+}
+
+// signedRecords returns the signed provider record envelopes of the set.
+func (ps *providerSet) signedRecords() [][]byte {
+	out := make([][]byte, 0, len(ps.records))
+	for _, p := range ps.providers {
+		if env, ok := ps.records[p]; ok {
+			out = append(out, env)
+		}
+	}
+	return out
+}
+
+// remove removes the provider from the set. The providers slice is replaced
+// rather than modified, since it may have been handed out by GetProviders.
+func (ps *providerSet) remove(p peer.ID) {
+	if _, found := ps.set[p]; !found {
+		return
+	}
+	delete(ps.set, p)
+	delete(ps.records, p)
+
+	providers := make([]peer.ID, 0, len(ps.providers)-1)
+	for _, prov := range ps.providers {
+		if prov != p {
+			providers = append(providers, prov)
+		}
+	}
+	ps.providers = providers
+}
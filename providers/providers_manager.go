@@ -8,6 +8,7 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/simplelru"
@@ -52,6 +53,19 @@ type ProviderStore interface {
 	io.Closer
 }
 
+// SignedProviderStore is a ProviderStore that can also keep the signed
+// provider record envelopes received along with provider announcements (see
+// ProviderRecord).
+type SignedProviderStore interface {
+	ProviderStore
+	// AddSignedProvider adds a provider along with the envelope of its signed
+	// provider record. The envelope is expected to have been verified.
+	AddSignedProvider(ctx context.Context, key []byte, prov peer.AddrInfo, envelope []byte) error
+	// GetSignedProviders returns the providers of the key, along with the
+	// signed provider record envelopes known for them.
+	GetSignedProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, [][]byte, error)
+}
+
 // ProviderManager adds and pulls providers out of the datastore,
 // caching them in between
 type ProviderManager struct {
@@ -61,18 +75,30 @@ type ProviderManager struct {
 	cache  lru.LRUCache
 	pstore peerstore.Peerstore
 	dstore *autobatch.Datastore
-
-	newprovs chan *addProv
-	getprovs chan *getProv
+	// backing is the datastore wrapped by dstore, it is only read directly
+	// after flushing dstore.
+	backing ds.Batching
+	// count is the number of provider records in the datastore, it is only
+	// updated within the run method and persisted on Close.
+	count atomic.Int64
+
+	newprovs    chan *addProv
+	getprovs    chan *getProv
+	rmprovs     chan *rmProv
+	rmencrypted chan *rmEncryptedProv
+	flushes     chan chan error
 
 	cleanupInterval time.Duration
+	// indexByPrefix is set if the provider records are indexed by prefix,
+	// see IndexByPrefix.
+	indexByPrefix bool
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
-var _ ProviderStore = (*ProviderManager)(nil)
+var _ SignedProviderStore = (*ProviderManager)(nil)
 
 // Option is a function that sets a provider manager option.
 type Option func(*ProviderManager) error
@@ -105,15 +131,22 @@ func Cache(c lru.LRUCache) Option {
 }
 
 type addProv struct {
-	ctx context.Context
-	key []byte
-	val peer.ID
+	ctx      context.Context
+	key      []byte
+	val      peer.ID
+	envelope []byte
+	// if non nil, an encrypted provider record of the double-hashed key,
+	// published by val.
+	encrypted []byte
 }
 
 type getProv struct {
 	ctx  context.Context
 	key  []byte
 	resp chan []peer.ID
+	// if non nil, the signed provider records of the key are sent on it
+	// along with the response.
+	records chan [][]byte
 }
 
 // NewProviderManager constructor
@@ -122,7 +155,11 @@ func NewProviderManager(local peer.ID, ps peerstore.Peerstore, dstore ds.Batchin
 	pm.self = local
 	pm.getprovs = make(chan *getProv)
 	pm.newprovs = make(chan *addProv)
+	pm.rmprovs = make(chan *rmProv)
+	pm.rmencrypted = make(chan *rmEncryptedProv)
+	pm.flushes = make(chan chan error)
 	pm.pstore = ps
+	pm.backing = dstore
 	pm.dstore = autobatch.NewAutoBatching(dstore, batchBufferSize)
 	cache, err := lru.NewLRU(lruCacheSize, nil)
 	if err != nil {
@@ -133,8 +170,32 @@ func NewProviderManager(local peer.ID, ps peerstore.Peerstore, dstore ds.Batchin
 	if err := pm.applyOptions(opts...); err != nil {
 		return nil, err
 	}
+	if err := indexProviders(context.Background(), dstore); err != nil {
+		return nil, fmt.Errorf("indexing provider records by peer: %w", err)
+	}
+	if !pm.indexByPrefix {
+		// the records added from now on aren't indexed, index them all again
+		// if the index is enabled later
+		if err := dstore.Delete(context.Background(), ds.NewKey(providersPrefixIndexedKey)); err != nil {
+			return nil, err
+		}
+	}
+	n, err := countProviderRecords(context.Background(), dstore)
+	if err != nil {
+		return nil, fmt.Errorf("counting provider records: %w", err)
+	}
+	pm.count.Store(n)
 	pm.ctx, pm.cancel = context.WithCancel(context.Background())
 	pm.run()
+	if pm.indexByPrefix {
+		pm.wg.Add(1)
+		go func() {
+			defer pm.wg.Done()
+			if err := indexProvidersByPrefix(pm.ctx, dstore); err != nil && pm.ctx.Err() == nil {
+				log.Error("failed to index provider records by prefix: ", err)
+			}
+		}()
+	}
 	return pm, nil
 }
 
@@ -153,6 +214,10 @@ func (pm *ProviderManager) run() {
 			}
 			if err := pm.dstore.Flush(context.Background()); err != nil {
 				log.Error("failed to flush datastore: ", err)
+				return
+			}
+			if err := storeProviderCount(context.Background(), pm.backing, pm.count.Load()); err != nil {
+				log.Error("failed to store provider record count: ", err)
 			}
 		}()
 
@@ -162,7 +227,13 @@ func (pm *ProviderManager) run() {
 		for {
 			select {
 			case np := <-pm.newprovs:
-				err := pm.addProv(np.ctx, np.key, np.val)
+				if np.encrypted != nil {
+					if err := pm.addEncryptedProv(np.ctx, np.key, np.val, np.encrypted); err != nil {
+						log.Error("error adding encrypted provider record: ", err)
+					}
+					continue
+				}
+				err := pm.addProv(np.ctx, np.key, np.val, np.envelope)
 				if err != nil {
 					log.Error("error adding new providers: ", err)
 					continue
@@ -172,14 +243,31 @@ func (pm *ProviderManager) run() {
 					// as we've updated it since the GC started.
 					gcSkip[mkProvKeyFor(np.key, np.val)] = struct{}{}
 				}
+			case rp := <-pm.rmprovs:
+				rp.resp <- pm.rmProv(rp.ctx, rp.key, rp.val)
+			case rp := <-pm.rmencrypted:
+				rp.resp <- pm.rmExpiredEncryptedProv(rp.ctx, rp.dsk, rp.now)
+			case resp := <-pm.flushes:
+				resp <- pm.dstore.Flush(pm.ctx)
 			case gp := <-pm.getprovs:
-				provs, err := pm.getProvidersForKey(gp.ctx, gp.key)
+				pset, err := pm.getProviderSetForKey(gp.ctx, gp.key)
 				if err != nil && err != ds.ErrNotFound {
 					log.Error("error reading providers: ", err)
 				}
+				var provs []peer.ID
+				if pset != nil {
+					provs = pset.providers
+				}
 
 				// set the cap so the user can't append to this.
 				gp.resp <- provs[0:len(provs):len(provs)]
+				if gp.records != nil {
+					var records [][]byte
+					if pset != nil {
+						records = pset.signedRecords()
+					}
+					gp.records <- records
+				}
 			case res, ok := <-gcQueryRes:
 				if !ok {
 					gcQuery.Close()
@@ -210,7 +298,8 @@ func (pm *ProviderManager) run() {
 					fallthrough
 				case gcTime.Sub(t) > ProvideValidity:
 					// or expired
-					err = pm.dstore.Delete(pm.ctx, ds.RawKey(res.Key))
+					// the record may have been removed since the GC started
+					err = pm.removeProviderEntry(pm.ctx, res.Key)
 					if err != nil && err != ds.ErrNotFound {
 						log.Error("failed to remove provider record from disk: ", err)
 					}
@@ -223,6 +312,14 @@ func (pm *ProviderManager) run() {
 				// Much faster than GCing.
 				pm.cache.Purge()
 
+				// encrypted provider records are listed concurrently,
+				// they aren't cached.
+				pm.wg.Add(1)
+				go func(now time.Time) {
+					defer pm.wg.Done()
+					pm.gcEncryptedProviders(pm.ctx, now)
+				}(gcTime)
+
 				// Now, kick off a GC of the datastore.
 				q, err := pm.dstore.Query(pm.ctx, dsq.Query{
 					Prefix: ProvidersKeyPrefix,
@@ -252,13 +349,28 @@ func (pm *ProviderManager) AddProvider(ctx context.Context, k []byte, provInfo p
 	ctx, span := internal.StartSpan(ctx, "ProviderManager.AddProvider")
 	defer span.End()
 
+	return pm.addProvider(ctx, k, provInfo, nil)
+}
+
+// AddSignedProvider adds a provider along with the envelope of its signed
+// provider record. Adding the same provider again without an envelope drops
+// the previous envelope.
+func (pm *ProviderManager) AddSignedProvider(ctx context.Context, k []byte, provInfo peer.AddrInfo, envelope []byte) error {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.AddSignedProvider")
+	defer span.End()
+
+	return pm.addProvider(ctx, k, provInfo, envelope)
+}
+
+func (pm *ProviderManager) addProvider(ctx context.Context, k []byte, provInfo peer.AddrInfo, envelope []byte) error {
 	if provInfo.ID != pm.self { // don't add own addrs.
 		pm.pstore.AddAddrs(provInfo.ID, provInfo.Addrs, ProviderAddrTTL)
 	}
 	prov := &addProv{
-		ctx: ctx,
-		key: k,
-		val: provInfo.ID,
+		ctx:      ctx,
+		key:      k,
+		val:      provInfo.ID,
+		envelope: envelope,
 	}
 	select {
 	case pm.newprovs <- prov:
@@ -269,23 +381,46 @@ func (pm *ProviderManager) AddProvider(ctx context.Context, k []byte, provInfo p
 }
 
 // addProv updates the cache if needed
-func (pm *ProviderManager) addProv(ctx context.Context, k []byte, p peer.ID) error {
+func (pm *ProviderManager) addProv(ctx context.Context, k []byte, p peer.ID, envelope []byte) error {
 	now := time.Now()
+	var exists bool
 	if provs, ok := pm.cache.Get(string(k)); ok {
-		provs.(*providerSet).setVal(p, now)
-	} // else not cached, just write through
+		pset := provs.(*providerSet)
+		_, exists = pset.set[p]
+		pset.setRecord(p, now, envelope)
+	} else {
+		// not cached, just write through
+		var err error
+		exists, err = pm.dstore.Has(ctx, ds.NewKey(mkProvKeyFor(k, p)))
+		if err != nil {
+			return err
+		}
+	}
 
-	return writeProviderEntry(ctx, pm.dstore, k, p, now)
+	if err := writeProviderEntry(ctx, pm.dstore, k, p, now, envelope, pm.indexByPrefix); err != nil {
+		return err
+	}
+	if !exists {
+		pm.addCount(1)
+	}
+	return nil
 }
 
-// writeProviderEntry writes the provider into the datastore
-func writeProviderEntry(ctx context.Context, dstore ds.Datastore, k []byte, p peer.ID, t time.Time) error {
+// writeProviderEntry writes the provider into the datastore, and indexes it by
+// peer, and by prefix if byPrefix is set. The value is the time the provider
+// was added, followed by the signed provider record envelope if any.
+func writeProviderEntry(ctx context.Context, dstore ds.Datastore, k []byte, p peer.ID, t time.Time, envelope []byte, byPrefix bool) error {
 	dsk := mkProvKeyFor(k, p)
-
-	buf := make([]byte, 16)
-	n := binary.PutVarint(buf, t.UnixNano())
-
-	return dstore.Put(ctx, ds.NewKey(dsk), buf[:n])
+	if err := dstore.Put(ctx, ds.NewKey(dsk), providerValue(t, envelope)); err != nil {
+		return err
+	}
+	if err := dstore.Put(ctx, ds.NewKey(mkPeerIndexKeyFor(p, k)), nil); err != nil {
+		return err
+	}
+	if !byPrefix {
+		return nil
+	}
+	return dstore.Put(ctx, ds.NewKey(mkPrefixIndexKeyFor(k, p)), nil)
 }
 
 func mkProvKeyFor(k []byte, p peer.ID) string {
@@ -320,12 +455,27 @@ func (pm *ProviderManager) GetProviders(ctx context.Context, k []byte) ([]peer.A
 	}
 }
 
-func (pm *ProviderManager) getProvidersForKey(ctx context.Context, k []byte) ([]peer.ID, error) {
-	pset, err := pm.getProviderSetForKey(ctx, k)
-	if err != nil {
-		return nil, err
+// GetSignedProviders returns the set of providers for the given key, along
+// with the signed provider record envelopes known for them.
+func (pm *ProviderManager) GetSignedProviders(ctx context.Context, k []byte) ([]peer.AddrInfo, [][]byte, error) {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.GetSignedProviders")
+	defer span.End()
+
+	gp := &getProv{
+		ctx:     ctx,
+		key:     k,
+		resp:    make(chan []peer.ID, 1), // buffered to prevent sender from blocking
+		records: make(chan [][]byte, 1),  // buffered to prevent sender from blocking
 	}
-	return pset.providers, nil
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case pm.getprovs <- gp:
+	}
+	// both channels are written to one after the other
+	peers := <-gp.resp
+	records := <-gp.records
+	return peerstoreImpl.PeerInfos(pm.pstore, peers), records, nil
 }
 
 // returns the ProviderSet if it already exists on cache, otherwise loads it from datasatore
@@ -335,10 +485,11 @@ func (pm *ProviderManager) getProviderSetForKey(ctx context.Context, k []byte) (
 		return cached.(*providerSet), nil
 	}
 
-	pset, err := loadProviderSet(ctx, pm.dstore, k)
+	pset, removed, err := loadProviderSet(ctx, pm.dstore, k, pm.indexByPrefix)
 	if err != nil {
 		return nil, err
 	}
+	pm.addCount(-int64(removed))
 
 	if len(pset.providers) > 0 {
 		pm.cache.Add(string(k), pset)
@@ -347,16 +498,18 @@ func (pm *ProviderManager) getProviderSetForKey(ctx context.Context, k []byte) (
 	return pset, nil
 }
 
-// loads the ProviderSet out of the datastore
-func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte) (*providerSet, error) {
+// loads the ProviderSet out of the datastore, removing the expired records.
+// Returns the number of records removed.
+func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte, byPrefix bool) (*providerSet, int, error) {
 	res, err := dstore.Query(ctx, dsq.Query{Prefix: mkProvKey(k)})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer res.Close()
 
 	now := time.Now()
 	out := newProviderSet()
+	removed := 0
 	for {
 		e, ok := res.NextSync()
 		if !ok {
@@ -368,7 +521,7 @@ func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte) (*provi
 		}
 
 		// check expiration time
-		t, err := readTimeValue(e.Value)
+		t, envelope, err := readProviderValue(e.Value)
 		switch {
 		case err != nil:
 			// couldn't parse the time
@@ -376,9 +529,11 @@ func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte) (*provi
 			fallthrough
 		case now.Sub(t) > ProvideValidity:
 			// or just expired
-			err = dstore.Delete(ctx, ds.RawKey(e.Key))
+			err = deleteProviderEntry(ctx, dstore, e.Key, byPrefix)
 			if err != nil && err != ds.ErrNotFound {
 				log.Error("failed to remove provider record from disk: ", err)
+			} else {
+				removed++
 			}
 			continue
 		}
@@ -388,26 +543,48 @@ func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte) (*provi
 		decstr, err := base32.RawStdEncoding.DecodeString(e.Key[lix+1:])
 		if err != nil {
 			log.Error("base32 decoding error: ", err)
-			err = dstore.Delete(ctx, ds.RawKey(e.Key))
+			err = deleteProviderEntry(ctx, dstore, e.Key, byPrefix)
 			if err != nil && err != ds.ErrNotFound {
 				log.Error("failed to remove provider record from disk: ", err)
+			} else {
+				removed++
 			}
 			continue
 		}
 
 		pid := peer.ID(decstr)
 
-		out.setVal(pid, t)
+		out.setRecord(pid, t, envelope)
 	}
 
-	return out, nil
+	return out, removed, nil
+}
+
+// providerValue returns a provider entry value, the time followed by an
+// optional payload.
+func providerValue(t time.Time, payload []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(payload))
+	n := binary.PutVarint(buf, t.UnixNano())
+	n += copy(buf[n:], payload)
+	return buf[:n]
 }
 
 func readTimeValue(data []byte) (time.Time, error) {
+	t, _, err := readProviderValue(data)
+	return t, err
+}
+
+// readProviderValue parses a provider entry value, returning the time the
+// provider was added and the envelope of its signed provider record if any.
+func readProviderValue(data []byte) (time.Time, []byte, error) {
 	nsec, n := binary.Varint(data)
 	if n <= 0 {
-		return time.Time{}, errors.New("failed to parse time")
+		return time.Time{}, nil, errors.New("failed to parse time")
 	}
 
-	return time.Unix(0, nsec), nil
+	var envelope []byte
+	if len(data) > n {
+		envelope = data[n:]
+	}
+	return time.Unix(0, nsec), envelope, nil
 }
@@ -111,17 +111,17 @@ func TestProvidersSerialization(t *testing.T) {
 	pt1 := time.Now()
 	pt2 := pt1.Add(time.Hour)
 
-	err := writeProviderEntry(context.Background(), dstore, k, p1, pt1)
+	err := writeProviderEntry(context.Background(), dstore, k, p1, pt1, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = writeProviderEntry(context.Background(), dstore, k, p2, pt2)
+	err = writeProviderEntry(context.Background(), dstore, k, p2, pt2, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pset, err := loadProviderSet(context.Background(), dstore, k)
+	pset, _, err := loadProviderSet(context.Background(), dstore, k, false)
 	if err != nil {
 		t.Fatal(err)
 	}
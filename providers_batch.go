@@ -0,0 +1,213 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// providersByKey collects up to count providers per key, it is safe for
+// concurrent use.
+type providersByKey struct {
+	mu    sync.Mutex
+	count int
+	provs map[string][]peer.AddrInfo
+}
+
+func newProvidersByKey(count int) *providersByKey {
+	return &providersByKey{count: count, provs: make(map[string][]peer.AddrInfo)}
+}
+
+// add adds prov to the providers of key, unless it is already known or enough
+// providers were found.
+func (pk *providersByKey) add(key string, prov peer.AddrInfo) {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	provs := pk.provs[key]
+	if len(provs) >= pk.count {
+		return
+	}
+	for _, p := range provs {
+		if p.ID == prov.ID {
+			return
+		}
+	}
+	pk.provs[key] = append(provs, prov)
+}
+
+func (pk *providersByKey) len(key string) int {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	return len(pk.provs[key])
+}
+
+func (pk *providersByKey) get(key string) []peer.AddrInfo {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	return pk.provs[key]
+}
+
+// FindProvidersMany looks for the providers of several keys at once, up to
+// the bucket size for each key like FindProviders. Keys are grouped by the
+// closest peers to them in the routing table, and each of these peers is asked
+// for the providers of all its keys with GET_PROVIDERS_BATCH requests. The keys
+// no provider was found for this way are then looked up individually.
+//
+// When private or prefix lookups are enabled, all keys are looked up
+// individually so that the peers queried don't learn them.
+func (dht *IpfsDHT) FindProvidersMany(ctx context.Context, keys []cid.Cid) (map[cid.Cid][]peer.AddrInfo, error) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.FindProvidersMany", trace.WithAttributes(attribute.Int("keys", len(keys))))
+	defer span.End()
+	ctx = ContextWithOperation(ctx, OperationFindProviders)
+
+	if !dht.enableProviders {
+		return nil, routing.ErrNotSupported
+	}
+	var mhs []multihash.Multihash
+	seen := make(map[string]struct{}, len(keys))
+	for _, c := range keys {
+		if !c.Defined() {
+			return nil, errors.New("invalid cid: undefined")
+		}
+		if _, ok := seen[string(c.Hash())]; !ok {
+			seen[string(c.Hash())] = struct{}{}
+			mhs = append(mhs, c.Hash())
+		}
+	}
+
+	found := newProvidersByKey(dht.bucketSize)
+	if !dht.privateLookups && dht.prefixLookupBits == 0 {
+		for _, mh := range mhs {
+			provs, records, err := dht.getSignedProviders(ctx, mh)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range dht.verifyProviders(mh, provs, records) {
+				found.add(string(mh), p)
+			}
+		}
+		dht.findProvidersBatched(ctx, mhs, found)
+	}
+
+	// look up the keys still missing providers one by one, at most alpha at
+	// a time as each lookup is already concurrent
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, dht.alpha)
+	for _, c := range keys {
+		if _, ok := seen[string(c.Hash())]; !ok || found.len(string(c.Hash())) > 0 {
+			continue
+		}
+		delete(seen, string(c.Hash()))
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(c cid.Cid) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for p := range dht.FindProvidersAsync(ctx, c, dht.bucketSize) {
+				found.add(string(c.Hash()), p)
+			}
+		}(c)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	out := make(map[cid.Cid][]peer.AddrInfo, len(keys))
+	for _, c := range keys {
+		out[c] = found.get(string(c.Hash()))
+	}
+	return out, nil
+}
+
+// findProvidersBatched asks the closest peers to each key in the routing
+// table for its providers, batching the keys sharing the same peers.
+func (dht *IpfsDHT) findProvidersBatched(ctx context.Context, keys []multihash.Multihash, found *providersByKey) {
+	keysPerPeer := make(map[peer.ID][]multihash.Multihash)
+	for _, k := range keys {
+		if found.len(string(k)) >= dht.bucketSize {
+			continue
+		}
+		for _, p := range dht.routingTable.NearestPeers(kb.ConvertKey(string(k)), dht.bucketSize) {
+			keysPerPeer[p] = append(keysPerPeer[p], k)
+		}
+	}
+	logger.Debugw("finding providers in batches", "keys", len(keys), "peers", len(keysPerPeer))
+
+	peersCh := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for range dht.alpha {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range peersCh {
+				dht.getProvidersBatch(ctx, p, keysPerPeer[p], found)
+			}
+		}()
+	}
+	for p := range keysPerPeer {
+		select {
+		case peersCh <- p:
+		case <-ctx.Done():
+		}
+	}
+	close(peersCh)
+	wg.Wait()
+}
+
+// getProvidersBatch asks p for the providers of the keys still missing some.
+func (dht *IpfsDHT) getProvidersBatch(ctx context.Context, p peer.ID, keys []multihash.Multihash, found *providersByKey) {
+	if ctx.Err() != nil {
+		return
+	}
+	pending := make(map[string]multihash.Multihash, len(keys))
+	var batch []multihash.Multihash
+	for _, k := range keys {
+		if found.len(string(k)) < dht.bucketSize {
+			pending[string(k)] = k
+			batch = append(batch, k)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	keyProvs, err := dht.protoMessenger.GetProvidersBatch(ctx, p, batch)
+	if err != nil {
+		logger.Debugw("failed to get providers batch", "peer", p, "keys", len(batch), "error", err)
+		return
+	}
+	for _, kp := range keyProvs {
+		// ignore the keys that weren't asked for
+		key, ok := pending[string(kp.GetKey())]
+		if !ok {
+			continue
+		}
+		unverified := make([]peer.AddrInfo, 0, len(kp.GetProviders()))
+		for _, prov := range pb.PBPeersToPeerInfos(kp.GetProviders()) {
+			unverified = append(unverified, *prov)
+		}
+		for _, prov := range dht.verifyProviders(key, unverified, kp.GetSignedProviderRecords()) {
+			dht.maybeAddAddrs(prov.ID, prov.Addrs, peerstore.TempAddrTTL)
+			found.add(string(key), prov)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libp2p/go-libp2p-kad-dht/amino"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func TestFindProvidersMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provided := testCaseCids[:3]
+	missing := testCaseCids[3]
+
+	var batches, single, singleProvided atomic.Int32
+	countRequests := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		switch req.GetType() {
+		case pb.Message_GET_PROVIDERS_BATCH:
+			batches.Add(1)
+		case pb.Message_GET_PROVIDERS:
+			single.Add(1)
+			for _, c := range provided {
+				if bytes.Equal(req.GetKey(), c.Hash()) {
+					singleProvided.Add(1)
+				}
+			}
+		}
+	})
+
+	servers := setupDHTS(t, ctx, 3, countRequests)
+	provider := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false)
+
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[0], servers[2])
+	connect(t, ctx, servers[1], servers[2])
+	for _, d := range []*IpfsDHT{provider, client} {
+		for _, s := range servers {
+			connect(t, ctx, d, s)
+		}
+	}
+
+	for _, c := range provided {
+		require.NoError(t, provider.Provide(ctx, c, true))
+	}
+	for _, c := range provided {
+		waitForProviders(t, servers, c.Hash(), storesProviders(1))
+	}
+
+	ctxT, cancelT := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelT()
+	res, err := client.FindProvidersMany(ctxT, testCaseCids[:4])
+	require.NoError(t, err)
+	for _, c := range provided {
+		require.Len(t, res[c], 1)
+		require.Equal(t, provider.self, res[c][0].ID)
+	}
+	require.Empty(t, res[missing])
+
+	// one batch per server, the missing key is then looked up individually
+	require.EqualValues(t, len(servers), batches.Load())
+	require.NotZero(t, single.Load())
+	require.Zero(t, singleProvided.Load())
+}
+
+func TestGetProvidersBatchLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+
+	pmes := pb.NewMessage(pb.Message_GET_PROVIDERS_BATCH, nil, 0)
+	for _, c := range testCaseCids[:amino.MaxProvidersBatchSize+1] {
+		pmes.Keys = append(pmes.Keys, c.Hash())
+	}
+	_, err := d.handleGetProvidersBatch(ctx, "", pmes)
+	require.Error(t, err)
+
+	pmes.Keys = pmes.Keys[:amino.MaxProvidersBatchSize]
+	resp, err := d.handleGetProvidersBatch(ctx, "", pmes)
+	require.NoError(t, err)
+	require.Empty(t, resp.GetKeyProviders())
+}
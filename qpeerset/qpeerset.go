@@ -67,6 +67,16 @@ func NewQueryPeerset(key string) *QueryPeerset {
 	}
 }
 
+// NewQueryPeersetForKadID creates a new empty set of peers for a lookup
+// targeting the Kademlia ID id, rather than the ID of a key.
+func NewQueryPeersetForKadID(id []byte) *QueryPeerset {
+	return &QueryPeerset{
+		key:    ks.Key{Space: ks.XORKeySpace, Original: id, Bytes: id},
+		all:    []queryPeerState{},
+		sorted: false,
+	}
+}
+
 func (qp *QueryPeerset) find(p peer.ID) int {
 	for i := range qp.all {
 		if qp.all[i].id == p {
@@ -121,6 +131,19 @@ func (qp *QueryPeerset) GetReferrer(p peer.ID) peer.ID {
 	return qp.all[qp.find(p)].referredBy
 }
 
+// GetReferralDepth returns the number of peers on the referral path from the
+// lookup initiator to p, p included. It is 1 for the peers the lookup was
+// seeded with, and 0 for unknown peers.
+func (qp *QueryPeerset) GetReferralDepth(p peer.ID) int {
+	depth := 0
+	// referrers are always added before the peers they refer, so the path
+	// can't loop.
+	for i := qp.find(p); i >= 0; i = qp.find(qp.all[i].referredBy) {
+		depth++
+	}
+	return depth
+}
+
 // GetClosestNInStates returns the closest to the key peers, which are in one of the given states.
 // It returns n peers or less, if fewer peers meet the condition.
 // The returned peers are sorted in ascending order by their distance to the key.
@@ -84,3 +84,22 @@ func TestQPeerSet(t *testing.T) {
 	require.Equal(t, []peer.ID{peer3, peer1}, qp.GetClosestInStates(PeerHeard))
 	require.Equal(t, 2, qp.NumHeard())
 }
+
+func TestQPeerSetReferralDepth(t *testing.T) {
+	qp := NewQueryPeerset("test")
+	self := test.RandPeerIDFatal(t)
+	seed := test.RandPeerIDFatal(t)
+	hop := test.RandPeerIDFatal(t)
+	next := test.RandPeerIDFatal(t)
+
+	require.True(t, qp.TryAdd(seed, self))
+	require.True(t, qp.TryAdd(hop, seed))
+	require.True(t, qp.TryAdd(next, hop))
+	// only the first referrer is kept
+	require.False(t, qp.TryAdd(next, seed))
+
+	require.Equal(t, 1, qp.GetReferralDepth(seed))
+	require.Equal(t, 2, qp.GetReferralDepth(hop))
+	require.Equal(t, 3, qp.GetReferralDepth(next))
+	require.Equal(t, 0, qp.GetReferralDepth(self))
+}
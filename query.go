@@ -17,6 +17,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
 	kb "github.com/libp2p/go-libp2p-kbucket"
 )
@@ -29,6 +30,28 @@ type (
 	stopFn  func(*qpeerset.QueryPeerset) bool
 )
 
+// lookupHopKey is the context key of the lookupHop passed to query functions.
+type lookupHopKey struct{}
+
+// lookupHop describes the position of a query function call within its lookup.
+type lookupHop struct {
+	// depth is the number of peers on the referral path from this node to
+	// the peer queried, 1 for the peers the lookup was seeded with.
+	depth int
+	// lookupStart is the time the lookup started.
+	lookupStart time.Time
+}
+
+func withLookupHop(ctx context.Context, hop lookupHop) context.Context {
+	return context.WithValue(ctx, lookupHopKey{}, hop)
+}
+
+// lookupHopFromContext returns the lookupHop of a query function call.
+func lookupHopFromContext(ctx context.Context) (lookupHop, bool) {
+	hop, ok := ctx.Value(lookupHopKey{}).(lookupHop)
+	return hop, ok
+}
+
 // query represents a single DHT query.
 type query struct {
 	// unique identifier for the lookup instance
@@ -37,9 +60,15 @@ type query struct {
 	// target key for the lookup
 	key string
 
+	// Kademlia ID the lookup walks towards
+	targetKadID kb.ID
+
 	// the query context.
 	ctx context.Context
 
+	// the time the query started
+	start time.Time
+
 	dht *IpfsDHT
 
 	// seedPeers is the set of peers that seed the query
@@ -57,6 +86,20 @@ type query struct {
 	// queryPeers is the set of peers known by this query and their respective states.
 	queryPeers *qpeerset.QueryPeerset
 
+	// slowPeers are the waiting peers answering slower than expected, they
+	// don't count towards the concurrency limit.
+	slowPeers map[peer.ID]struct{}
+
+	// hedgeOf maps the peers queried in place of a slow peer to it, and
+	// hedgedBy the slow peers to the peer queried in their place.
+	hedgeOf, hedgedBy map[peer.ID]peer.ID
+
+	// path is the index of the query among the disjoint paths of the lookup,
+	// and claims tells which path each peer belongs to, nil if the lookup
+	// runs a single path.
+	path   int
+	claims *pathClaims
+
 	// terminated is set when the first worker thread encounters the termination condition.
 	// Its role is to make sure that once termination is determined, it is sticky.
 	terminated bool
@@ -89,11 +132,19 @@ type lookupWithFollowupResult struct {
 // After the lookup is complete the query function is run (unless stopped) against all of the top K peers from the
 // lookup that have not already been successfully queried.
 func (dht *IpfsDHT) runLookupWithFollowup(ctx context.Context, target string, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, error) {
+	return dht.runLookupWithFollowupToKadID(ctx, target, kb.ConvertKey(target), queryFn, stopFn)
+}
+
+// runLookupWithFollowupToKadID is like runLookupWithFollowup, but walks towards targetKadID rather than towards the
+// Kademlia ID of target. It is used by lookups that must not reveal target to the peers queried.
+func (dht *IpfsDHT) runLookupWithFollowupToKadID(ctx context.Context, target string, targetKadID kb.ID, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, error) {
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.RunLookupWithFollowup", trace.WithAttributes(internal.KeyAsAttribute("Target", target)))
 	defer span.End()
 
+	lookupStart := time.Now()
+
 	// run the query
-	lookupRes, qps, err := dht.runQuery(ctx, target, queryFn, stopFn)
+	lookupRes, qps, err := dht.runQuery(ctx, target, targetKadID, queryFn, stopFn)
 	if err != nil {
 		return nil, err
 	}
@@ -124,8 +175,9 @@ func (dht *IpfsDHT) runLookupWithFollowup(ctx context.Context, target string, qu
 	defer cancelFollowUp()
 	for _, p := range queryPeers {
 		qp := p
+		hopCtx := withLookupHop(followUpCtx, lookupHop{depth: qps.GetReferralDepth(qp), lookupStart: lookupStart})
 		go func() {
-			_, _ = queryFn(followUpCtx, qp)
+			_, _ = queryFn(hopCtx, qp)
 			doneCh <- struct{}{}
 		}()
 	}
@@ -160,13 +212,12 @@ processFollowUp:
 	return lookupRes, nil
 }
 
-func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, *qpeerset.QueryPeerset, error) {
+func (dht *IpfsDHT) runQuery(ctx context.Context, target string, targetKadID kb.ID, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, *qpeerset.QueryPeerset, error) {
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.RunQuery")
 	defer span.End()
 
 	// pick the K closest peers to the key in our Routing table.
-	targetKadID := kb.ConvertKey(target)
-	seedPeers := dht.routingTable.NearestPeers(targetKadID, dht.bucketSize)
+	seedPeers := dht.seedPeers(targetKadID, dht.bucketSize)
 	if len(seedPeers) == 0 {
 		routing.PublishQueryEvent(ctx, &routing.QueryEvent{
 			Type:  routing.QueryError,
@@ -183,29 +234,76 @@ func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn
 		}
 	}
 
-	q := &query{
-		id:                 uuid.New(),
-		key:                target,
-		ctx:                ctx,
-		dht:                dht,
-		queryPeers:         qpeerset.NewQueryPeerset(target),
-		maxPeersPerIPGroup: maxPeersPerIPGroup,
-		seedPeers:          seedPeers,
-		peerTimes:          make(map[peer.ID]time.Duration),
-		terminated:         false,
-		queryFn:            queryFn,
-		stopFn:             stopFn,
+	// split the seed peers between the disjoint paths, if any
+	paths := dht.splitSeedPeers(seedPeers)
+	var claims *pathClaims
+	if len(paths) > 1 {
+		claims = newPathClaims(paths)
+	}
+
+	start := time.Now()
+	queries := make([]*query, len(paths))
+	for i, seeds := range paths {
+		queries[i] = &query{
+			id:                 uuid.New(),
+			key:                target,
+			targetKadID:        targetKadID,
+			ctx:                ctx,
+			start:              start,
+			dht:                dht,
+			queryPeers:         qpeerset.NewQueryPeersetForKadID(targetKadID),
+			maxPeersPerIPGroup: maxPeersPerIPGroup,
+			seedPeers:          seeds,
+			peerTimes:          make(map[peer.ID]time.Duration),
+			slowPeers:          make(map[peer.ID]struct{}),
+			hedgeOf:            make(map[peer.ID]peer.ID),
+			hedgedBy:           make(map[peer.ID]peer.ID),
+			path:               i,
+			claims:             claims,
+			terminated:         false,
+			queryFn:            queryFn,
+			stopFn:             stopFn,
+		}
 	}
 
+	ids := make([]uuid.UUID, len(queries))
+	for i, q := range queries {
+		ids[i] = q.id
+	}
+	defer dht.inFlight.add(InFlightQuery{
+		IDs:       ids,
+		Key:       target,
+		Operation: operationFromContext(ctx),
+		StartedAt: start,
+	})()
+
 	// run the query
-	q.run()
+	if len(queries) == 1 {
+		queries[0].run()
+	} else {
+		var wg sync.WaitGroup
+		for _, q := range queries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.run()
+			}()
+		}
+		wg.Wait()
+	}
 
 	if ctx.Err() == nil {
-		q.recordValuablePeers()
+		for _, q := range queries {
+			q.recordValuablePeers()
+		}
 	}
 
-	res := q.constructLookupResult()
-	return res, q.queryPeers, nil
+	if len(queries) == 1 {
+		q := queries[0]
+		return q.constructLookupResult(), q.queryPeers, nil
+	}
+	res, qps := dht.mergePaths(queries)
+	return res, qps, nil
 }
 
 func (q *query) recordPeerIsValuable(p peer.ID) {
@@ -241,12 +339,16 @@ func (q *query) constructLookupResult() *lookupWithFollowupResult {
 	// isLookupTermination) is not possible in small networks. Starvation is a
 	// successful query termination in small networks.
 	completed := q.isLookupTermination() || q.isStarvationTermination()
+	return newLookupResult(q.queryPeers, q.dht.bucketSize, completed)
+}
 
+// newLookupResult builds the result of a lookup from the peers it knows.
+func newLookupResult(qps *qpeerset.QueryPeerset, bucketSize int, completed bool) *lookupWithFollowupResult {
 	// extract the top K not unreachable peers
-	peers := q.queryPeers.GetClosestNInStates(q.dht.bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
+	peers := qps.GetClosestNInStates(bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
 
 	// get the top K overall peers (including unreachable)
-	closest := q.queryPeers.GetClosestNInStates(q.dht.bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried, qpeerset.PeerUnreachable)
+	closest := qps.GetClosestNInStates(bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried, qpeerset.PeerUnreachable)
 
 	// return the top K not unreachable peers as well as their states at the end of the query
 	res := &lookupWithFollowupResult{
@@ -257,7 +359,7 @@ func (q *query) constructLookupResult() *lookupWithFollowupResult {
 	}
 
 	for i, p := range peers {
-		res.state[i] = q.queryPeers.GetState(p)
+		res.state[i] = qps.GetState(p)
 	}
 
 	return res
@@ -268,6 +370,8 @@ type queryUpdate struct {
 	queried     []peer.ID
 	heard       []peer.ID
 	unreachable []peer.ID
+	err         error // the error that made cause unreachable
+	slow        bool  // cause is still waiting, but slower than expected
 
 	queryDuration time.Duration
 }
@@ -296,9 +400,11 @@ func (q *query) run() {
 			q.terminate(pathCtx, cancelPath, LookupCancelled)
 		}
 
-		// calculate the maximum number of queries we could be spawning.
+		// calculate the maximum number of queries we could be spawning, the
+		// queries beyond the concurrency limit hedge the slow peers.
 		// Note: NumWaiting will be updated in spawnQuery
-		maxNumQueriesToSpawn := alpha - q.queryPeers.NumWaiting()
+		freeSlots := alpha - q.queryPeers.NumWaiting()
+		maxNumQueriesToSpawn := freeSlots + q.extraSlots()
 
 		// termination is triggered on end-of-lookup conditions or starvation of unused peers
 		// it also returns the peers we should query next for a maximum of `maxNumQueriesToSpawn` peers.
@@ -312,12 +418,23 @@ func (q *query) run() {
 		}
 
 		// try spawning the queries, if there are no available peers to query then we won't spawn them
-		for _, p := range qPeers {
+		for i, p := range qPeers {
 			q.spawnQuery(pathCtx, cause, p, ch)
+			if i >= freeSlots {
+				q.startHedge(p)
+			}
 		}
 	}
 }
 
+// newLookupEvent creates a LookupEvent of this query.
+func (q *query) newLookupEvent(request, response *LookupUpdateEvent, terminate *LookupTerminateEvent) *LookupEvent {
+	ev := NewLookupEvent(q.dht.self, q.id, q.key, request, response, terminate)
+	// the target may not be the Kademlia ID of the key, see runQuery
+	ev.Key.Kad = q.targetKadID
+	return ev
+}
+
 // spawnQuery starts one query, if an available heard peer is found
 func (q *query) spawnQuery(ctx context.Context, cause peer.ID, queryPeer peer.ID, ch chan<- *queryUpdate) {
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.SpawnQuery", trace.WithAttributes(
@@ -327,10 +444,7 @@ func (q *query) spawnQuery(ctx context.Context, cause peer.ID, queryPeer peer.ID
 	defer span.End()
 
 	PublishLookupEvent(ctx,
-		NewLookupEvent(
-			q.dht.self,
-			q.id,
-			q.key,
+		q.newLookupEvent(
 			NewLookupUpdateEvent(
 				cause,
 				q.queryPeers.GetReferrer(queryPeer),
@@ -344,8 +458,9 @@ func (q *query) spawnQuery(ctx context.Context, cause peer.ID, queryPeer peer.ID
 		),
 	)
 	q.queryPeers.SetState(queryPeer, qpeerset.PeerWaiting)
+	hop := lookupHop{depth: q.queryPeers.GetReferralDepth(queryPeer), lookupStart: q.start}
 	q.waitGroup.Add(1)
-	go q.queryPeer(ctx, ch, queryPeer)
+	go q.queryPeer(ctx, ch, queryPeer, hop)
 }
 
 func (q *query) isReadyToTerminate(nPeersToQuery int) (bool, LookupTerminationReason, []peer.ID) {
@@ -391,47 +506,55 @@ func (q *query) terminate(ctx context.Context, cancel context.CancelFunc, reason
 	}
 
 	PublishLookupEvent(ctx,
-		NewLookupEvent(
-			q.dht.self,
-			q.id,
-			q.key,
+		q.newLookupEvent(
 			nil,
 			nil,
 			NewLookupTerminateEvent(reason),
 		),
 	)
+	q.cancelHedges(ctx)
 	cancel() // abort outstanding queries
 	q.terminated = true
 }
 
 // queryPeer queries a single peer and reports its findings on the channel.
 // queryPeer does not access the query state in queryPeers!
-func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID) {
+func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID, hop lookupHop) {
 	defer q.waitGroup.Done()
 
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.QueryPeer")
 	defer span.End()
 
-	dialCtx, queryCtx := ctx, q.ctx
+	dialCtx, queryCtx := ctx, withLookupHop(q.ctx, hop)
 
 	// dial the peer
 	if err := q.dht.dialPeer(dialCtx, p); err != nil {
 		// remove the peer if there was a dial failure..but not because of a context cancellation
 		if dialCtx.Err() == nil {
 			q.dht.peerStoppedDHT(p)
+			q.dht.invalidateCachedLookups(p)
 		}
-		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}}
+		sendQueryUpdate(ctx, ch, &queryUpdate{cause: p, unreachable: []peer.ID{p}, err: err})
 		return
 	}
 
+	// let the lookup query another peer if this one is slower than expected
+	if delay, ok := q.slowDelay(p); ok {
+		timer := time.AfterFunc(delay, func() {
+			sendQueryUpdate(ctx, ch, &queryUpdate{cause: p, slow: true})
+		})
+		defer timer.Stop()
+	}
+
 	startQuery := time.Now()
 	// send query RPC to the remote peer
 	newPeers, err := q.queryFn(queryCtx, p)
 	if err != nil {
 		if queryCtx.Err() == nil {
 			q.dht.peerStoppedDHT(p)
+			q.dht.invalidateCachedLookups(p)
 		}
-		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}}
+		sendQueryUpdate(ctx, ch, &queryUpdate{cause: p, unreachable: []peer.ID{p}, err: err})
 		return
 	}
 
@@ -466,43 +589,82 @@ func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID
 		}
 	}
 
-	ch <- &queryUpdate{cause: p, heard: saw, queried: []peer.ID{p}, queryDuration: queryDuration}
+	sendQueryUpdate(ctx, ch, &queryUpdate{cause: p, heard: saw, queried: []peer.ID{p}, queryDuration: queryDuration})
+}
+
+// sendQueryUpdate reports up to the query, unless the query path is done. As
+// slow peers don't count towards the concurrency limit, more updates than the
+// channel can buffer may be sent after the query terminated.
+func sendQueryUpdate(ctx context.Context, ch chan<- *queryUpdate, up *queryUpdate) {
+	select {
+	case ch <- up:
+	case <-ctx.Done():
+	}
+}
+
+// slowPeerDelay returns how long a lookup waits for p to answer before
+// considering it slow, false if it is never considered slow.
+func (dht *IpfsDHT) slowPeerDelay(p peer.ID) (time.Duration, bool) {
+	if dht.slowPeerMinDelay == 0 {
+		return 0, false
+	}
+	ms, ok := dht.msgSender.(pb.MessageSenderWithLatency)
+	if !ok {
+		return 0, false
+	}
+	expected, ok := ms.ExpectedLatency(p)
+	if !ok {
+		return 0, false
+	}
+	return max(expected, dht.slowPeerMinDelay), true
 }
 
 func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 	if q.terminated {
 		panic("update should not be invoked after the logical lookup termination")
 	}
-	PublishLookupEvent(ctx,
-		NewLookupEvent(
-			q.dht.self,
-			q.id,
-			q.key,
-			nil,
-			NewLookupUpdateEvent(
-				up.cause,
-				up.cause,
-				up.heard,       // heard
-				nil,            // waiting
-				up.queried,     // queried
-				up.unreachable, // unreachable
-			),
-			nil,
-		),
+	if up.slow {
+		// the response may have been handled already
+		if q.queryPeers.GetState(up.cause) == qpeerset.PeerWaiting {
+			logger.Debugw("peer slower than expected, querying another one", "peer", up.cause)
+			q.slowPeers[up.cause] = struct{}{}
+		}
+		return
+	}
+	response := NewLookupUpdateEvent(
+		up.cause,
+		up.cause,
+		up.heard,       // heard
+		nil,            // waiting
+		up.queried,     // queried
+		up.unreachable, // unreachable
 	)
+	if up.err != nil {
+		response.Error = up.err.Error()
+	}
+	PublishLookupEvent(ctx, q.newLookupEvent(nil, response, nil))
 	for _, p := range up.heard {
 		if p == q.dht.self { // don't add self.
 			continue
 		}
+		// don't add the peers of the other disjoint paths
+		if q.claims != nil && !q.claims.claim(p, q.path) {
+			continue
+		}
 		q.queryPeers.TryAdd(p, up.cause)
 	}
 	for _, p := range up.queried {
 		if p == q.dht.self { // don't add self.
 			continue
 		}
+		q.resolveHedge(ctx, p, true)
+		delete(q.slowPeers, p)
 		if st := q.queryPeers.GetState(p); st == qpeerset.PeerWaiting {
 			q.queryPeers.SetState(p, qpeerset.PeerQueried)
 			q.peerTimes[p] = up.queryDuration
+			if q.dht.hedgePercentile != 0 {
+				q.dht.queryLatencies.record(up.queryDuration)
+			}
 		} else {
 			panic(fmt.Errorf("kademlia protocol error: tried to transition to the queried state from state %v", st))
 		}
@@ -511,7 +673,8 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 		if p == q.dht.self { // don't add self.
 			continue
 		}
-
+		q.resolveHedge(ctx, p, false)
+		delete(q.slowPeers, p)
 		if st := q.queryPeers.GetState(p); st == qpeerset.PeerWaiting {
 			q.queryPeers.SetState(p, qpeerset.PeerUnreachable)
 		} else {
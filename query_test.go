@@ -3,10 +3,16 @@ package dht
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	tu "github.com/libp2p/go-libp2p-testing/etc"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 
 	"github.com/stretchr/testify/require"
 )
@@ -111,8 +117,176 @@ func TestRTAdditionOnSuccessfulQuery(t *testing.T) {
 	}))
 }
 
+// slowFindNodeHook returns a request hook delaying the FIND_NODE requests of
+// the peers for which slow returns true, along with the maximum number of
+// these requests handled concurrently.
+func slowFindNodeHook(delay time.Duration, slow func(from peer.ID) bool) (Option, *atomic.Int32) {
+	var inFlight, maxInFlight atomic.Int32
+	hook := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() != pb.Message_FIND_NODE || !slow(s.Conn().RemotePeer()) {
+			return
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		time.Sleep(delay)
+	})
+	return hook, &maxInFlight
+}
+
+func TestSlowPeerReplacement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client atomic.Value
+	slowServer, maxInFlight := slowFindNodeHook(300*time.Millisecond, func(from peer.ID) bool {
+		return from == client.Load()
+	})
+
+	servers := setupDHTS(t, ctx, 3, slowServer)
+	d := setupDHT(ctx, t, false, Concurrency(1), SlowPeerReplacement(20*time.Millisecond))
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+	// the servers only get slow once d learnt their latency
+	client.Store(d.self)
+
+	peers, err := d.GetClosestPeers(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, peers, len(servers))
+	// the slow servers didn't hold the only concurrency slot
+	require.Greater(t, maxInFlight.Load(), int32(1))
+}
+
+func TestHedgedRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var slow atomic.Bool
+	slowServer, maxInFlight := slowFindNodeHook(300*time.Millisecond, func(peer.ID) bool {
+		return slow.Load()
+	})
+
+	servers := setupDHTS(t, ctx, 4, slowServer)
+	d := setupDHT(ctx, t, false, Concurrency(1), HedgedRequests(90, 1))
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+
+	// learn the usual latency of the servers
+	for range minHedgingSamples {
+		_, err := d.GetClosestPeers(ctx, "foo")
+		require.NoError(t, err)
+	}
+	_, ok := d.hedgeDelay()
+	require.True(t, ok)
+
+	slow.Store(true)
+	peers, err := d.GetClosestPeers(ctx, "foo")
+	slow.Store(false)
+	require.NoError(t, err)
+	require.Len(t, peers, len(servers))
+	// one request hedged at a time
+	require.EqualValues(t, 2, maxInFlight.Load())
+}
+
+func TestResolveHedge(t *testing.T) {
+	ctx := context.Background()
+	slow, hedge := peer.ID("slow"), peer.ID("hedge")
+	newQuery := func() *query {
+		return &query{
+			hedgeOf:  map[peer.ID]peer.ID{hedge: slow},
+			hedgedBy: map[peer.ID]peer.ID{slow: hedge},
+		}
+	}
+
+	q := newQuery()
+	require.Equal(t, hedgeWon, q.resolveHedge(ctx, hedge, true))
+	require.Empty(t, q.resolveHedge(ctx, slow, true))
+
+	q = newQuery()
+	require.Equal(t, hedgeLost, q.resolveHedge(ctx, slow, true))
+	require.Empty(t, q.resolveHedge(ctx, hedge, true))
+
+	// the hedge saved the lookup if the slow peer failed
+	q = newQuery()
+	require.Empty(t, q.resolveHedge(ctx, slow, false))
+	require.Equal(t, hedgeWon, q.resolveHedge(ctx, hedge, true))
+
+	q = newQuery()
+	require.Empty(t, q.resolveHedge(ctx, slow, false))
+	require.Equal(t, hedgeLost, q.resolveHedge(ctx, hedge, false))
+	require.Empty(t, q.hedgeOf)
+	require.Empty(t, q.hedgedBy)
+}
+
+func TestExtraSlots(t *testing.T) {
+	q := &query{
+		dht:       &IpfsDHT{alpha: 2},
+		slowPeers: map[peer.ID]struct{}{"a": {}, "b": {}, "c": {}},
+	}
+	// slow peers replaced without hedging are capped by the concurrency
+	require.Equal(t, 2, q.extraSlots())
+
+	q.dht.maxHedgedRequests = 1
+	require.Equal(t, 1, q.extraSlots())
+	q.dht.maxHedgedRequests = 5
+	require.Equal(t, 3, q.extraSlots())
+}
+
+func TestQueryLatenciesPercentile(t *testing.T) {
+	l := newQueryLatencies()
+	for i := 1; i < minHedgingSamples; i++ {
+		l.record(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := l.percentile(50)
+	require.False(t, ok)
+
+	for i := minHedgingSamples; i <= 2*queryLatencyWindow; i++ {
+		l.record(time.Duration(i) * time.Millisecond)
+	}
+	// only the latest requests are kept
+	p, ok := l.percentile(50)
+	require.True(t, ok)
+	require.Equal(t, time.Duration(queryLatencyWindow+queryLatencyWindow/2)*time.Millisecond, p)
+	p, _ = l.percentile(100)
+	require.Equal(t, time.Duration(2*queryLatencyWindow)*time.Millisecond, p)
+}
+
 func checkRoutingTable(a, b *IpfsDHT) bool {
 	// loop until connection notification has been received.
 	// under high load, this may not happen as immediately as we would like.
 	return a.routingTable.Find(b.self) != "" && b.routingTable.Find(a.self) != ""
 }
+
+func TestInFlightQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var d *IpfsDHT
+	var mu sync.Mutex
+	var seen []InFlightQuery
+	observe := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		if string(req.GetKey()) == "foo" && seen == nil {
+			seen = d.InFlightQueries()
+		}
+	})
+	d = setupDHT(ctx, t, false, DisjointPaths(2))
+	servers := []*IpfsDHT{setupDHT(ctx, t, false, observe), setupDHT(ctx, t, false, observe)}
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+
+	_, err := d.GetClosestPeers(ContextWithOperation(ctx, "my-app"), "foo")
+	require.NoError(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, seen, 1)
+	require.Equal(t, "foo", seen[0].Key)
+	require.Equal(t, Operation("my-app"), seen[0].Operation)
+	require.Len(t, seen[0].IDs, 2)
+	require.Empty(t, d.InFlightQueries())
+}
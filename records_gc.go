@@ -0,0 +1,143 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/multiformats/go-base32"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p-kad-dht/internal/metrics"
+)
+
+// Reasons reported when removing a value record.
+const (
+	collectExpired = "expired"
+	collectInvalid = "invalid"
+)
+
+func (dht *IpfsDHT) runRecordGCLoop() {
+	dht.wg.Add(1)
+	go func() {
+		defer dht.wg.Done()
+
+		ticker := time.NewTicker(dht.recordGCInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				removed, reclaimed, err := dht.collectRecords(dht.ctx)
+				if err != nil {
+					if dht.ctx.Err() != nil {
+						return
+					}
+					logger.Warnw("value record GC failed", "error", err)
+				}
+				if removed > 0 {
+					logger.Infow("removed stale value records", "count", removed, "bytes", reclaimed)
+				}
+			case <-dht.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// rootKeyFilter only accepts the keys at the root of the datastore, where
+// value records are stored next to the other namespaces.
+type rootKeyFilter struct{}
+
+func (rootKeyFilter) Filter(e dsq.Entry) bool {
+	return len(ds.RawKey(e.Key).Namespaces()) == 1
+}
+
+// collectRecords removes the value records of the datastore that are older
+// than maxRecordAge or fail validation. It returns the number of records
+// removed and their total size.
+//
+// Only the keys of the datastore are listed, the values are only read for the
+// keys at its root.
+func (dht *IpfsDHT) collectRecords(ctx context.Context) (int, int64, error) {
+	res, err := dht.datastore.Query(ctx, dsq.Query{
+		Filters:  []dsq.Filter{rootKeyFilter{}},
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	removed, reclaimed := 0, int64(0)
+	for e := range res.Next() {
+		if e.Error != nil {
+			return removed, reclaimed, e.Error
+		}
+		dskey := ds.RawKey(e.Key)
+		key, err := base32.RawStdEncoding.DecodeString(dskey.Name())
+		if err != nil {
+			continue
+		}
+
+		reason, size, err := dht.removeStaleRecord(ctx, dskey, key, now)
+		if err != nil {
+			return removed, reclaimed, err
+		}
+		if reason == "" {
+			continue
+		}
+		metrics.RecordRecordCollected(ctx, reason, int64(size))
+		removed++
+		reclaimed += int64(size)
+	}
+	return removed, reclaimed, nil
+}
+
+// removeStaleRecord removes the value record stored at dskey if it is stale.
+// It returns why the record was removed and its size, or an empty reason if it
+// was kept.
+func (dht *IpfsDHT) removeStaleRecord(ctx context.Context, dskey ds.Key, key []byte, now time.Time) (string, int, error) {
+	lk := dht.putLock(key)
+	lk.Lock()
+	defer lk.Unlock()
+
+	buf, err := dht.datastore.Get(ctx, dskey)
+	if errors.Is(err, ds.ErrNotFound) {
+		return "", 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	reason := dht.staleRecordReason(key, buf, now)
+	if reason == "" {
+		return "", 0, nil
+	}
+	logger.Debugw("removing stale value record", "key", internal.LoggableRecordKeyBytes(key), "reason", reason)
+	if err := dht.datastore.Delete(ctx, dskey); err != nil {
+		return "", 0, err
+	}
+	return reason, len(buf), nil
+}
+
+// staleRecordReason returns why the value record stored for key should be
+// removed, or an empty string if it should be kept. Entries that aren't value
+// records are always kept.
+func (dht *IpfsDHT) staleRecordReason(key []byte, buf []byte, now time.Time) string {
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(buf, rec); err != nil || string(rec.GetKey()) != string(key) {
+		return ""
+	}
+	recvtime, err := internal.ParseRFC3339(rec.GetTimeReceived())
+	if err != nil || now.Sub(recvtime) > dht.maxRecordAge {
+		return collectExpired
+	}
+	if err := dht.Validator.Validate(string(key), rec.GetValue()); err != nil {
+		return collectInvalid
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+)
+
+func putTestRecord(t *testing.T, d *IpfsDHT, key string, received time.Time) {
+	t.Helper()
+	rec := record.MakePutRecord(key, []byte("value"))
+	rec.TimeReceived = internal.FormatRFC3339(received)
+	require.NoError(t, d.putLocal(context.Background(), key, rec))
+}
+
+func TestCollectRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, RecordGCInterval(0))
+	putTestRecord(t, d, "/v/fresh", time.Now())
+	putTestRecord(t, d, "/v/old", time.Now().Add(-2*d.maxRecordAge))
+	// there is no validator for this namespace
+	putTestRecord(t, d, "/unknown/fresh", time.Now())
+	// entries that aren't value records are left alone
+	require.NoError(t, d.datastore.Put(ctx, ds.NewKey("/other"), []byte("data")))
+	require.NoError(t, d.Provide(ctx, testCaseCids[0], false))
+
+	removed, reclaimed, err := d.collectRecords(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	require.Positive(t, reclaimed)
+
+	for key, exists := range map[string]bool{"/v/fresh": true, "/v/old": false, "/unknown/fresh": false} {
+		has, err := d.datastore.Has(ctx, mkDsKey(key))
+		require.NoError(t, err)
+		require.Equal(t, exists, has, key)
+	}
+	has, err := d.datastore.Has(ctx, ds.NewKey("/other"))
+	require.NoError(t, err)
+	require.True(t, has)
+	provs, err := d.providerStore.GetProviders(ctx, testCaseCids[0].Hash())
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+}
+
+func TestRecordGCLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, RecordGCInterval(10*time.Millisecond))
+	putTestRecord(t, d, "/v/old", time.Now().Add(-2*d.maxRecordAge))
+	require.Eventually(t, func() bool {
+		has, err := d.datastore.Has(ctx, mkDsKey("/v/old"))
+		require.NoError(t, err)
+		return !has
+	}, 5*time.Second, 10*time.Millisecond)
+}
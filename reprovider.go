@@ -0,0 +1,367 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-base32"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+)
+
+const (
+	// reprovideKeysPrefix is the datastore namespace holding the set of keys
+	// that are periodically re-announced.
+	reprovideKeysPrefix = "/reprovider/keys/"
+	// reprovideLastRunKey stores the start time of the last completed run, so
+	// that the schedule survives restarts.
+	reprovideLastRunKey = "/reprovider/lastrun"
+
+	// reprovideWorkers bounds the number of keys being re-announced
+	// concurrently during a run.
+	reprovideWorkers = 8
+)
+
+// reprovideRetryInterval is how long the reprovider waits before trying again
+// when a run is due but the routing table is still empty, or the run couldn't
+// start.
+var reprovideRetryInterval = time.Minute
+
+// ReprovideStats reports the progress of the reprovider.
+type ReprovideStats struct {
+	// Running is true while a reprovide run is in progress.
+	Running bool
+	// KeysTotal is the number of keys in the current (or last) run.
+	KeysTotal int
+	// KeysProvided is the number of keys successfully re-announced in the
+	// current (or last) run.
+	KeysProvided int
+	// KeysFailed is the number of keys that could not be re-announced in the
+	// current (or last) run.
+	KeysFailed int
+	// LastRunStart is the time at which the current (or last) run started.
+	LastRunStart time.Time
+	// LastRunDuration is how long the last completed run took.
+	LastRunDuration time.Duration
+	// LastSuccess is the time at which the last run that re-announced every
+	// key completed.
+	LastSuccess time.Time
+	// NextRun is the time at which the next run is scheduled.
+	NextRun time.Time
+}
+
+// reprovider keeps track of the keys provided by this node and periodically
+// re-announces them to the network before their provider records expire.
+type reprovider struct {
+	dht      *IpfsDHT
+	dstore   ds.Datastore
+	interval time.Duration
+
+	// rescheduleCh notifies the background loop that a run happened outside
+	// of its schedule.
+	rescheduleCh chan struct{}
+
+	// runLk makes sure only one run is in progress at a time.
+	runLk sync.Mutex
+
+	statsLk sync.Mutex
+	stats   ReprovideStats
+}
+
+func newReprovider(dht *IpfsDHT, dstore ds.Datastore, interval time.Duration) *reprovider {
+	return &reprovider{
+		dht:          dht,
+		dstore:       dstore,
+		interval:     interval,
+		rescheduleCh: make(chan struct{}, 1),
+	}
+}
+
+func mkReprovideKey(k multihash.Multihash) ds.Key {
+	return ds.NewKey(reprovideKeysPrefix + base32.RawStdEncoding.EncodeToString(k))
+}
+
+// track adds the key to the set of keys to reprovide.
+func (rp *reprovider) track(ctx context.Context, k multihash.Multihash) error {
+	return rp.dstore.Put(ctx, mkReprovideKey(k), []byte{})
+}
+
+// untrack removes the key from the set of keys to reprovide.
+func (rp *reprovider) untrack(ctx context.Context, k multihash.Multihash) error {
+	return rp.dstore.Delete(ctx, mkReprovideKey(k))
+}
+
+func (rp *reprovider) getStats() ReprovideStats {
+	rp.statsLk.Lock()
+	defer rp.statsLk.Unlock()
+	return rp.stats
+}
+
+// start launches the background loop re-announcing the tracked keys every
+// interval. The first run is scheduled relative to the last completed run
+// recorded in the datastore, so restarting the node doesn't delay it.
+func (rp *reprovider) start() {
+	rp.dht.wg.Add(1)
+	go func() {
+		defer rp.dht.wg.Done()
+		rp.run(rp.dht.ctx)
+	}()
+}
+
+func (rp *reprovider) run(ctx context.Context) {
+	next := time.Now()
+	if last, err := rp.loadLastRun(ctx); err != nil {
+		logger.Warnw("failed to load last reprovide time", "error", err)
+	} else if !last.IsZero() {
+		next = last.Add(rp.interval)
+	}
+	rp.setNextRun(next)
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if rp.dht.routingTable.Size() == 0 {
+				logger.Debugw("routing table is empty, postponing reprovide", "retry", reprovideRetryInterval)
+				next = time.Now().Add(reprovideRetryInterval)
+				break
+			}
+			lastStart := rp.getStats().LastRunStart
+			if err := rp.reprovide(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Warnw("reprovide run failed", "error", err)
+			}
+			if start := rp.getStats().LastRunStart; start.After(lastStart) {
+				next = start.Add(rp.interval)
+			} else {
+				// the run failed before starting, don't schedule the next
+				// one relative to the previous run, which may be long past
+				next = time.Now().Add(reprovideRetryInterval)
+			}
+		case <-rp.rescheduleCh:
+			next = rp.getStats().LastRunStart.Add(rp.interval)
+		case <-ctx.Done():
+			return
+		}
+		rp.setNextRun(next)
+		timer.Reset(time.Until(next))
+	}
+}
+
+func (rp *reprovider) setNextRun(t time.Time) {
+	rp.statsLk.Lock()
+	rp.stats.NextRun = t
+	rp.statsLk.Unlock()
+}
+
+func (rp *reprovider) loadLastRun(ctx context.Context) (time.Time, error) {
+	return loadTime(ctx, rp.dstore, ds.NewKey(reprovideLastRunKey))
+}
+
+func (rp *reprovider) storeLastRun(ctx context.Context, t time.Time) error {
+	return storeTime(ctx, rp.dstore, ds.NewKey(reprovideLastRunKey), t)
+}
+
+// loadTime reads a time stored with storeTime, it returns the zero time if
+// the key doesn't exist.
+func loadTime(ctx context.Context, dstore ds.Datastore, k ds.Key) (time.Time, error) {
+	v, err := dstore.Get(ctx, k)
+	if errors.Is(err, ds.ErrNotFound) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	nsec, n := binary.Varint(v)
+	if n <= 0 {
+		return time.Time{}, fmt.Errorf("failed to parse time stored at %s", k)
+	}
+	return time.Unix(0, nsec), nil
+}
+
+// storeTime stores t under k as a varint of nanoseconds since the epoch.
+func storeTime(ctx context.Context, dstore ds.Datastore, k ds.Key, t time.Time) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, t.UnixNano())
+	return dstore.Put(ctx, k, buf[:n])
+}
+
+// countKeys returns the number of tracked keys.
+func (rp *reprovider) countKeys(ctx context.Context) (int, error) {
+	res, err := rp.dstore.Query(ctx, dsq.Query{Prefix: reprovideKeysPrefix, KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	count := 0
+	for e := range res.Next() {
+		if e.Error != nil {
+			return 0, e.Error
+		}
+		count++
+	}
+	return count, nil
+}
+
+// reprovide re-announces every tracked key once.
+func (rp *reprovider) reprovide(ctx context.Context) error {
+	rp.runLk.Lock()
+	defer rp.runLk.Unlock()
+
+	total, err := rp.countKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count keys to reprovide: %w", err)
+	}
+
+	start := time.Now()
+	rp.statsLk.Lock()
+	rp.stats.Running = true
+	rp.stats.KeysTotal = total
+	rp.stats.KeysProvided = 0
+	rp.stats.KeysFailed = 0
+	rp.stats.LastRunStart = start
+	rp.statsLk.Unlock()
+
+	logger.Infow("starting reprovide run", "keys", total)
+
+	res, err := rp.dstore.Query(ctx, dsq.Query{Prefix: reprovideKeysPrefix, KeysOnly: true})
+	if err != nil {
+		rp.endRun(start, false)
+		return err
+	}
+	defer res.Close()
+
+	keyCh := make(chan multihash.Multihash)
+	var wg sync.WaitGroup
+	wg.Add(reprovideWorkers)
+	for i := 0; i < reprovideWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for k := range keyCh {
+				err := rp.dht.provide(ctx, k)
+
+				rp.statsLk.Lock()
+				if err != nil {
+					rp.stats.KeysFailed++
+				} else {
+					rp.stats.KeysProvided++
+				}
+				rp.statsLk.Unlock()
+
+				if err != nil {
+					logger.Debugw("failed to reprovide key", "key", k, "error", err)
+				}
+			}
+		}()
+	}
+
+	var queryErr error
+loop:
+	for e := range res.Next() {
+		if e.Error != nil {
+			queryErr = e.Error
+			break
+		}
+		k, err := base32.RawStdEncoding.DecodeString(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnw("failed to decode reprovide key", "key", e.Key, "error", err)
+			continue
+		}
+		select {
+		case keyCh <- multihash.Multihash(k):
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(keyCh)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		rp.endRun(start, false)
+		return ctx.Err()
+	}
+	if queryErr != nil {
+		rp.endRun(start, false)
+		return queryErr
+	}
+
+	if err := rp.storeLastRun(ctx, start); err != nil {
+		logger.Warnw("failed to store last reprovide time", "error", err)
+	}
+
+	stats := rp.endRun(start, true)
+	logger.Infow("finished reprovide run", "provided", stats.KeysProvided, "failed", stats.KeysFailed, "duration", stats.LastRunDuration)
+	if stats.KeysFailed > 0 {
+		return fmt.Errorf("failed to reprovide %d out of %d keys", stats.KeysFailed, stats.KeysTotal)
+	}
+	return nil
+}
+
+// endRun updates the stats at the end of a run and returns them.
+func (rp *reprovider) endRun(start time.Time, completed bool) ReprovideStats {
+	rp.statsLk.Lock()
+	defer rp.statsLk.Unlock()
+
+	now := time.Now()
+	rp.stats.Running = false
+	rp.stats.LastRunDuration = now.Sub(start)
+	if completed && rp.stats.KeysFailed == 0 {
+		rp.stats.LastSuccess = now
+	}
+	return rp.stats
+}
+
+// Reprovide synchronously re-announces every key tracked by the reprovider,
+// without waiting for the next scheduled run. The schedule is then reset
+// relative to this run.
+//
+// It returns routing.ErrNotSupported if the reprovider is disabled.
+func (dht *IpfsDHT) Reprovide(ctx context.Context) error {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.Reprovide")
+	defer span.End()
+
+	if dht.reprovider == nil {
+		return routing.ErrNotSupported
+	}
+	err := dht.reprovider.reprovide(ctx)
+	select {
+	case dht.reprovider.rescheduleCh <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// StopProviding removes the key from the set of keys re-announced by the
+// reprovider. Existing provider records are left to expire on their own.
+//
+// It returns routing.ErrNotSupported if the reprovider is disabled.
+func (dht *IpfsDHT) StopProviding(ctx context.Context, key cid.Cid) error {
+	if dht.reprovider == nil {
+		return routing.ErrNotSupported
+	} else if !key.Defined() {
+		return errors.New("invalid cid: undefined")
+	}
+	return dht.reprovider.untrack(ctx, key.Hash())
+}
+
+// ReprovideStats returns the progress of the reprovider. It returns the zero
+// value if the reprovider is disabled.
+func (dht *IpfsDHT) ReprovideStats() ReprovideStats {
+	if dht.reprovider == nil {
+		return ReprovideStats{}
+	}
+	return dht.reprovider.getStats()
+}
@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func TestReprovide(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var addProviders atomic.Int64
+	countAddProvider := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() == pb.Message_ADD_PROVIDER {
+			addProviders.Add(1)
+		}
+	})
+
+	servers := setupDHTS(t, ctx, 3, countAddProvider)
+	provider := setupDHT(ctx, t, false, EnableReprovider(), ReprovideInterval(time.Hour))
+
+	connect(t, ctx, servers[0], servers[1])
+	connect(t, ctx, servers[1], servers[2])
+	connect(t, ctx, provider, servers[0])
+
+	for _, k := range testCaseCids {
+		require.NoError(t, provider.Provide(ctx, k, true))
+	}
+	// every key is announced to all the servers, ADD_PROVIDER messages are
+	// sent without waiting for a response.
+	perRun := int64(len(servers) * len(testCaseCids))
+	require.Eventually(t, func() bool { return addProviders.Load() == perRun }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, provider.Reprovide(ctx))
+	require.Eventually(t, func() bool { return addProviders.Load() == 2*perRun }, 5*time.Second, 10*time.Millisecond)
+
+	stats := provider.ReprovideStats()
+	require.False(t, stats.Running)
+	require.Equal(t, len(testCaseCids), stats.KeysTotal)
+	require.Equal(t, len(testCaseCids), stats.KeysProvided)
+	require.Zero(t, stats.KeysFailed)
+	require.False(t, stats.LastSuccess.IsZero())
+
+	// the next run is rescheduled relative to the manual one
+	require.Eventually(t, func() bool {
+		return provider.ReprovideStats().NextRun.Equal(stats.LastRunStart.Add(time.Hour))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, provider.StopProviding(ctx, testCaseCids[0]))
+	require.NoError(t, provider.Reprovide(ctx))
+	require.Equal(t, len(testCaseCids)-1, provider.ReprovideStats().KeysTotal)
+}
+
+func TestReprovideDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	require.ErrorIs(t, d.Reprovide(ctx), routing.ErrNotSupported)
+	require.ErrorIs(t, d.StopProviding(ctx, testCaseCids[0]), routing.ErrNotSupported)
+	require.Equal(t, ReprovideStats{}, d.ReprovideStats())
+
+	_, err := New(ctx, d.host, testPrefix, ReprovideInterval(49*time.Hour))
+	require.Error(t, err)
+}
+
+// failingQueryDatastore fails every query.
+type failingQueryDatastore struct {
+	ds.Datastore
+}
+
+func (failingQueryDatastore) Query(context.Context, dsq.Query) (dsq.Results, error) {
+	return nil, errors.New("query failed")
+}
+
+func TestReprovideRetriesFailedStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d1, d2 := setupDHT(ctx, t, false), setupDHT(ctx, t, false)
+	connect(t, ctx, d1, d2)
+
+	// counting the keys fails, so the run never starts
+	rp := newReprovider(d1, failingQueryDatastore{ds.NewMapDatastore()}, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rp.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		return rp.getStats().NextRun.After(time.Now().Add(reprovideRetryInterval / 2))
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, rp.getStats().LastRunStart.IsZero())
+}
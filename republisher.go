@@ -0,0 +1,313 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-base32"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+)
+
+const (
+	// republishKeysPrefix is the datastore namespace holding the keys of the
+	// records that are periodically republished. The value tells whether the
+	// record was authored locally.
+	republishKeysPrefix = "/republisher/keys/"
+	// republishLastRunKey stores the start time of the last completed run.
+	republishLastRunKey = "/republisher/lastrun"
+
+	// republishWorkers bounds the number of records being republished
+	// concurrently during a run.
+	republishWorkers = 4
+)
+
+// Values stored under republishKeysPrefix.
+var (
+	recordAuthored = []byte{1}
+	recordReceived = []byte{0}
+)
+
+// republisher keeps track of the value records this node is responsible for
+// and periodically pushes them to the closest peers that are missing them.
+//
+// Records authored locally with PutValue are republished until they become
+// invalid or are removed with StopRepublishing, and their local copy is kept
+// from expiring. Records received from other peers are only republished as
+// long as this node is among the closest peers to their key, and until their
+// local copy expires.
+type republisher struct {
+	dht      *IpfsDHT
+	dstore   ds.Datastore
+	interval time.Duration
+
+	// runLk makes sure only one run is in progress at a time.
+	runLk sync.Mutex
+}
+
+func newRepublisher(dht *IpfsDHT, dstore ds.Datastore, interval time.Duration) *republisher {
+	return &republisher{
+		dht:      dht,
+		dstore:   dstore,
+		interval: interval,
+	}
+}
+
+func mkRepublishKey(key string) ds.Key {
+	return ds.NewKey(republishKeysPrefix + base32.RawStdEncoding.EncodeToString([]byte(key)))
+}
+
+// track adds the record key to the set of records to republish. Records
+// authored locally stay so when they are received again.
+func (rp *republisher) track(ctx context.Context, key string, authored bool) error {
+	if authored {
+		return rp.dstore.Put(ctx, mkRepublishKey(key), recordAuthored)
+	}
+	has, err := rp.dstore.Has(ctx, mkRepublishKey(key))
+	if err != nil || has {
+		return err
+	}
+	return rp.dstore.Put(ctx, mkRepublishKey(key), recordReceived)
+}
+
+// untrack removes the record key from the set of records to republish.
+func (rp *republisher) untrack(ctx context.Context, key string) error {
+	return rp.dstore.Delete(ctx, mkRepublishKey(key))
+}
+
+// start launches the background loop republishing the tracked records every
+// interval.
+func (rp *republisher) start() {
+	rp.dht.wg.Add(1)
+	go func() {
+		defer rp.dht.wg.Done()
+		rp.run(rp.dht.ctx)
+	}()
+}
+
+func (rp *republisher) run(ctx context.Context) {
+	k := ds.NewKey(republishLastRunKey)
+	// records are published when they are first tracked, so without a
+	// previous run there is no hurry.
+	next := time.Now().Add(rp.interval)
+	if last, err := loadTime(ctx, rp.dstore, k); err != nil {
+		logger.Warnw("failed to load last republish time", "error", err)
+	} else if !last.IsZero() {
+		next = last.Add(rp.interval)
+	}
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if rp.dht.routingTable.Size() == 0 {
+				logger.Debugw("routing table is empty, postponing republish", "retry", reprovideRetryInterval)
+				timer.Reset(reprovideRetryInterval)
+				continue
+			}
+			start := time.Now()
+			if err := rp.republish(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Warnw("republish run failed", "error", err)
+			} else if err := storeTime(ctx, rp.dstore, k, start); err != nil {
+				logger.Warnw("failed to store last republish time", "error", err)
+			}
+			timer.Reset(time.Until(start.Add(rp.interval)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type republishEntry struct {
+	key      string
+	authored bool
+}
+
+// republish pushes every tracked record to the closest peers missing it once.
+func (rp *republisher) republish(ctx context.Context) error {
+	rp.runLk.Lock()
+	defer rp.runLk.Unlock()
+
+	res, err := rp.dstore.Query(ctx, dsq.Query{Prefix: republishKeysPrefix})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	start := time.Now()
+	var records, pushed, failed atomic.Int64
+	entryCh := make(chan republishEntry)
+	var wg sync.WaitGroup
+	wg.Add(republishWorkers)
+	for i := 0; i < republishWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for e := range entryCh {
+				n, err := rp.republishRecord(ctx, e.key, e.authored)
+				if err != nil {
+					failed.Add(1)
+					logger.Debugw("failed to republish record", "key", internal.LoggableRecordKeyString(e.key), "error", err)
+					continue
+				}
+				records.Add(1)
+				pushed.Add(int64(n))
+			}
+		}()
+	}
+
+	var queryErr error
+loop:
+	for e := range res.Next() {
+		if e.Error != nil {
+			queryErr = e.Error
+			break
+		}
+		k, err := base32.RawStdEncoding.DecodeString(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnw("failed to decode republish key", "key", e.Key, "error", err)
+			continue
+		}
+		select {
+		case entryCh <- republishEntry{key: string(k), authored: bytes.Equal(e.Value, recordAuthored)}:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(entryCh)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if queryErr != nil {
+		return queryErr
+	}
+
+	logger.Infow("finished republish run", "records", records.Load(), "pushed", pushed.Load(), "failed", failed.Load(), "duration", time.Since(start))
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("failed to republish %d records", n)
+	}
+	return nil
+}
+
+// republishRecord pushes the local copy of the record to the closest peers
+// that don't have it or hold an older version, and returns how many peers it
+// was pushed to.
+func (rp *republisher) republishRecord(ctx context.Context, key string, authored bool) (int, error) {
+	rec, err := rp.refreshLocal(ctx, key, authored)
+	if err != nil {
+		return 0, err
+	}
+	if rec == nil {
+		return 0, rp.untrack(ctx, key)
+	}
+	if err := rp.dht.Validator.Validate(key, rec.GetValue()); err != nil {
+		logger.Debugw("not republishing invalid record", "key", internal.LoggableRecordKeyString(key), "error", err)
+		return 0, rp.untrack(ctx, key)
+	}
+
+	peers, err := rp.dht.GetClosestPeers(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if !authored {
+		responsible := len(peers) < rp.dht.bucketSize
+		for _, p := range peers {
+			if kb.Closer(rp.dht.self, p, key) {
+				responsible = true
+				break
+			}
+		}
+		if !responsible {
+			logger.Debugw("no longer responsible for record, not republishing", "key", internal.LoggableRecordKeyString(key))
+			return 0, rp.untrack(ctx, key)
+		}
+	}
+
+	var pushed atomic.Int64
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if !rp.isMissing(ctx, p, key, rec) {
+				return
+			}
+			if err := rp.dht.protoMessenger.PutValue(ctx, p, rec); err != nil {
+				logger.Debugw("failed to republish record to peer", "key", internal.LoggableRecordKeyString(key), "peer", p, "error", err)
+				return
+			}
+			pushed.Add(1)
+		}(p)
+	}
+	wg.Wait()
+	return int(pushed.Load()), nil
+}
+
+// refreshLocal returns the local copy of the record, or nil if it expired.
+// The receive time of records authored locally is reset so that they don't
+// expire.
+func (rp *republisher) refreshLocal(ctx context.Context, key string, authored bool) (*recpb.Record, error) {
+	lk := rp.dht.putLock([]byte(key))
+	lk.Lock()
+	defer lk.Unlock()
+
+	rec, err := rp.dht.getLocal(ctx, key)
+	if err != nil || rec == nil || !authored {
+		return rec, err
+	}
+	rec.TimeReceived = internal.FormatRFC3339(time.Now())
+	if err := rp.dht.putLocal(ctx, key, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// isMissing returns whether p doesn't hold rec or a better version of it.
+func (rp *republisher) isMissing(ctx context.Context, p peer.ID, key string, rec *recpb.Record) bool {
+	remote, _, err := rp.dht.protoMessenger.GetValue(ctx, p, key)
+	if err != nil && !errors.Is(err, internal.ErrIncorrectRecord) {
+		// the peer can't be reached, don't bother trying to put the record.
+		return false
+	}
+	if remote == nil {
+		return true
+	}
+	if bytes.Equal(remote.GetValue(), rec.GetValue()) {
+		return false
+	}
+	if err := rp.dht.Validator.Validate(key, remote.GetValue()); err != nil {
+		return true
+	}
+	i, err := rp.dht.Validator.Select(key, [][]byte{rec.GetValue(), remote.GetValue()})
+	return err == nil && i == 0
+}
+
+// StopRepublishing removes the record key from the set of records
+// republished by the republisher. Existing records are left to expire on their
+// own.
+//
+// It returns routing.ErrNotSupported if the republisher is disabled.
+func (dht *IpfsDHT) StopRepublishing(ctx context.Context, key string) error {
+	if dht.republisher == nil {
+		return routing.ErrNotSupported
+	}
+	return dht.republisher.untrack(ctx, key)
+}
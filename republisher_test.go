@@ -0,0 +1,66 @@
+package dht
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func TestRepublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var puts atomic.Int64
+	countPutValue := OnRequestHook(func(ctx context.Context, s network.Stream, req *pb.Message) {
+		if req.GetType() == pb.Message_PUT_VALUE {
+			puts.Add(1)
+		}
+	})
+
+	servers := setupDHTS(t, ctx, 2, countPutValue, EnableRepublisher())
+	author := setupDHT(ctx, t, false, EnableRepublisher())
+	connect(t, ctx, author, servers[0])
+	connect(t, ctx, servers[0], servers[1])
+
+	const key = "/v/hello"
+	require.NoError(t, author.PutValue(ctx, key, []byte("world")))
+	require.Equal(t, int64(2), puts.Load())
+
+	// peers already holding the record are left alone
+	require.NoError(t, author.republisher.republish(ctx))
+	require.Equal(t, int64(2), puts.Load())
+
+	// new close peers get the record
+	late := setupDHT(ctx, t, false, countPutValue)
+	connect(t, ctx, late, servers[1])
+	require.NoError(t, author.republisher.republish(ctx))
+	require.Equal(t, int64(3), puts.Load())
+	rec, err := late.getLocal(ctx, key)
+	require.NoError(t, err)
+	require.NotNil(t, rec)
+	require.Equal(t, []byte("world"), rec.GetValue())
+
+	// servers are among the closest peers of the records they received
+	v, err := servers[0].datastore.Get(ctx, mkRepublishKey(key))
+	require.NoError(t, err)
+	require.Equal(t, recordReceived, v)
+
+	require.NoError(t, author.StopRepublishing(ctx, key))
+	has, err := author.datastore.Has(ctx, mkRepublishKey(key))
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestRepublishDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	require.ErrorIs(t, d.StopRepublishing(ctx, "/v/hello"), routing.ErrNotSupported)
+}
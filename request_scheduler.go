@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	"github.com/libp2p/go-libp2p-kad-dht/internal/metrics"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// rejectOverloaded is reported when load is shed because too many requests
+// are being handled.
+const rejectOverloaded = "overloaded"
+
+var (
+	errRequestQueueFull    = errors.New("request queue full")
+	errRequestQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// requestWaiter is a request waiting for a handler slot.
+type requestWaiter struct {
+	priority int
+	seq      uint64
+	index    int
+	granted  chan struct{}
+}
+
+// requestQueue is a min-heap of waiters, ordered by priority then arrival.
+type requestQueue []*requestWaiter
+
+func (q requestQueue) Len() int { return len(q) }
+
+func (q requestQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q requestQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *requestQueue) Push(x any) {
+	w := x.(*requestWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *requestQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+// requestScheduler bounds the number of inbound requests handled
+// concurrently. Requests that can't be handled right away wait in a queue and
+// are served by priority as handlers complete.
+type requestScheduler struct {
+	maxConcurrency int
+	queueSize      int
+	queueTimeout   time.Duration
+	priorities     map[pb.Message_MessageType]int
+
+	lk      sync.Mutex
+	running int
+	seq     uint64
+	queue   requestQueue
+}
+
+// newRequestScheduler returns the request scheduler configured in cfg, or nil
+// if the number of concurrent requests is unbounded.
+func newRequestScheduler(cfg dhtcfg.Config) *requestScheduler {
+	c := cfg.RequestScheduler
+	if c.MaxConcurrency == 0 {
+		return nil
+	}
+	return &requestScheduler{
+		maxConcurrency: c.MaxConcurrency,
+		queueSize:      c.QueueSize,
+		queueTimeout:   c.QueueTimeout,
+		priorities:     c.Priorities,
+	}
+}
+
+// acquire waits for a handler slot for a request of type t. It fails if the
+// queue is full, or if no slot was freed within the queue timeout. On success
+// the returned function must be called once the request has been handled.
+func (s *requestScheduler) acquire(ctx context.Context, t pb.Message_MessageType) (func(), error) {
+	s.lk.Lock()
+	if s.running < s.maxConcurrency {
+		s.running++
+		s.lk.Unlock()
+		return s.release, nil
+	}
+	if len(s.queue) >= s.queueSize {
+		s.lk.Unlock()
+		return nil, errRequestQueueFull
+	}
+	s.seq++
+	w := &requestWaiter{
+		priority: s.priorities[t],
+		seq:      s.seq,
+		granted:  make(chan struct{}),
+	}
+	heap.Push(&s.queue, w)
+	s.lk.Unlock()
+
+	metrics.RecordRequestQueued(ctx, 1)
+	defer metrics.RecordRequestQueued(ctx, -1)
+	start := time.Now()
+	defer func() {
+		metrics.RecordRequestQueueWait(ctx, float64(time.Since(start))/float64(time.Millisecond))
+	}()
+
+	timer := time.NewTimer(s.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.granted:
+		return s.release, nil
+	case <-timer.C:
+		err = errRequestQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if w.index < 0 {
+		// a slot was handed to us while giving up, give it to the next waiter
+		s.releaseLocked()
+		return nil, err
+	}
+	heap.Remove(&s.queue, w.index)
+	return nil, err
+}
+
+// release frees a handler slot, handing it over to the first waiter if any.
+func (s *requestScheduler) release() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.releaseLocked()
+}
+
+func (s *requestScheduler) releaseLocked() {
+	if len(s.queue) == 0 {
+		s.running--
+		return
+	}
+	w := heap.Pop(&s.queue).(*requestWaiter)
+	close(w.granted)
+}
+
+// shedHandler returns the handler answering requests of type t when the node
+// is overloaded, or nil if such requests should be dropped. Lookups are only
+// answered with closer peers from the routing table, which is cheap and keeps
+// remote queries progressing.
+func (dht *IpfsDHT) shedHandler(t pb.Message_MessageType) dhtHandler {
+	switch t {
+	case pb.Message_FIND_NODE:
+		return dht.handleFindPeer
+	case pb.Message_GET_VALUE, pb.Message_GET_PROVIDERS, pb.Message_GET_ENCRYPTED_PROVIDERS:
+		return dht.handleCloserPeersOnly
+	default:
+		return nil
+	}
+}
+
+// handleCloserPeersOnly answers a request with the closest peers to its key
+// that this node knows of, without looking up any record.
+func (dht *IpfsDHT) handleCloserPeersOnly(_ context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	resp := pb.NewMessage(pmes.GetType(), pmes.GetKey(), pmes.GetClusterLevel())
+	closer := dht.betterPeersToQuery(pmes, p, dht.bucketSize)
+	resp.CloserPeers = pb.PeerInfosToPBPeers(dht.host.Network(), pstore.PeerInfos(dht.peerstore, closer))
+	return resp, nil
+}
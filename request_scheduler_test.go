@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func newTestRequestScheduler(t *testing.T, opts ...Option) *requestScheduler {
+	t.Helper()
+	var cfg dhtcfg.Config
+	require.NoError(t, cfg.Apply(append([]Option{dhtcfg.Defaults}, opts...)...))
+	s := newRequestScheduler(cfg)
+	require.NotNil(t, s)
+	return s
+}
+
+func TestRequestSchedulerPriorities(t *testing.T) {
+	ctx := context.Background()
+	s := newTestRequestScheduler(t, MaxConcurrentRequests(1), RequestQueue(8, time.Minute))
+
+	release, err := s.acquire(ctx, pb.Message_PING)
+	require.NoError(t, err)
+
+	served := make(chan pb.Message_MessageType)
+	waiting := func(n int) func() bool {
+		return func() bool {
+			s.lk.Lock()
+			defer s.lk.Unlock()
+			return len(s.queue) == n
+		}
+	}
+	for i, typ := range []pb.Message_MessageType{pb.Message_GET_PROVIDERS, pb.Message_PUT_VALUE, pb.Message_FIND_NODE} {
+		go func() {
+			release, err := s.acquire(ctx, typ)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			served <- typ
+			release()
+		}()
+		require.Eventually(t, waiting(i+1), time.Second, time.Millisecond)
+	}
+
+	release()
+	require.Equal(t, pb.Message_FIND_NODE, <-served)
+	require.Equal(t, pb.Message_PUT_VALUE, <-served)
+	require.Equal(t, pb.Message_GET_PROVIDERS, <-served)
+
+	// every slot was released
+	release, err = s.acquire(ctx, pb.Message_PING)
+	require.NoError(t, err)
+	release()
+}
+
+func TestRequestSchedulerShedding(t *testing.T) {
+	ctx := context.Background()
+	s := newTestRequestScheduler(t, MaxConcurrentRequests(1), RequestQueue(1, 50*time.Millisecond))
+
+	release, err := s.acquire(ctx, pb.Message_PING)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, pb.Message_FIND_NODE)
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		s.lk.Lock()
+		defer s.lk.Unlock()
+		return len(s.queue) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = s.acquire(ctx, pb.Message_FIND_NODE)
+	require.ErrorIs(t, err, errRequestQueueFull)
+	require.ErrorIs(t, <-done, errRequestQueueTimeout)
+	require.Empty(t, s.queue)
+
+	release()
+	require.Zero(t, s.running)
+}
+
+func TestShedRequestsAnsweredWithCloserPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 3)
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[1], dhts[2])
+
+	k := testCaseCids[0].Hash()
+	require.NoError(t, dhts[1].providerStore.AddProvider(ctx, k, dhts[1].peerstore.PeerInfo(dhts[1].self)))
+
+	req := pb.NewMessage(pb.Message_GET_PROVIDERS, k, 0)
+	require.Nil(t, dhts[1].shedHandler(pb.Message_ADD_PROVIDER))
+	resp, err := dhts[1].shedHandler(pb.Message_GET_PROVIDERS)(ctx, dhts[0].self, req)
+	require.NoError(t, err)
+	require.Empty(t, resp.ProviderPeers)
+	require.Len(t, resp.CloserPeers, 1)
+	require.Equal(t, []byte(dhts[2].self), resp.CloserPeers[0].Id)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
 	internalConfig "github.com/libp2p/go-libp2p-kad-dht/internal/config"
 	"github.com/libp2p/go-libp2p-kad-dht/netsize"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
 	kb "github.com/libp2p/go-libp2p-kbucket"
 	record "github.com/libp2p/go-libp2p-record"
@@ -32,6 +33,7 @@ import (
 func (dht *IpfsDHT) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) (err error) {
 	ctx, end := tracer.PutValue(dhtName, ctx, key, value, opts...)
 	defer func() { end(err) }()
+	ctx = ContextWithOperation(ctx, OperationPutValue)
 
 	if !dht.enableValues {
 		return routing.ErrNotSupported
@@ -69,6 +71,12 @@ func (dht *IpfsDHT) PutValue(ctx context.Context, key string, value []byte, opts
 		return err
 	}
 
+	if dht.republisher != nil {
+		if err := dht.republisher.track(ctx, key, true); err != nil {
+			logger.Warnw("failed to track record for republishing", "key", internal.LoggableRecordKeyString(key), "error", err)
+		}
+	}
+
 	peers, err := dht.GetClosestPeers(ctx, key)
 	if err != nil {
 		return err
@@ -107,6 +115,7 @@ type recvdVal struct {
 func (dht *IpfsDHT) GetValue(ctx context.Context, key string, opts ...routing.Option) (result []byte, err error) {
 	ctx, end := tracer.GetValue(dhtName, ctx, key, opts...)
 	defer func() { end(result, err) }()
+	ctx = ContextWithOperation(ctx, OperationGetValue)
 
 	if !dht.enableValues {
 		return nil, routing.ErrNotSupported
@@ -144,6 +153,7 @@ func (dht *IpfsDHT) GetValue(ctx context.Context, key string, opts ...routing.Op
 func (dht *IpfsDHT) SearchValue(ctx context.Context, key string, opts ...routing.Option) (ch <-chan []byte, err error) {
 	ctx, end := tracer.SearchValue(dhtName, ctx, key, opts...)
 	defer func() { ch, err = end(ch, err) }()
+	ctx = ContextWithOperation(ctx, OperationGetValue)
 
 	if !dht.enableValues {
 		return nil, routing.ErrNotSupported
@@ -385,6 +395,7 @@ func (dht *IpfsDHT) refreshRTIfNoShortcut(key kb.ID, lookupRes *lookupWithFollow
 func (dht *IpfsDHT) Provide(ctx context.Context, key cid.Cid, brdcst bool) (err error) {
 	ctx, end := tracer.Provide(dhtName, ctx, key, brdcst)
 	defer func() { end(err) }()
+	ctx = ContextWithOperation(ctx, OperationProvide)
 
 	if !dht.enableProviders {
 		return routing.ErrNotSupported
@@ -400,6 +411,30 @@ func (dht *IpfsDHT) Provide(ctx context.Context, key cid.Cid, brdcst bool) (err
 		return nil
 	}
 
+	if dht.reprovider != nil {
+		if err := dht.reprovider.track(ctx, keyMH); err != nil {
+			logger.Warnw("failed to track key for reproviding", "mh", internal.LoggableProviderRecordBytes(keyMH), "error", err)
+		}
+	}
+
+	return dht.provide(ctx, keyMH)
+}
+
+// provide announces to the network that we are providing the given key, using
+// the optimistic provide process if it is enabled. An encrypted provider record
+// is announced alongside if encrypted provider records are enabled, failing to
+// announce it doesn't fail the provide.
+func (dht *IpfsDHT) provide(ctx context.Context, keyMH multihash.Multihash) error {
+	if dht.encryptProviderRecords {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := dht.provideEncrypted(ctx, keyMH); err != nil {
+				logger.Warnw("failed to announce encrypted provider record", "mh", internal.LoggableProviderRecordBytes(keyMH), "error", err)
+			}
+		}()
+		defer func() { <-done }()
+	}
 	if dht.enableOptProv {
 		err := dht.optimisticProvide(ctx, keyMH)
 		if errors.Is(err, netsize.ErrNotEnoughData) {
@@ -449,16 +484,19 @@ func (dht *IpfsDHT) classicProvide(ctx context.Context, keyMH multihash.Multihas
 		return err
 	}
 
+	self := peer.AddrInfo{
+		ID:    dht.self,
+		Addrs: dht.filterAddrs(dht.host.Addrs()),
+	}
+	envelope := dht.sealProviderRecord(keyMH, self)
+
 	wg := sync.WaitGroup{}
 	for _, p := range peers {
 		wg.Add(1)
 		go func(p peer.ID) {
 			defer wg.Done()
 			logger.Debugf("putProvider(%s, %s)", internal.LoggableProviderRecordBytes(keyMH), p)
-			err := dht.protoMessenger.PutProviderAddrs(ctx, p, keyMH, peer.AddrInfo{
-				ID:    dht.self,
-				Addrs: dht.filterAddrs(dht.host.Addrs()),
-			})
+			err := dht.protoMessenger.PutSignedProviderAddrs(ctx, p, keyMH, self, envelope)
 			if err != nil {
 				logger.Debug(err)
 			}
@@ -496,6 +534,29 @@ func (dht *IpfsDHT) FindProvidersAsync(ctx context.Context, key cid.Cid, count i
 	defer func() { ch = end(ch, nil) }()
 
 	peerOut := make(chan peer.AddrInfo)
+	results := dht.findProvidersAsync(ctx, key, count)
+	go func() {
+		defer close(peerOut)
+		for res := range results {
+			select {
+			case peerOut <- res.AddrInfo:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return peerOut
+}
+
+// FindProvidersAsyncWithInfo is like FindProvidersAsync, but every provider is
+// returned along with how it was found, see ProviderResult. A provider may be
+// returned a second time if it was first found without addresses.
+func (dht *IpfsDHT) FindProvidersAsyncWithInfo(ctx context.Context, key cid.Cid, count int) <-chan ProviderResult {
+	return dht.findProvidersAsync(ctx, key, count)
+}
+
+func (dht *IpfsDHT) findProvidersAsync(ctx context.Context, key cid.Cid, count int) <-chan ProviderResult {
+	ctx = ContextWithOperation(ctx, OperationFindProviders)
+	peerOut := make(chan ProviderResult)
 	if !dht.enableProviders || !key.Defined() {
 		close(peerOut)
 		return peerOut
@@ -503,12 +564,18 @@ func (dht *IpfsDHT) FindProvidersAsync(ctx context.Context, key cid.Cid, count i
 
 	keyMH := key.Hash()
 
+	if dht.privateLookups {
+		logger.Debugw("finding providers privately", "cid", key)
+		go dht.findEncryptedProvidersAsyncRoutine(ctx, keyMH, count, peerOut)
+		return peerOut
+	}
+
 	logger.Debugw("finding providers", "cid", key, "mh", internal.LoggableProviderRecordBytes(keyMH))
 	go dht.findProvidersAsyncRoutine(ctx, keyMH, count, peerOut)
 	return peerOut
 }
 
-func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash.Multihash, count int, peerOut chan peer.AddrInfo) {
+func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash.Multihash, count int, peerOut chan ProviderResult) {
 	// use a span here because unlike tracer.FindProvidersAsync we know who told us about it and that intresting to log.
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.FindProvidersAsyncRoutine")
 	defer span.End()
@@ -535,20 +602,22 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 		return len(ps)
 	}
 
-	provs, err := dht.providerStore.GetProviders(ctx, key)
+	provs, records, err := dht.getSignedProviders(ctx, key)
 	if err != nil {
 		return
 	}
-	for _, p := range provs {
+	local, signed := dht.verifySignedProviders(key, provs, records)
+	for _, p := range local {
+		res := dht.newProviderResult(ctx, p, dht.self, signed, 0)
 		// NOTE: Assuming that this list of peers is unique
-		if psTryAdd(p) {
+		if psTryAdd(res.AddrInfo) {
 			select {
-			case peerOut <- p:
+			case peerOut <- res:
 				// Add tracing event for finding a provider
 				span.AddEvent("found provider", trace.WithAttributes(
 					attribute.Stringer("peer", p.ID),
 					attribute.Stringer("from", dht.self),
-					attribute.Int("provider_addrs_count", len(p.Addrs)),
+					attribute.Int("provider_addrs_count", len(res.Addrs)),
 					attribute.Bool("found_in_provider_store", true),
 				))
 			case <-ctx.Done():
@@ -563,7 +632,8 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 		}
 	}
 
-	lookupRes, err := dht.runLookupWithFollowup(ctx, string(key),
+	target := dht.providersLookupTarget(key)
+	lookupRes, err := dht.runLookupWithFollowupToKadID(ctx, string(key), target,
 		func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
 			// For DHT query command
 			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
@@ -571,25 +641,51 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 				ID:   p,
 			})
 
-			provs, closest, err := dht.protoMessenger.GetProviders(ctx, p, key)
+			var provs, closest []*peer.AddrInfo
+			var records [][]byte
+			var err error
+			start := time.Now()
+			if dht.prefixLookupBits > 0 {
+				provs, closest, err = dht.getRemoteProvidersByPrefix(ctx, p, key)
+				if errors.Is(err, pb.ErrPrefixLookupsNotSupported) {
+					if dht.prefixLookupFallback {
+						// reveals the key to the peer
+						provs, closest, records, err = dht.protoMessenger.GetSignedProviders(ctx, p, key)
+					} else {
+						// the peer only returned the providers of the
+						// prefix itself, keep its closer peers
+						err = nil
+					}
+				}
+			} else {
+				provs, closest, records, err = dht.protoMessenger.GetSignedProviders(ctx, p, key)
+			}
 			if err != nil {
 				return nil, err
 			}
+			rtt := time.Since(start)
 
 			logger.Debugf("%d provider entries", len(provs))
 
+			unverified := make([]peer.AddrInfo, len(provs))
+			for i, prov := range provs {
+				unverified[i] = *prov
+			}
+
 			// Add unique providers from request, up to 'count'
-			for _, prov := range provs {
+			verified, signed := dht.verifySignedProviders(key, unverified, records)
+			for _, prov := range verified {
 				dht.maybeAddAddrs(prov.ID, prov.Addrs, peerstore.TempAddrTTL)
 				logger.Debugf("got provider: %s", prov)
-				if psTryAdd(*prov) {
+				res := dht.newProviderResult(ctx, prov, p, signed, rtt)
+				if psTryAdd(res.AddrInfo) {
 					logger.Debugf("using provider: %s", prov)
 					select {
-					case peerOut <- *prov:
+					case peerOut <- res:
 						span.AddEvent("found provider", trace.WithAttributes(
 							attribute.Stringer("peer", prov.ID),
 							attribute.Stringer("from", p),
-							attribute.Int("provider_addrs_count", len(prov.Addrs)),
+							attribute.Int("provider_addrs_count", len(res.Addrs)),
 						))
 					case <-ctx.Done():
 						logger.Debug("context timed out sending more providers")
@@ -619,7 +715,7 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 	)
 
 	if err == nil && ctx.Err() == nil {
-		dht.refreshRTIfNoShortcut(kb.ConvertKey(string(key)), lookupRes)
+		dht.refreshRTIfNoShortcut(target, lookupRes)
 	}
 }
 
@@ -627,6 +723,7 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 func (dht *IpfsDHT) FindPeer(ctx context.Context, id peer.ID) (pi peer.AddrInfo, err error) {
 	ctx, end := tracer.FindPeer(dhtName, ctx, id)
 	defer func() { end(pi, err) }()
+	ctx = ContextWithOperation(ctx, OperationFindPeer)
 
 	if err := id.Validate(); err != nil {
 		return peer.AddrInfo{}, err
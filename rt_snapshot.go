@@ -0,0 +1,229 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// rtSnapshotPrefix is the datastore namespace holding one entry per peer
+	// of the last routing table snapshot.
+	rtSnapshotPrefix = "/routing-table/peers/"
+
+	// rtRestoreConcurrency bounds the number of snapshot peers being checked
+	// concurrently when restoring the routing table.
+	rtRestoreConcurrency = 32
+)
+
+// rtSnapshotPeer is the datastore entry of a routing table peer.
+type rtSnapshotPeer struct {
+	Addrs                         [][]byte  `json:"addrs"`
+	LastUsefulAt                  time.Time `json:"lastUsefulAt"`
+	LastSuccessfulOutboundQueryAt time.Time `json:"lastSuccessfulOutboundQueryAt"`
+}
+
+func mkRTSnapshotKey(p peer.ID) ds.Key {
+	return ds.NewKey(rtSnapshotPrefix + base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+// runRTSnapshotLoop periodically saves a snapshot of the routing table.
+func (dht *IpfsDHT) runRTSnapshotLoop() {
+	dht.wg.Add(1)
+	go func() {
+		defer dht.wg.Done()
+
+		ticker := time.NewTicker(dht.rtSnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := dht.snapshotRoutingTable(dht.ctx); err != nil {
+					logger.Warnw("failed to save routing table snapshot", "error", err)
+				}
+			case <-dht.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// snapshotRoutingTable replaces the snapshot in the datastore with the current
+// peers of the routing table. An empty routing table doesn't overwrite the
+// previous snapshot.
+func (dht *IpfsDHT) snapshotRoutingTable(ctx context.Context) error {
+	infos := dht.routingTable.GetPeerInfos()
+	if len(infos) == 0 {
+		return nil
+	}
+
+	batch, err := dht.rtSnapshotStore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[ds.Key]struct{}, len(infos))
+	for _, pi := range infos {
+		addrs := dht.peerstore.Addrs(pi.Id)
+		entry := rtSnapshotPeer{
+			Addrs:                         make([][]byte, len(addrs)),
+			LastUsefulAt:                  pi.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+		}
+		for i, a := range addrs {
+			entry.Addrs[i] = a.Bytes()
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		k := mkRTSnapshotKey(pi.Id)
+		current[k] = struct{}{}
+		if err := batch.Put(ctx, k, data); err != nil {
+			return err
+		}
+	}
+
+	// remove the peers that left the routing table since the last snapshot
+	res, err := dht.rtSnapshotStore.Query(ctx, dsq.Query{Prefix: rtSnapshotPrefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k := ds.NewKey(e.Key)
+		if _, ok := current[k]; ok {
+			continue
+		}
+		if err := batch.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	return batch.Commit(ctx)
+}
+
+type rtSnapshotEntry struct {
+	id peer.ID
+	rtSnapshotPeer
+	addrs []ma.Multiaddr
+}
+
+// loadRoutingTableSnapshot reads the peers of the last routing table snapshot,
+// most recently queried first.
+func (dht *IpfsDHT) loadRoutingTableSnapshot(ctx context.Context) ([]rtSnapshotEntry, error) {
+	res, err := dht.rtSnapshotStore.Query(ctx, dsq.Query{Prefix: rtSnapshotPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []rtSnapshotEntry
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+
+		k := ds.RawKey(e.Key)
+		decoded, err := base32.RawStdEncoding.DecodeString(k.BaseNamespace())
+		if err != nil {
+			logger.Debugw("invalid routing table snapshot key", "key", e.Key, "error", err)
+			continue
+		}
+		id, err := peer.IDFromBytes(decoded)
+		if err != nil {
+			logger.Debugw("invalid peer in routing table snapshot", "key", e.Key, "error", err)
+			continue
+		}
+
+		entry := rtSnapshotEntry{id: id}
+		if err := json.Unmarshal(e.Value, &entry.rtSnapshotPeer); err != nil {
+			logger.Debugw("invalid routing table snapshot entry", "peer", id, "error", err)
+			continue
+		}
+		for _, b := range entry.Addrs {
+			a, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				continue
+			}
+			entry.addrs = append(entry.addrs, a)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastSuccessfulOutboundQueryAt.After(entries[j].LastSuccessfulOutboundQueryAt)
+	})
+	return entries, nil
+}
+
+// restoreRoutingTable adds the peers of the last routing table snapshot back
+// to the routing table, provided they still answer DHT requests as expected.
+// It returns once every peer has been checked.
+func (dht *IpfsDHT) restoreRoutingTable() {
+	entries, err := dht.loadRoutingTableSnapshot(dht.ctx)
+	if err != nil {
+		logger.Warnw("failed to load routing table snapshot", "error", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	logger.Debugw("restoring routing table from snapshot", "peers", len(entries))
+
+	sem := make(chan struct{}, rtRestoreConcurrency)
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		if e.id == dht.self || len(e.addrs) == 0 {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-dht.ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(e rtSnapshotEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			dht.peerstore.AddAddrs(e.id, e.addrs, peerstore.TempAddrTTL)
+
+			livelinessCtx, cancel := context.WithTimeout(dht.ctx, dht.lookupCheckTimeout)
+			defer cancel()
+			if err := dht.lookupCheck(livelinessCtx, e.id); err != nil {
+				logger.Debugw("routing table snapshot peer not answering DHT request as expected", "peer", e.id, "error", err)
+				return
+			}
+			if valid, err := dht.validRTPeer(e.id); err != nil || !valid {
+				return
+			}
+
+			// the peer just answered a query, so it is added as a queried
+			// peer, but keeps the usefulness it had before the snapshot.
+			if _, err := dht.routingTable.TryAddPeer(e.id, true, true); err != nil {
+				return
+			}
+			if !e.LastUsefulAt.IsZero() {
+				dht.routingTable.UpdateLastUsefulAt(e.id, e.LastUsefulAt)
+			}
+		}(e)
+	}
+	wg.Wait()
+}
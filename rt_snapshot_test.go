@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTablePersistence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 3)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	d := setupDHT(ctx, t, false, Datastore(dstore), RoutingTablePersistence(true))
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+	require.Equal(t, len(servers), d.routingTable.Size())
+
+	// the snapshot is saved on close
+	require.NoError(t, d.Close())
+	entries, err := d.loadRoutingTableSnapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, len(servers))
+
+	// a new node using the same datastore starts with the same routing table,
+	// without being connected to anyone.
+	restarted := setupDHT(ctx, t, false, Datastore(dstore), RoutingTablePersistence(true))
+	require.Eventually(t, func() bool {
+		return restarted.routingTable.Size() == len(servers)
+	}, 10*time.Second, 10*time.Millisecond)
+	for _, s := range servers {
+		require.NotEmpty(t, restarted.routingTable.Find(s.self))
+	}
+
+	// peers that left the routing table are removed from the next snapshot
+	restarted.routingTable.RemovePeer(servers[0].self)
+	require.NoError(t, restarted.snapshotRoutingTable(ctx))
+	entries, err = restarted.loadRoutingTableSnapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, len(servers)-1)
+}
+
+func TestRoutingTablePersistenceSkipsDeadPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := setupDHTS(t, ctx, 2)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	d := setupDHT(ctx, t, false, Datastore(dstore), RoutingTablePersistence(true))
+	for _, s := range servers {
+		connect(t, ctx, d, s)
+	}
+	require.NoError(t, d.Close())
+	require.NoError(t, servers[0].host.Close())
+
+	restarted := setupDHT(ctx, t, false, Datastore(dstore), RoutingTablePersistence(true))
+	require.Eventually(t, func() bool {
+		return restarted.routingTable.Find(servers[1].self) != ""
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Empty(t, restarted.routingTable.Find(servers[0].self))
+}
@@ -55,6 +55,29 @@ type RtRefreshManager struct {
 	triggerRefresh chan *triggerRefreshReq // channel to write refresh requests to.
 
 	refreshDoneCh chan struct{} // write to this channel after every refresh
+
+	stateLk sync.Mutex
+	state   State
+}
+
+// State describes the refreshes run by a RtRefreshManager.
+type State struct {
+	// AutoRefresh is whether the routing table is refreshed periodically,
+	// every Interval.
+	AutoRefresh bool
+	Interval    time.Duration
+	// Running is whether a refresh is in progress.
+	Running bool
+	// Refreshes is the number of refreshes finished.
+	Refreshes int
+	// LastStartedAt and LastFinishedAt are the times the last refresh
+	// started and finished, LastError is the error it finished with.
+	LastStartedAt  time.Time
+	LastFinishedAt time.Time
+	LastError      error
+	// CplLastRefreshedAt maps the common prefix lengths tracked for refresh
+	// to the time they were last refreshed.
+	CplLastRefreshedAt map[uint]time.Time
 }
 
 func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool,
@@ -120,6 +143,38 @@ func (r *RtRefreshManager) Refresh(force bool) <-chan error {
 	return resp
 }
 
+// State returns the current state of the refresh manager.
+func (r *RtRefreshManager) State() State {
+	r.stateLk.Lock()
+	s := r.state
+	r.stateLk.Unlock()
+
+	s.AutoRefresh = r.enableAutoRefresh
+	s.Interval = r.refreshInterval
+	tracked := r.rt.GetTrackedCplsForRefresh()
+	s.CplLastRefreshedAt = make(map[uint]time.Time, len(tracked))
+	for cpl, t := range tracked {
+		s.CplLastRefreshedAt[uint(cpl)] = t
+	}
+	return s
+}
+
+func (r *RtRefreshManager) refreshStarted() {
+	r.stateLk.Lock()
+	defer r.stateLk.Unlock()
+	r.state.Running = true
+	r.state.LastStartedAt = time.Now()
+}
+
+func (r *RtRefreshManager) refreshFinished(err error) {
+	r.stateLk.Lock()
+	defer r.stateLk.Unlock()
+	r.state.Running = false
+	r.state.Refreshes++
+	r.state.LastFinishedAt = time.Now()
+	r.state.LastError = err
+}
+
 // RefreshNoWait requests the refresh manager to refresh the Routing Table.
 // However, it moves on without blocking if it's request can't get through.
 func (r *RtRefreshManager) RefreshNoWait() {
@@ -182,7 +237,9 @@ func (r *RtRefreshManager) loop() {
 
 	var refreshTickrCh <-chan time.Time
 	if r.enableAutoRefresh {
+		r.refreshStarted()
 		err := r.doRefresh(r.ctx, true)
+		r.refreshFinished(err)
 		if err != nil {
 			logger.Warn("failed when refreshing routing table", err)
 		}
@@ -220,11 +277,13 @@ func (r *RtRefreshManager) loop() {
 		}
 
 		ctx, span := internal.StartSpan(r.ctx, "RefreshManager.Refresh")
+		r.refreshStarted()
 
 		r.pingAndEvictPeers(ctx)
 
 		// Query for self and refresh the required buckets
 		err := r.doRefresh(ctx, forced)
+		r.refreshFinished(err)
 		for _, w := range waiting {
 			w <- err
 			close(w)
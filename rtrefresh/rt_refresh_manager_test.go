@@ -2,6 +2,7 @@ package rtrefresh
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"testing"
 	"time"
@@ -102,3 +103,46 @@ func TestSkipRefreshOnGapCpls(t *testing.T) {
 	}
 	require.Equal(t, 2, rt.NPeersForCpl(10))
 }
+
+func TestState(t *testing.T) {
+	local := test.RandPeerIDFatal(t)
+	rt, err := kb.NewRoutingTable(2, kb.ConvertPeerID(local), time.Hour, pstore.NewMetrics(), 100*time.Hour, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	failing := make(chan struct{})
+	r := &RtRefreshManager{
+		ctx:              ctx,
+		cancel:           cancel,
+		rt:               rt,
+		dhtPeerId:        local,
+		refreshKeyGenFnc: func(cpl uint) (string, error) { return strconv.FormatInt(int64(cpl), 10), nil },
+		refreshQueryFnc: func(ctx context.Context, key string) error {
+			<-failing
+			return errors.New("boom")
+		},
+		refreshQueryTimeout: time.Minute,
+		refreshInterval:     time.Hour,
+		triggerRefresh:      make(chan *triggerRefreshReq),
+		refreshDoneCh:       make(chan struct{}, 1),
+	}
+	r.Start()
+	defer r.Close()
+
+	s := r.State()
+	require.False(t, s.Running)
+	require.Zero(t, s.Refreshes)
+	require.Equal(t, time.Hour, s.Interval)
+
+	done := r.Refresh(true)
+	require.Eventually(t, func() bool { return r.State().Running }, 5*time.Second, time.Millisecond)
+	close(failing)
+	require.Error(t, <-done)
+
+	s = r.State()
+	require.False(t, s.Running)
+	require.Equal(t, 1, s.Refreshes)
+	require.Error(t, s.LastError)
+	require.False(t, s.LastFinishedAt.Before(s.LastStartedAt))
+	require.Contains(t, s.CplLastRefreshedAt, uint(0))
+}
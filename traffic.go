@@ -0,0 +1,198 @@
+package dht
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// Operation names the high level operation DHT messages are exchanged for, so
+// that their traffic can be accounted to it (see TrafficAccounting).
+type Operation string
+
+const (
+	OperationProvide         Operation = "provide"
+	OperationFindProviders   Operation = "find-providers"
+	OperationPutValue        Operation = "put-value"
+	OperationGetValue        Operation = "get-value"
+	OperationFindPeer        Operation = "find-peer"
+	OperationGetClosestPeers Operation = "get-closest-peers"
+	OperationRefresh         Operation = "refresh"
+	// OperationServe is the traffic of the requests sent by remote peers.
+	OperationServe Operation = "serve"
+	// OperationOther is the traffic of the messages sent without operation.
+	OperationOther Operation = "other"
+)
+
+type operationKey struct{}
+
+// ContextWithOperation tags the messages sent with the returned context as
+// sent for op, unless ctx is already tagged. Operations calling each other
+// are accounted to the outermost one, e.g. the lookups run by Provide are
+// accounted to OperationProvide rather than to OperationGetClosestPeers.
+//
+// Callers can tag contexts with their own operations to find how much DHT
+// traffic they cause.
+func ContextWithOperation(ctx context.Context, op Operation) context.Context {
+	if _, ok := ctx.Value(operationKey{}).(Operation); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+func operationFromContext(ctx context.Context) Operation {
+	if op, ok := ctx.Value(operationKey{}).(Operation); ok {
+		return op
+	}
+	return OperationOther
+}
+
+// Traffic counts the DHT messages exchanged with remote peers.
+type Traffic struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+}
+
+// Bytes returns the number of bytes sent and received.
+func (t Traffic) Bytes() uint64 {
+	return t.BytesSent + t.BytesReceived
+}
+
+func (t *Traffic) add(o Traffic) {
+	t.MessagesSent += o.MessagesSent
+	t.MessagesReceived += o.MessagesReceived
+	t.BytesSent += o.BytesSent
+	t.BytesReceived += o.BytesReceived
+}
+
+// PeerTraffic is the traffic exchanged with a remote peer.
+type PeerTraffic struct {
+	Peer peer.ID
+	Traffic
+}
+
+// OperationTraffic is the traffic exchanged for an operation.
+type OperationTraffic struct {
+	Operation Operation
+	Traffic
+}
+
+// trafficLedger accounts the traffic exchanged with each remote peer and for
+// each operation. It is safe for concurrent use.
+type trafficLedger struct {
+	mu sync.Mutex
+	// peers maps the remote peers to their *Traffic, the peers the least
+	// recently exchanged with are forgotten first.
+	peers *lru.LRU
+	ops   map[Operation]*Traffic
+}
+
+func newTrafficLedger(maxPeers int) *trafficLedger {
+	// the size is validated by the TrafficAccounting option
+	peers, _ := lru.NewLRU(maxPeers, nil)
+	return &trafficLedger{peers: peers, ops: make(map[Operation]*Traffic)}
+}
+
+// record accounts t to p and op.
+func (l *trafficLedger) record(p peer.ID, op Operation, t Traffic) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v, ok := l.peers.Get(p); ok {
+		v.(*Traffic).add(t)
+	} else {
+		pt := t
+		l.peers.Add(p, &pt)
+	}
+	if ot, ok := l.ops[op]; ok {
+		ot.add(t)
+	} else {
+		l.ops[op] = &t
+	}
+}
+
+// topPeers returns the n peers with the most bytes exchanged, all of them if n
+// isn't positive.
+func (l *trafficLedger) topPeers(n int) []PeerTraffic {
+	l.mu.Lock()
+	out := make([]PeerTraffic, 0, l.peers.Len())
+	for _, k := range l.peers.Keys() {
+		v, _ := l.peers.Peek(k)
+		out = append(out, PeerTraffic{Peer: k.(peer.ID), Traffic: *v.(*Traffic)})
+	}
+	l.mu.Unlock()
+	slices.SortFunc(out, func(a, b PeerTraffic) int { return cmp.Compare(b.Bytes(), a.Bytes()) })
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
+
+// topOperations returns the n operations with the most bytes exchanged, all of
+// them if n isn't positive.
+func (l *trafficLedger) topOperations(n int) []OperationTraffic {
+	l.mu.Lock()
+	out := make([]OperationTraffic, 0, len(l.ops))
+	for op, t := range l.ops {
+		out = append(out, OperationTraffic{Operation: op, Traffic: *t})
+	}
+	l.mu.Unlock()
+	slices.SortFunc(out, func(a, b OperationTraffic) int { return cmp.Compare(b.Bytes(), a.Bytes()) })
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
+
+// accountingMessageSender accounts the traffic of the messages it sends.
+type accountingMessageSender struct {
+	pb.MessageSender
+	ledger *trafficLedger
+}
+
+func (m *accountingMessageSender) SendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	resp, err := m.MessageSender.SendRequest(ctx, p, pmes)
+	t := Traffic{MessagesSent: 1, BytesSent: uint64(proto.Size(pmes))}
+	if err == nil {
+		t.MessagesReceived, t.BytesReceived = 1, uint64(proto.Size(resp))
+	}
+	m.ledger.record(p, operationFromContext(ctx), t)
+	return resp, err
+}
+
+func (m *accountingMessageSender) SendMessage(ctx context.Context, p peer.ID, pmes *pb.Message) error {
+	err := m.MessageSender.SendMessage(ctx, p, pmes)
+	m.ledger.record(p, operationFromContext(ctx), Traffic{MessagesSent: 1, BytesSent: uint64(proto.Size(pmes))})
+	return err
+}
+
+// TopPeersByTraffic returns up to n remote peers this node exchanged the most
+// bytes with, in decreasing order, along with their traffic. All of them are
+// returned if n isn't positive. Only the most recently active peers are
+// remembered. It returns nil unless traffic accounting is enabled (see
+// TrafficAccounting).
+func (dht *IpfsDHT) TopPeersByTraffic(n int) []PeerTraffic {
+	if dht.traffic == nil {
+		return nil
+	}
+	return dht.traffic.topPeers(n)
+}
+
+// TopOperationsByTraffic returns up to n operations the most bytes were
+// exchanged for, in decreasing order, along with their traffic. All of them are
+// returned if n isn't positive. It returns nil unless traffic accounting is
+// enabled (see TrafficAccounting).
+func (dht *IpfsDHT) TopOperationsByTraffic(n int) []OperationTraffic {
+	if dht.traffic == nil {
+		return nil
+	}
+	return dht.traffic.topOperations(n)
+}
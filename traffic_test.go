@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficAccounting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d1 := setupDHT(ctx, t, false, TrafficAccounting(16))
+	d2 := setupDHT(ctx, t, false, TrafficAccounting(16))
+	connect(t, ctx, d1, d2)
+
+	require.NoError(t, d1.PutValue(ctx, "/v/hello", []byte("world")))
+	_, err := d1.GetClosestPeers(ContextWithOperation(ctx, "my-app"), "foo")
+	require.NoError(t, err)
+
+	ops := make(map[Operation]Traffic)
+	for _, ot := range d1.TopOperationsByTraffic(10) {
+		ops[ot.Operation] = ot.Traffic
+	}
+	require.NotZero(t, ops[OperationPutValue].MessagesSent)
+	require.NotZero(t, ops[OperationPutValue].BytesReceived)
+	// the lookup run by PutValue is accounted to it
+	require.NotContains(t, ops, OperationGetClosestPeers)
+	require.NotZero(t, ops["my-app"].MessagesSent)
+
+	top := d1.TopPeersByTraffic(1)
+	require.Len(t, top, 1)
+	require.Equal(t, d2.self, top[0].Peer)
+
+	// d2 accounts the requests of d1 to serving them
+	var served Traffic
+	for _, ot := range d2.TopOperationsByTraffic(10) {
+		if ot.Operation == OperationServe {
+			served = ot.Traffic
+		}
+	}
+	require.GreaterOrEqual(t, served.MessagesReceived, ops[OperationPutValue].MessagesSent+ops["my-app"].MessagesSent)
+	require.NotZero(t, served.BytesSent)
+
+	require.Nil(t, setupDHT(ctx, t, false).TopPeersByTraffic(1))
+}
+
+func TestTrafficLedger(t *testing.T) {
+	l := newTrafficLedger(2)
+	l.record("a", OperationProvide, Traffic{MessagesSent: 1, BytesSent: 10})
+	l.record("b", OperationGetValue, Traffic{MessagesSent: 1, BytesSent: 30})
+	l.record("a", OperationProvide, Traffic{MessagesReceived: 1, BytesReceived: 5})
+	l.record("c", OperationProvide, Traffic{MessagesSent: 1, BytesSent: 1})
+
+	// b is the least recently active peer
+	require.Equal(t, []PeerTraffic{
+		{Peer: "a", Traffic: Traffic{MessagesSent: 1, MessagesReceived: 1, BytesSent: 10, BytesReceived: 5}},
+		{Peer: "c", Traffic: Traffic{MessagesSent: 1, BytesSent: 1}},
+	}, l.topPeers(3))
+	require.Equal(t, peer.ID("a"), l.topPeers(1)[0].Peer)
+	require.Len(t, l.topPeers(0), 2)
+	require.Len(t, l.topPeers(-1), 2)
+
+	require.Equal(t, []OperationTraffic{
+		{Operation: OperationGetValue, Traffic: Traffic{MessagesSent: 1, BytesSent: 30}},
+		{Operation: OperationProvide, Traffic: Traffic{MessagesSent: 2, MessagesReceived: 1, BytesSent: 11, BytesReceived: 5}},
+	}, l.topOperations(5))
+	require.Len(t, l.topOperations(-1), 2)
+}